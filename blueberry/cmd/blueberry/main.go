@@ -1,8 +1,18 @@
 package main
 
-import "blueberry/internal/server"
+import (
+	"os"
+
+	"blueberry/internal/cli"
+	"blueberry/internal/server"
+)
 
 func main() {
+	//Run the command if one was specified (blueberry rules test)
+	if isCommand, exitCode := cli.Run(os.Args[1:]); isCommand {
+		os.Exit(exitCode)
+	}
+
 	bServer := server.BlueberryServer{}
 	err := bServer.Init()
 	if err != nil {
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"blueberry/internal/config"
+	rules "blueberry/internal/detection/rules"
+	"blueberry/internal/logging"
+)
+
+// The usage message of the rules command
+const rulesUsage = `Usage: blueberry rules <command> [options]
+
+Commands:
+  test    Runs the test cases embedded in the rule files
+  lint    Checks the rule files and reports every problem with its position
+`
+
+// Runs the rules command with the arguments after "rules"
+// @param args - the command line arguments after the rules keyword
+// @param stdout - the writer for the report
+// @param stderr - the writer for the errors and the log messages
+// Returns the exit code of the command
+func RunRulesCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, rulesUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "test":
+		return runRulesTest(args[1:], stdout, stderr)
+	case "lint":
+		return runRulesLint(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "Unknown rules command %s\n\n%s", args[0], rulesUsage)
+		return 2
+	}
+}
+
+// Creates the logger used by the commands, the log messages are written to stderr so they do not mix with the report
+func newCommandLogger(stderr io.Writer) logging.ILogger {
+	logger := logging.NewDefaultLogger()
+	logger.InternalLogger = log.New(stderr, "[BLUEBERRY] - ", log.Ldate|log.Ltime)
+	return logger
+}
+
+// Gets the configuration used by the commands
+// If the configuration file is specified the rules options are taken from it, otherwise the default options are used
+// @param configFile - the path to the configuration file (can be empty)
+// @param rulesDirectory - the rules directory which overrides the one from the configuration (can be empty)
+// Returns the configuration or an error if the configuration file cannot be loaded
+func loadCommandConfiguration(configFile string, rulesDirectory string) (config.Configuration, error) {
+	configuration := config.Configuration{RuleConfig: &config.RuleOptions{MaxDecodingDepth: rules.DefaultMaxDecodingDepth}}
+	if configFile != "" {
+		loaded, err := config.LoadConfigurationFromFile(configFile)
+		if err != nil {
+			return configuration, err
+		}
+		configuration = *loaded
+	}
+
+	if rulesDirectory != "" {
+		configuration.RuleConfig.RulesDirectory = rulesDirectory
+	}
+	if configuration.RuleConfig.RulesDirectory == "" {
+		configuration.RuleConfig.RulesDirectory = "./rules"
+	}
+	return configuration, nil
+}
+
+// Runs the test cases embedded in the rule files and prints a pass/fail report
+// Returns 0 if all the tests passed, 1 if any test failed or the rules could not be loaded
+func runRulesTest(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rules test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "The path to the configuration file (optional, the rules options are taken from it)")
+	rulesDirectory := flags.String("rules", "", "The rules directory (overrides the one from the configuration, default ./rules)")
+	verbose := flags.Bool("v", false, "Print the passed tests as well")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	configuration, err := loadCommandConfiguration(*configFile, *rulesDirectory)
+	if err != nil {
+		fmt.Fprintln(stderr, "Could not load the configuration,", err.Error())
+		return 1
+	}
+
+	logger := newCommandLogger(stderr)
+
+	//Every rule file has to be valid, otherwise the tests of the invalid rules would be silently skipped
+	allRules, err := rules.LoadRulesFromDirectoryStrict(configuration, logger)
+	if err != nil {
+		fmt.Fprintln(stderr, "Could not load the rules from", configuration.RuleConfig.RulesDirectory)
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+
+	results := rules.RunRuleTests(allRules, logger, configuration)
+
+	//Count the rules without tests so the authors know what is not covered
+	untested := 0
+	for _, rule := range allRules {
+		if len(rule.Tests) == 0 {
+			untested++
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Passed {
+			if *verbose {
+				fmt.Fprintf(stdout, "PASS %s / %s\n", result.RuleId, result.TestName)
+			}
+			continue
+		}
+		failed++
+		if result.Error != "" {
+			fmt.Fprintf(stdout, "FAIL %s / %s: %s\n", result.RuleId, result.TestName, result.Error)
+		} else {
+			fmt.Fprintf(stdout, "FAIL %s / %s: expected %s, got %s\n", result.RuleId, result.TestName, result.Expected, result.Actual)
+		}
+	}
+
+	fmt.Fprintf(stdout, "%d tests, %d passed, %d failed (%d rules, %d without tests)\n", len(results), len(results)-failed, failed, len(allRules), untested)
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Holds the report of the lint command in the json format
+type lintReport struct {
+	Diagnostics []rules.LintDiagnostic `json:"diagnostics"` //The problems found in the rule files
+	Errors      int                    `json:"errors"`      //The number of errors
+	Warnings    int                    `json:"warnings"`    //The number of warnings
+}
+
+// Lints the rule files and prints the problems as text or json
+// Returns 0 if no errors were found (the warnings do not fail the command), 1 otherwise
+func runRulesLint(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rules lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "The path to the configuration file (optional, the rules options are taken from it)")
+	rulesDirectory := flags.String("rules", "", "The rules directory (overrides the one from the configuration, default ./rules)")
+	format := flags.String("format", "text", "The output format (text or json)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(stderr, "Unknown format", *format, "allowed values are text, json")
+		return 2
+	}
+
+	configuration, err := loadCommandConfiguration(*configFile, *rulesDirectory)
+	if err != nil {
+		fmt.Fprintln(stderr, "Could not load the configuration,", err.Error())
+		return 1
+	}
+
+	diagnostics, err := rules.LintRulesDirectory(configuration)
+	if err != nil {
+		fmt.Fprintln(stderr, "Could not lint the rules from", configuration.RuleConfig.RulesDirectory+",", err.Error())
+		return 1
+	}
+
+	report := lintReport{Diagnostics: diagnostics}
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == rules.LintError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(stdout, diagnostic.String())
+		}
+		fmt.Fprintf(stdout, "%d errors, %d warnings\n", report.Errors, report.Warnings)
+	}
+
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Runs the command line interface if a command was specified
+// @param args - the command line arguments without the program name
+// Returns if a command was run and its exit code
+func Run(args []string) (bool, int) {
+	if len(args) == 0 || args[0] != "rules" {
+		return false, 0
+	}
+	return true, RunRulesCommand(args[1:], os.Stdout, os.Stderr)
+}
@@ -16,6 +16,30 @@ import (
 // RemoteProtocol - The protocol used for communication by the remote server
 // RemoteAddress - The IPv4 address of the remote service
 // RemotePort - The port the remote service is listening on
+// VerdictMode - How the verdict is taken based on the findings (first_match or anomaly_scoring)
+// InboundAnomalyThreshold - The anomaly score of the incoming data from which it is dropped (only for anomaly_scoring)
+// OutboundAnomalyThreshold - The anomaly score of the outgoing data from which it is dropped (only for anomaly_scoring)
+// RuleConfig - The selection of the rules used by the service and the default action override
+// Rewrites - The rewrites applied in order on the path of the http requests before the base path of the remote URL is added
+// PreserveHost - If the Host header of the client should be sent to the service instead of the host of the remote URL
+// HostHeader - The Host header sent to the service (overrides PreserveHost)
+// TrustedProxies - The IP addresses or CIDR ranges of the proxies in front of the agent whose forwarding headers are kept
+// ForwardedHeaders - The forwarding headers added to the http requests (x_forwarded, forwarded, both or none)
+// BodyInspectionLimit - The number of bytes of the http bodies buffered for the rules, the rest of the body is streamed (default 1 MiB)
+// BodyLimitPolicy - What happens when a body is larger than the inspection limit (inspect_partial, block or pass)
+// StreamReadTimeout - The number of seconds the client can stay idle while its request body is read, the deadline is moved forward with every chunk (default 15)
+// StreamWriteTimeout - The number of seconds a chunk of the response body can take to be sent to the client, the deadline is moved forward with every chunk (default 60)
+// Upstreams - The pool of targets the traffic is balanced between (if not specified the remote URL is the only target)
+// LoadBalancing - How a target of the pool is chosen (round_robin, least_connections or consistent_hash)
+// HealthCheck - The active and passive health checks of the targets
+// DialTimeout - The number of seconds after which connecting to a target fails (default 5)
+// TLSHandshakeTimeout - The number of seconds after which the TLS handshake with a target fails (default 5)
+// ResponseHeaderTimeout - The number of seconds to wait for the headers of the response after the request was sent (default 30)
+// MaxIdleConnections - The number of idle connections kept open to every target (default 100)
+// Retries - The number of times an idempotent request is sent again after a connection error or a gateway error status (default 0)
+// RetryBackoff - The number of milliseconds waited before the first retry, doubled for every retry (default 100)
+// CircuitBreaker - The circuit breaker of every target (if not specified the circuits are always closed)
+// ErrorPages - The pages sent when a request cannot be forwarded to the targets
 type BackendServices struct {
 	Name string `yaml:"name" mapstructure:"name"`
 	//Listen options
@@ -28,6 +52,132 @@ type BackendServices struct {
 	RemoteProtocol string `yaml:"rprotocol" mapstructure:"rprotocol"`
 	RemoteAddress  string `yaml:"raddress" mapstructure:"raddress"`
 	RemotePort     string `yaml:"rport" mapstructure:"rport"`
+
+	//Verdict options
+	VerdictMode              string `yaml:"verdict_mode" mapstructure:"verdict_mode"`
+	InboundAnomalyThreshold  int64  `yaml:"inbound_anomaly_threshold" mapstructure:"inbound_anomaly_threshold"`
+	OutboundAnomalyThreshold int64  `yaml:"outbound_anomaly_threshold" mapstructure:"outbound_anomaly_threshold"`
+
+	//Rules options
+	RuleConfig *ServiceRuleOptions `yaml:"rules" mapstructure:"rules"`
+
+	//Forwarding options
+	Rewrites         []*PathRewrite `yaml:"rewrites" mapstructure:"rewrites"`
+	PreserveHost     bool           `yaml:"preserve_host" mapstructure:"preserve_host"`
+	HostHeader       string         `yaml:"host_header" mapstructure:"host_header"`
+	TrustedProxies   []string       `yaml:"trusted_proxies" mapstructure:"trusted_proxies"`
+	ForwardedHeaders string         `yaml:"forwarded_headers" mapstructure:"forwarded_headers"`
+
+	//Body inspection options
+	BodyInspectionLimit int64  `yaml:"body_inspection_limit" mapstructure:"body_inspection_limit"`
+	BodyLimitPolicy     string `yaml:"body_limit_policy" mapstructure:"body_limit_policy"`
+	StreamReadTimeout   int64  `yaml:"stream_read_timeout" mapstructure:"stream_read_timeout"`
+	StreamWriteTimeout  int64  `yaml:"stream_write_timeout" mapstructure:"stream_write_timeout"`
+
+	//Upstream options
+	Upstreams     []*UpstreamTarget   `yaml:"upstreams" mapstructure:"upstreams"`
+	LoadBalancing string              `yaml:"load_balancing" mapstructure:"load_balancing"`
+	HealthCheck   *HealthCheckOptions `yaml:"health_check" mapstructure:"health_check"`
+
+	//Upstream connection options
+	DialTimeout           int64                  `yaml:"dial_timeout" mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout   int64                  `yaml:"tls_handshake_timeout" mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int64                  `yaml:"response_header_timeout" mapstructure:"response_header_timeout"`
+	MaxIdleConnections    int                    `yaml:"max_idle_connections" mapstructure:"max_idle_connections"`
+	Retries               int64                  `yaml:"retries" mapstructure:"retries"`
+	RetryBackoff          int64                  `yaml:"retry_backoff" mapstructure:"retry_backoff"`
+	CircuitBreaker        *CircuitBreakerOptions `yaml:"circuit_breaker" mapstructure:"circuit_breaker"`
+	ErrorPages            *ErrorPagesOptions     `yaml:"error_pages" mapstructure:"error_pages"`
+}
+
+// Structure that holds a target of the upstream pool of a service
+// @fields
+// URL - The URL of the target (the protocol should be the same for all the targets of the service)
+// Weight - The share of the traffic sent to the target relative to the other targets (default 1)
+type UpstreamTarget struct {
+	URL    string `yaml:"url" mapstructure:"url"`
+	Weight int    `yaml:"weight" mapstructure:"weight"`
+}
+
+// Structure that holds the circuit breaker of the targets of a service
+// The circuit of a target opens after consecutive failures and no traffic is sent to the target while it is open
+// After the open time a single trial request is sent, the circuit closes if it succeeds and opens again if it fails
+// @fields
+// FailureThreshold - The number of consecutive failures after which the circuit opens (default 5)
+// OpenTime - The number of seconds the circuit stays open before the trial request (default 30)
+type CircuitBreakerOptions struct {
+	FailureThreshold int64 `yaml:"failure_threshold" mapstructure:"failure_threshold"`
+	OpenTime         int64 `yaml:"open_time" mapstructure:"open_time"`
+}
+
+// Structure that holds the pages sent to the clients when a request cannot be forwarded
+// @fields
+// BadGateway - The page sent when the target could not be reached or failed to respond (502)
+// ServiceUnavailable - The page sent when no target is available (503)
+// GatewayTimeout - The page sent when the target did not respond in time (504)
+type ErrorPagesOptions struct {
+	BadGateway         string `yaml:"bad_gateway" mapstructure:"bad_gateway"`
+	ServiceUnavailable string `yaml:"service_unavailable" mapstructure:"service_unavailable"`
+	GatewayTimeout     string `yaml:"gateway_timeout" mapstructure:"gateway_timeout"`
+}
+
+// Structure that holds the health checks of the targets of a service
+// The active checks remove a target after consecutive failed checks and restore it after consecutive successful checks
+// The passive checks eject a target for a time after consecutive failed requests or connections
+// @fields
+// Type - The type of the active check (http or tcp, default based on the remote protocol)
+// Path - The path requested by the http checks, a status code lower than 400 is healthy (default /)
+// Interval - The number of seconds between the active checks (0 disables the active checks)
+// Timeout - The number of seconds after which a check fails (default 2)
+// HealthyThreshold - The number of consecutive successful checks after which an unhealthy target is restored (default 2)
+// UnhealthyThreshold - The number of consecutive failed checks after which a target is removed (default 3)
+// MaxFailures - The number of consecutive failed requests after which a target is ejected (0 disables the passive checks)
+// EjectionTime - The number of seconds a target is ejected for (default 30)
+type HealthCheckOptions struct {
+	Type               string `yaml:"type" mapstructure:"type"`
+	Path               string `yaml:"path" mapstructure:"path"`
+	Interval           int64  `yaml:"interval" mapstructure:"interval"`
+	Timeout            int64  `yaml:"timeout" mapstructure:"timeout"`
+	HealthyThreshold   int64  `yaml:"healthy_threshold" mapstructure:"healthy_threshold"`
+	UnhealthyThreshold int64  `yaml:"unhealthy_threshold" mapstructure:"unhealthy_threshold"`
+	MaxFailures        int64  `yaml:"max_failures" mapstructure:"max_failures"`
+	EjectionTime       int64  `yaml:"ejection_time" mapstructure:"ejection_time"`
+}
+
+// Structure that holds a rewrite of the path of the http requests forwarded to a service
+// The prefix is stripped first, then the regex is replaced, the path is kept escaped as it was received
+// @fields
+// StripPrefix - The prefix removed from the path if the path starts with it (like /api)
+// Regex - The regex matched on the path
+// Replacement - The replacement of the regex matches (can reference the groups with $1 or ${name})
+type PathRewrite struct {
+	StripPrefix string `yaml:"strip_prefix" mapstructure:"strip_prefix"`
+	Regex       string `yaml:"regex" mapstructure:"regex"`
+	Replacement string `yaml:"replacement" mapstructure:"replacement"`
+}
+
+// Structure that holds the rules options of a service
+// If no include list is specified all the rules are included, otherwise a rule is included if it matches any of the include lists
+// The excluded directories and tags are applied after the includes and are overridden by the included ids,
+// the excluded ids are always removed
+// @fields
+// IncludeDirectories - The directories (relative to the rules directory) the rules should be taken from
+// ExcludeDirectories - The directories (relative to the rules directory) the rules should not be taken from
+// IncludeTags - The tags of the rules that should be used (case insensitive)
+// ExcludeTags - The tags of the rules that should not be used (case insensitive)
+// IncludeIds - The ids of the rules that should be used
+// ExcludeIds - The ids of the rules that should not be used
+// DefaultAction - The default action for the rules which do not specify one (overrides the one from the rules options)
+// BudgetExceededAction - The action when the evaluation of the rules exceeds its budget (overrides the one from the rules options)
+type ServiceRuleOptions struct {
+	IncludeDirectories   []string `yaml:"include_directories" mapstructure:"include_directories"`
+	ExcludeDirectories   []string `yaml:"exclude_directories" mapstructure:"exclude_directories"`
+	IncludeTags          []string `yaml:"include_tags" mapstructure:"include_tags"`
+	ExcludeTags          []string `yaml:"exclude_tags" mapstructure:"exclude_tags"`
+	IncludeIds           []string `yaml:"include_ids" mapstructure:"include_ids"`
+	ExcludeIds           []string `yaml:"exclude_ids" mapstructure:"exclude_ids"`
+	DefaultAction        string   `yaml:"default_action" mapstructure:"default_action"`
+	BudgetExceededAction string   `yaml:"budget_exceeded_action" mapstructure:"budget_exceeded_action"`
 }
 
 // Structure that holds the rules related options
@@ -35,13 +185,39 @@ type BackendServices struct {
 // RulesDirectory - The directory where rules can be found
 // IgnoreRulesDirectories - The directories with rules that should be ignored when loading the rules
 // DefaultAction - The default actions for rules which do not specify
+// DisableWatcher - If the rules directory should not be watched for changes (the rules can still be reloaded with SIGHUP)
+// MaxDecodingDepth - The maximum number of decodings applied in a chain when searching a value
+// MaxDecodedValues - The maximum number of decoded values searched for a value, the decoding stops when it is reached
+// MaxDecodedBytes - The maximum number of bytes of all the decoded values searched for a value, the decoding stops when it is reached
+// MaxBodyParseSize - The maximum size in bytes of the JSON, XML and multipart bodies parsed into parameters
+// MaxBodyParseDepth - The maximum nesting depth of the JSON and XML bodies parsed into parameters
+// LibraryDirectory - The directory with the wordlists and the macros used by the rules (relative to the rules directory, it is not loaded as rules)
+// EvaluationTimeBudget - The maximum time in milliseconds spent evaluating the rules on a request, response or message, the remaining rules are skipped (negative to disable)
+// BudgetExceededAction - The action when the evaluation of the rules exceeds the time budget or an expression exceeds the memory budget, drop (the default, the data is blocked) or allow (the skipped rules are ignored)
+// SlowRuleThreshold - The 99th percentile of the evaluation time in milliseconds above which a rule is flagged as slow
+// DisableSlowRules - If the rules flagged as slow should not be evaluated anymore for the service they are slow on (until the rules are reloaded)
+// ProfilingAddress - The address of the endpoint which exposes the evaluation statistics of the rules (empty to disable the endpoint)
+// ProfilingReportInterval - The interval in seconds the evaluation statistics of the rules are sent to cranberry
 type RuleOptions struct {
-	RulesDirectory         string   `yaml:"rules_directory" mapstructure:"rules_directory"`
-	IgnoreRulesDirectories []string `yaml:"ignore_rules_directories" mapstructure:"ignore_rules_directories"`
-	DefaultAction          string   `yaml:"default_action" mapstructure:"default_action"`
-	ForbiddenHTTPMessage   string   `yaml:"forbidden_http_message" mapstructure:"forbidden_http_message"`
-	ForbiddenHTTPPath      string   `yaml:"forbidden_http_path" mapstructure:"forbidden_http_path"`
-	ForbiddenTCPMessage    string   `yaml:"forbidden_tcp_message" mapstructure:"forbidden_tcp_message"`
+	RulesDirectory          string   `yaml:"rules_directory" mapstructure:"rules_directory"`
+	IgnoreRulesDirectories  []string `yaml:"ignore_rules_directories" mapstructure:"ignore_rules_directories"`
+	DefaultAction           string   `yaml:"default_action" mapstructure:"default_action"`
+	ForbiddenHTTPMessage    string   `yaml:"forbidden_http_message" mapstructure:"forbidden_http_message"`
+	ForbiddenHTTPPath       string   `yaml:"forbidden_http_path" mapstructure:"forbidden_http_path"`
+	ForbiddenTCPMessage     string   `yaml:"forbidden_tcp_message" mapstructure:"forbidden_tcp_message"`
+	DisableWatcher          bool     `yaml:"disable_watcher" mapstructure:"disable_watcher"`
+	MaxDecodingDepth        int      `yaml:"max_decoding_depth" mapstructure:"max_decoding_depth"`
+	MaxDecodedValues        int      `yaml:"max_decoded_values" mapstructure:"max_decoded_values"`
+	MaxDecodedBytes         int      `yaml:"max_decoded_bytes" mapstructure:"max_decoded_bytes"`
+	MaxBodyParseSize        int64    `yaml:"max_body_parse_size" mapstructure:"max_body_parse_size"`
+	MaxBodyParseDepth       int      `yaml:"max_body_parse_depth" mapstructure:"max_body_parse_depth"`
+	LibraryDirectory        string   `yaml:"library_directory" mapstructure:"library_directory"`
+	EvaluationTimeBudget    int      `yaml:"evaluation_time_budget" mapstructure:"evaluation_time_budget"`
+	BudgetExceededAction    string   `yaml:"budget_exceeded_action" mapstructure:"budget_exceeded_action"`
+	SlowRuleThreshold       float64  `yaml:"slow_rule_threshold" mapstructure:"slow_rule_threshold"`
+	DisableSlowRules        bool     `yaml:"disable_slow_rules" mapstructure:"disable_slow_rules"`
+	ProfilingAddress        string   `yaml:"profiling_address" mapstructure:"profiling_address"`
+	ProfilingReportInterval int      `yaml:"profiling_report_interval" mapstructure:"profiling_report_interval"`
 }
 
 // Structure that holds the ssl options
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -14,6 +15,24 @@ import (
 
 var allowedProtocols []string = []string{"http", "tcp", "https", "tcps"}
 
+// The allowed values for the verdict mode of a service
+var allowedVerdictModes []string = []string{"first_match", "anomaly_scoring"}
+
+// The allowed values for the default action of a service
+var allowedDefaultActions []string = []string{"allow", "drop"}
+
+// The allowed values for the forwarding headers added by a service
+var allowedForwardedHeaders []string = []string{"x_forwarded", "forwarded", "both", "none"}
+
+// The allowed values for the policy applied on the http bodies larger than the inspection limit
+var allowedBodyLimitPolicies []string = []string{"inspect_partial", "block", "pass"}
+
+// The allowed values for the load balancing between the upstream targets of a service
+var allowedLoadBalancing []string = []string{"round_robin", "least_connections", "consistent_hash"}
+
+// The allowed values for the type of the active health checks
+var allowedHealthCheckTypes []string = []string{"http", "tcp"}
+
 // Adds the default values to missing fields in the configuration
 func completeDefaultValues(conf *Configuration) {
 	//For every service check if the remote url is set
@@ -25,6 +44,55 @@ func completeDefaultValues(conf *Configuration) {
 			conf.Services[i].Name = fmt.Sprintf("Service %s", service.ListeningPort)
 		}
 
+		//If the verdict mode is not specified the first matching drop rule drops the data
+		if service.VerdictMode == "" {
+			conf.Services[i].VerdictMode = "first_match"
+		}
+
+		//Set the default anomaly thresholds (the same as the OWASP CRS defaults)
+		if service.InboundAnomalyThreshold <= 0 {
+			conf.Services[i].InboundAnomalyThreshold = 5
+		}
+		if service.OutboundAnomalyThreshold <= 0 {
+			conf.Services[i].OutboundAnomalyThreshold = 4
+		}
+
+		//The X-Forwarded-* and the Forwarded headers are added by default
+		if service.ForwardedHeaders == "" {
+			conf.Services[i].ForwardedHeaders = "both"
+		}
+
+		//The first 1 MiB of the bodies is inspected and the rest of the body is forwarded without being inspected
+		if service.BodyInspectionLimit <= 0 {
+			conf.Services[i].BodyInspectionLimit = 1048576
+		}
+		if service.BodyLimitPolicy == "" {
+			conf.Services[i].BodyLimitPolicy = "inspect_partial"
+		}
+		//The bodies are streamed as long as the client reads or sends a chunk before the timeouts
+		if service.StreamReadTimeout <= 0 {
+			conf.Services[i].StreamReadTimeout = 15
+		}
+		if service.StreamWriteTimeout <= 0 {
+			conf.Services[i].StreamWriteTimeout = 60
+		}
+
+		//If the service does not override the default action it uses the one from the rules options
+		if service.RuleConfig == nil {
+			conf.Services[i].RuleConfig = &ServiceRuleOptions{}
+		}
+		if conf.Services[i].RuleConfig.DefaultAction == "" {
+			conf.Services[i].RuleConfig.DefaultAction = conf.RuleConfig.DefaultAction
+		}
+		if conf.Services[i].RuleConfig.BudgetExceededAction == "" {
+			conf.Services[i].RuleConfig.BudgetExceededAction = conf.RuleConfig.BudgetExceededAction
+		}
+
+		//The remote URL of a service with an upstream pool is the URL of the first target
+		if service.RemoteURL == "" && len(service.Upstreams) > 0 {
+			conf.Services[i].RemoteURL = service.Upstreams[0].URL
+		}
+
 		if service.RemoteURL == "" {
 			conf.Services[i].RemoteURL = fmt.Sprintf("%s://%s:%s", service.RemoteProtocol, service.RemoteAddress, service.RemotePort)
 		}
@@ -36,6 +104,97 @@ func completeDefaultValues(conf *Configuration) {
 			conf.Services[i].RemoteAddress = u.Hostname()
 			conf.Services[i].RemotePort = u.Port()
 		}
+
+		//The targets of the pool get an equal share of the traffic by default
+		for _, target := range service.Upstreams {
+			if target.Weight <= 0 {
+				target.Weight = 1
+			}
+		}
+		if service.LoadBalancing == "" {
+			conf.Services[i].LoadBalancing = "round_robin"
+		}
+
+		//Set the default values of the health checks, the http services are checked with http requests
+		if healthCheck := service.HealthCheck; healthCheck != nil {
+			if healthCheck.Type == "" {
+				healthCheck.Type = "tcp"
+				if conf.Services[i].RemoteProtocol == "http" || conf.Services[i].RemoteProtocol == "https" {
+					healthCheck.Type = "http"
+				}
+			}
+			if healthCheck.Path == "" {
+				healthCheck.Path = "/"
+			}
+			if healthCheck.Timeout <= 0 {
+				healthCheck.Timeout = 2
+			}
+			if healthCheck.HealthyThreshold <= 0 {
+				healthCheck.HealthyThreshold = 2
+			}
+			if healthCheck.UnhealthyThreshold <= 0 {
+				healthCheck.UnhealthyThreshold = 3
+			}
+			if healthCheck.EjectionTime <= 0 {
+				healthCheck.EjectionTime = 30
+			}
+		}
+
+		//Set the default timeouts of the connections to the targets
+		if service.DialTimeout <= 0 {
+			conf.Services[i].DialTimeout = 5
+		}
+		if service.TLSHandshakeTimeout <= 0 {
+			conf.Services[i].TLSHandshakeTimeout = 5
+		}
+		if service.ResponseHeaderTimeout <= 0 {
+			conf.Services[i].ResponseHeaderTimeout = 30
+		}
+		if service.MaxIdleConnections <= 0 {
+			conf.Services[i].MaxIdleConnections = 100
+		}
+		if service.RetryBackoff <= 0 {
+			conf.Services[i].RetryBackoff = 100
+		}
+
+		//Set the default values of the circuit breaker
+		if circuitBreaker := service.CircuitBreaker; circuitBreaker != nil {
+			if circuitBreaker.FailureThreshold <= 0 {
+				circuitBreaker.FailureThreshold = 5
+			}
+			if circuitBreaker.OpenTime <= 0 {
+				circuitBreaker.OpenTime = 30
+			}
+		}
+
+		//Set the default error pages
+		if service.ErrorPages == nil {
+			conf.Services[i].ErrorPages = &ErrorPagesOptions{}
+		}
+		if conf.Services[i].ErrorPages.BadGateway == "" {
+			conf.Services[i].ErrorPages.BadGateway = `
+<html>
+	<h1>Bad Gateway</h1>
+	<p>The service could not be reached or sent an invalid response</p>
+</html>
+		`
+		}
+		if conf.Services[i].ErrorPages.ServiceUnavailable == "" {
+			conf.Services[i].ErrorPages.ServiceUnavailable = `
+<html>
+	<h1>Service Unavailable</h1>
+	<p>The service is temporarily unavailable, try again later</p>
+</html>
+		`
+		}
+		if conf.Services[i].ErrorPages.GatewayTimeout == "" {
+			conf.Services[i].ErrorPages.GatewayTimeout = `
+<html>
+	<h1>Gateway Timeout</h1>
+	<p>The service did not respond in time</p>
+</html>
+		`
+		}
 	}
 
 	//If the default forbidden message is missing for http
@@ -54,6 +213,45 @@ func completeDefaultValues(conf *Configuration) {
 		conf.RuleConfig.ForbiddenTCPMessage = "Forbidden\n"
 	}
 
+	//Set the default limits of the decoding chains
+	if conf.RuleConfig.MaxDecodingDepth <= 0 {
+		conf.RuleConfig.MaxDecodingDepth = 3
+	}
+	if conf.RuleConfig.MaxDecodedValues <= 0 {
+		conf.RuleConfig.MaxDecodedValues = 64
+	}
+	if conf.RuleConfig.MaxDecodedBytes <= 0 {
+		conf.RuleConfig.MaxDecodedBytes = 4194304
+	}
+
+	//Set the default limits of the bodies parsed into parameters
+	if conf.RuleConfig.MaxBodyParseSize <= 0 {
+		conf.RuleConfig.MaxBodyParseSize = 1048576
+	}
+	if conf.RuleConfig.MaxBodyParseDepth <= 0 {
+		conf.RuleConfig.MaxBodyParseDepth = 32
+	}
+
+	//Set the default directory of the wordlists and the macros used by the rules
+	if conf.RuleConfig.LibraryDirectory == "" {
+		conf.RuleConfig.LibraryDirectory = "library"
+	}
+
+	//Set the default limits of the time spent evaluating the rules
+	if conf.RuleConfig.EvaluationTimeBudget == 0 {
+		conf.RuleConfig.EvaluationTimeBudget = 100
+	}
+	//The data is dropped by default (fail closed), a client could otherwise pad the data until the remaining rules are skipped
+	if conf.RuleConfig.BudgetExceededAction == "" {
+		conf.RuleConfig.BudgetExceededAction = "drop"
+	}
+	if conf.RuleConfig.SlowRuleThreshold <= 0 {
+		conf.RuleConfig.SlowRuleThreshold = 10
+	}
+	if conf.RuleConfig.ProfilingReportInterval <= 0 {
+		conf.RuleConfig.ProfilingReportInterval = 60
+	}
+
 	//If the operation mode is not specified then it will be waf
 	if conf.OperationMode == "" {
 		conf.OperationMode = "waf"
@@ -116,6 +314,16 @@ func checkConfiguration(config *Configuration) error {
 		return errors.New("rules configuration is not defined")
 	}
 
+	//Check the action when the evaluation of the rules exceeds its budget
+	if config.RuleConfig.BudgetExceededAction != "" {
+		if slices.Index(allowedDefaultActions, strings.ToLower(config.RuleConfig.BudgetExceededAction)) == -1 {
+			return fmt.Errorf("budget exceeded action invalid, allowed values are %v", allowedDefaultActions)
+		}
+
+		//The budget exceeded action is correct so make it lowercase
+		config.RuleConfig.BudgetExceededAction = strings.ToLower(config.RuleConfig.BudgetExceededAction)
+	}
+
 	//Check if logging config is defined
 	if config.LogConfig == nil {
 		return errors.New("logging is not defined")
@@ -159,9 +367,19 @@ func checkConfiguration(config *Configuration) error {
 			config.Services[i].ListeningProtocol = strings.ToLower(service.ListeningProtocol)
 		}
 
+		//Check the targets of the upstream pool, they replace the remote url
+		for j, target := range service.Upstreams {
+			if target == nil || !isValidURL(target.URL) {
+				return fmt.Errorf("url of upstream %d of service %d is not valid", j, i)
+			}
+			if target.Weight < 0 {
+				return fmt.Errorf("weight of upstream %d of service %d cannot be negative", j, i)
+			}
+		}
+
 		//Check if either the remote url was specified or the combo of remote protocol, remote address and remote port
-		if service.RemoteURL == "" && (service.RemoteAddress == "" || service.RemotePort == "" || service.RemoteProtocol == "") {
-			return fmt.Errorf("either rurl (Remote URL) or raddress, rport and rprotocol must be specified in service %d", i)
+		if len(service.Upstreams) == 0 && service.RemoteURL == "" && (service.RemoteAddress == "" || service.RemotePort == "" || service.RemoteProtocol == "") {
+			return fmt.Errorf("either rurl (Remote URL), upstreams or raddress, rport and rprotocol must be specified in service %d", i)
 		}
 
 		//If the remote url is specified and the other fields are not
@@ -182,6 +400,126 @@ func checkConfiguration(config *Configuration) error {
 			//The protocol is correct so make it lowercase
 			config.Services[i].RemoteProtocol = strings.ToLower(service.RemoteProtocol)
 		}
+
+		//Check the verdict mode
+		if service.VerdictMode != "" {
+			if slices.Index(allowedVerdictModes, strings.ToLower(service.VerdictMode)) == -1 {
+				return fmt.Errorf("verdict mode invalid for service %d, allowed values are %v", i, allowedVerdictModes)
+			}
+
+			//The verdict mode is correct so make it lowercase
+			config.Services[i].VerdictMode = strings.ToLower(service.VerdictMode)
+		}
+
+		//Check the anomaly thresholds
+		if service.InboundAnomalyThreshold < 0 || service.OutboundAnomalyThreshold < 0 {
+			return fmt.Errorf("anomaly thresholds cannot be negative for service %d", i)
+		}
+
+		//Check the default action override
+		if service.RuleConfig != nil && service.RuleConfig.DefaultAction != "" {
+			if slices.Index(allowedDefaultActions, strings.ToLower(service.RuleConfig.DefaultAction)) == -1 {
+				return fmt.Errorf("default action invalid for service %d, allowed values are %v", i, allowedDefaultActions)
+			}
+
+			//The default action is correct so make it lowercase
+			config.Services[i].RuleConfig.DefaultAction = strings.ToLower(service.RuleConfig.DefaultAction)
+		}
+
+		//Check the budget exceeded action override
+		if service.RuleConfig != nil && service.RuleConfig.BudgetExceededAction != "" {
+			if slices.Index(allowedDefaultActions, strings.ToLower(service.RuleConfig.BudgetExceededAction)) == -1 {
+				return fmt.Errorf("budget exceeded action invalid for service %d, allowed values are %v", i, allowedDefaultActions)
+			}
+
+			//The budget exceeded action is correct so make it lowercase
+			config.Services[i].RuleConfig.BudgetExceededAction = strings.ToLower(service.RuleConfig.BudgetExceededAction)
+		}
+
+		//Check the forwarding headers
+		if service.ForwardedHeaders != "" {
+			if slices.Index(allowedForwardedHeaders, strings.ToLower(service.ForwardedHeaders)) == -1 {
+				return fmt.Errorf("forwarded headers invalid for service %d, allowed values are %v", i, allowedForwardedHeaders)
+			}
+
+			//The forwarded headers are correct so make them lowercase
+			config.Services[i].ForwardedHeaders = strings.ToLower(service.ForwardedHeaders)
+		}
+
+		//Check the policy of the bodies larger than the inspection limit
+		if service.BodyLimitPolicy != "" {
+			if slices.Index(allowedBodyLimitPolicies, strings.ToLower(service.BodyLimitPolicy)) == -1 {
+				return fmt.Errorf("body limit policy invalid for service %d, allowed values are %v", i, allowedBodyLimitPolicies)
+			}
+
+			//The body limit policy is correct so make it lowercase
+			config.Services[i].BodyLimitPolicy = strings.ToLower(service.BodyLimitPolicy)
+		}
+		if service.BodyInspectionLimit < 0 {
+			return fmt.Errorf("body inspection limit of service %d cannot be negative", i)
+		}
+		if service.StreamReadTimeout < 0 || service.StreamWriteTimeout < 0 {
+			return fmt.Errorf("stream_read_timeout and stream_write_timeout cannot be negative for service %d", i)
+		}
+
+		//Check the load balancing between the upstream targets
+		if service.LoadBalancing != "" {
+			if slices.Index(allowedLoadBalancing, strings.ToLower(service.LoadBalancing)) == -1 {
+				return fmt.Errorf("load balancing invalid for service %d, allowed values are %v", i, allowedLoadBalancing)
+			}
+
+			//The load balancing is correct so make it lowercase
+			config.Services[i].LoadBalancing = strings.ToLower(service.LoadBalancing)
+		}
+
+		//Check the health checks of the upstream targets
+		if healthCheck := service.HealthCheck; healthCheck != nil {
+			if healthCheck.Type != "" {
+				if slices.Index(allowedHealthCheckTypes, strings.ToLower(healthCheck.Type)) == -1 {
+					return fmt.Errorf("health check type invalid for service %d, allowed values are %v", i, allowedHealthCheckTypes)
+				}
+
+				//The health check type is correct so make it lowercase
+				healthCheck.Type = strings.ToLower(healthCheck.Type)
+			}
+			if healthCheck.Path != "" && !strings.HasPrefix(healthCheck.Path, "/") {
+				return fmt.Errorf("health check path of service %d should start with /", i)
+			}
+			if healthCheck.Interval < 0 || healthCheck.Timeout < 0 || healthCheck.MaxFailures < 0 || healthCheck.EjectionTime < 0 {
+				return fmt.Errorf("health check interval, timeout, max_failures and ejection_time cannot be negative for service %d", i)
+			}
+		}
+
+		//Check the connections to the targets
+		if service.DialTimeout < 0 || service.TLSHandshakeTimeout < 0 || service.ResponseHeaderTimeout < 0 || service.MaxIdleConnections < 0 {
+			return fmt.Errorf("dial_timeout, tls_handshake_timeout, response_header_timeout and max_idle_connections cannot be negative for service %d", i)
+		}
+		if service.Retries < 0 || service.RetryBackoff < 0 {
+			return fmt.Errorf("retries and retry_backoff cannot be negative for service %d", i)
+		}
+		if service.CircuitBreaker != nil && (service.CircuitBreaker.FailureThreshold < 0 || service.CircuitBreaker.OpenTime < 0) {
+			return fmt.Errorf("circuit breaker failure_threshold and open_time cannot be negative for service %d", i)
+		}
+
+		//Check the trusted proxies
+		for _, proxy := range service.TrustedProxies {
+			if _, _, err := net.ParseCIDR(proxy); err != nil && !isValidIPAddress(proxy) {
+				return fmt.Errorf("trusted proxy %s of service %d is not a valid ip address or cidr range", proxy, i)
+			}
+		}
+
+		//Check the path rewrites
+		for j, rewrite := range service.Rewrites {
+			if rewrite == nil || (rewrite.StripPrefix == "" && rewrite.Regex == "") {
+				return fmt.Errorf("rewrite %d of service %d should have strip_prefix or regex", j, i)
+			}
+			if rewrite.StripPrefix != "" && !strings.HasPrefix(rewrite.StripPrefix, "/") {
+				return fmt.Errorf("strip_prefix of rewrite %d of service %d should start with /", j, i)
+			}
+			if _, err := regexp.Compile(rewrite.Regex); err != nil {
+				return fmt.Errorf("regex of rewrite %d of service %d is not valid, %s", j, i, err.Error())
+			}
+		}
 	}
 
 	//Check the operation mode
@@ -80,3 +80,65 @@ func (cc *CranberryClient) SendLog(logData models.LogData) (bool, error) {
 	//The log has been added in the database
 	return true, nil
 }
+
+// Sends the outcome of a rules reload to the API
+func (cc *CranberryClient) SendRulesReload(reloadData models.RulesReloadData) (bool, error) {
+	//Parse the data into a JSON
+	bodyData, err := json.Marshal(reloadData)
+	//Check if an error occured when transforming the reload data into JSON
+	if err != nil {
+		return false, errors.New("could not transform the rules reload data into JSON")
+	}
+
+	//Send the data to the api
+	url := fmt.Sprintf("%s/%s/%s/%s", cc.configuration.CranberryURL, "agents", cc.configuration.UUID, "rules/reloads")
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(bodyData))
+	//Check if an error occured when sending the request to cranberry
+	if err != nil {
+		return false, errors.New("could not send the rules reload to api, " + err.Error())
+	}
+	defer resp.Body.Close()
+	//Check the status code of the response
+	if resp.StatusCode != 200 {
+		apiErr := models.CranberryAPIError{}
+		//Parse the error response from the API
+		err := apiErr.FromJSON(resp.Body)
+		//Check if an error occured when parsing the api error response
+		if err != nil {
+			return false, errors.New("could not parse error message from API, " + err.Error())
+		}
+		return false, errors.New("error on the server, detail:" + apiErr.Detail)
+	}
+	return true, nil
+}
+
+// Sends the evaluation statistics of the rules to the API
+func (cc *CranberryClient) SendRulesProfile(profileData models.RulesProfileData) (bool, error) {
+	//Parse the data into a JSON
+	bodyData, err := json.Marshal(profileData)
+	//Check if an error occured when transforming the profile data into JSON
+	if err != nil {
+		return false, errors.New("could not transform the rules profile data into JSON")
+	}
+
+	//Send the data to the api
+	url := fmt.Sprintf("%s/%s/%s/%s", cc.configuration.CranberryURL, "agents", cc.configuration.UUID, "rules/profiles")
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(bodyData))
+	//Check if an error occured when sending the request to cranberry
+	if err != nil {
+		return false, errors.New("could not send the rules profile to api, " + err.Error())
+	}
+	defer resp.Body.Close()
+	//Check the status code of the response
+	if resp.StatusCode != 200 {
+		apiErr := models.CranberryAPIError{}
+		//Parse the error response from the API
+		err := apiErr.FromJSON(resp.Body)
+		//Check if an error occured when parsing the api error response
+		if err != nil {
+			return false, errors.New("could not parse error message from API, " + err.Error())
+		}
+		return false, errors.New("error on the server, detail:" + apiErr.Detail)
+	}
+	return true, nil
+}
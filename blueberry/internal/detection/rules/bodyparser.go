@@ -0,0 +1,395 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The default maximum size of a body which is parsed into parameters (1 MiB)
+const DefaultMaxBodyParseSize int64 = 1048576
+
+// The default maximum nesting depth of the JSON and XML bodies which is parsed into parameters
+const DefaultMaxBodyParseDepth = 32
+
+// The kinds of structured bodies
+const (
+	bodyKindJSON      = "json"
+	bodyKindXML       = "xml"
+	bodyKindMultipart = "multipart"
+)
+
+// Holds a value extracted from a structured body
+type bodyField struct {
+	kind  string   //The kind of the body the value was extracted from (json, xml or multipart)
+	name  string   //The name of the parameter (the JSON key, the XML element or attribute name, the multipart field name)
+	path  []string //The path of the value in the body used by the selectors
+	value string   //The value
+}
+
+// Holds the values extracted from a structured body
+type parsedBody struct {
+	fields     []bodyField         //The values with their path in the body
+	parameters map[string][]string //The values grouped by the parameter name (used by the params matchers)
+}
+
+// Adds a value to the parsed body
+func (pb *parsedBody) add(kind string, name string, path []string, value string) {
+	pb.fields = append(pb.fields, bodyField{kind: kind, name: name, path: slices.Clone(path), value: value})
+	pb.parameters[name] = append(pb.parameters[name], value)
+}
+
+// Parses the body based on the content type into named parameters
+// JSON leaves are named by their key (the array items by the key of the array), XML elements and attributes by their local name,
+// multipart fields by the field name (the file fields have the file name as value)
+// @param contentType - the Content-Type header of the request
+// @param body - the body of the request
+// @param maxSize - the maximum size of the body which is parsed
+// @param maxDepth - the maximum nesting depth of the JSON and XML bodies
+// Returns the values extracted from the body and an error if the body could not be fully parsed (the values extracted before the error are kept)
+func parseRequestBody(contentType string, body []byte, maxSize int64, maxDepth int) (*parsedBody, error) {
+	parsed := &parsedBody{fields: make([]bodyField, 0), parameters: make(map[string][]string)}
+	if len(body) == 0 || contentType == "" {
+		return parsed, nil
+	}
+
+	mediaType, mediaParams, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return parsed, errors.New("invalid content type, " + err.Error())
+	}
+
+	kind := getBodyKind(mediaType)
+	if kind == "" {
+		return parsed, nil
+	}
+
+	//Do not parse the bodies larger than the limit, they are still searched by the body matchers
+	if int64(len(body)) > maxSize {
+		return parsed, errors.New("body size " + strconv.Itoa(len(body)) + " is larger than the maximum parsed size " + strconv.FormatInt(maxSize, 10))
+	}
+
+	switch kind {
+	case bodyKindJSON:
+		err = parseJSONBody(body, maxDepth, parsed)
+	case bodyKindXML:
+		err = parseXMLBody(body, maxDepth, parsed)
+	case bodyKindMultipart:
+		err = parseMultipartBody(body, mediaParams["boundary"], parsed)
+	}
+	return parsed, err
+}
+
+// Gets the kind of the structured body from the media type (empty if the body is not structured)
+func getBodyKind(mediaType string) string {
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return bodyKindJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return bodyKindXML
+	case mediaType == "multipart/form-data":
+		return bodyKindMultipart
+	default:
+		return ""
+	}
+}
+
+// Parses the JSON body, the tokens are read one by one so the nesting depth is checked before descending
+func parseJSONBody(body []byte, maxDepth int, parsed *parsedBody) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	//Keep the numbers as they were written
+	decoder.UseNumber()
+	return parseJSONValue(decoder, "", make([]string, 0), 0, maxDepth, parsed)
+}
+
+// Parses a JSON value and adds its leaves to the parsed body
+func parseJSONValue(decoder *json.Decoder, name string, path []string, depth int, maxDepth int, parsed *parsedBody) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return errors.New("invalid json body, " + err.Error())
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if depth >= maxDepth {
+			return errors.New("json body is nested deeper than " + strconv.Itoa(maxDepth))
+		}
+		if value == '{' {
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return errors.New("invalid json body, " + err.Error())
+				}
+				key, _ := keyToken.(string)
+				err = parseJSONValue(decoder, key, append(path, key), depth+1, maxDepth, parsed)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			//The items of the array are named by the key of the array
+			for index := 0; decoder.More(); index++ {
+				err = parseJSONValue(decoder, name, append(path, strconv.Itoa(index)), depth+1, maxDepth, parsed)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		//Read the closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return errors.New("invalid json body, " + err.Error())
+		}
+	case string:
+		parsed.add(bodyKindJSON, name, path, value)
+	case json.Number:
+		parsed.add(bodyKindJSON, name, path, value.String())
+	case bool:
+		parsed.add(bodyKindJSON, name, path, strconv.FormatBool(value))
+	}
+	return nil
+}
+
+// Parses the XML body, the text of the elements and the attributes are added to the parsed body
+// The external entities are never resolved, the unknown entities are kept as they are
+func parseXMLBody(body []byte, maxDepth int, parsed *parsedBody) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	path := make([]string, 0)
+	texts := make([]*strings.Builder, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New("invalid xml body, " + err.Error())
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if len(path) >= maxDepth {
+				return errors.New("xml body is nested deeper than " + strconv.Itoa(maxDepth))
+			}
+			path = append(path, element.Name.Local)
+			texts = append(texts, &strings.Builder{})
+			for _, attribute := range element.Attr {
+				//The namespace declarations are not values
+				if attribute.Name.Space == "xmlns" || attribute.Name.Local == "xmlns" {
+					continue
+				}
+				parsed.add(bodyKindXML, attribute.Name.Local, append(path, "@"+attribute.Name.Local), attribute.Value)
+			}
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1].Write(element)
+			}
+		case xml.EndElement:
+			if len(path) == 0 {
+				continue
+			}
+			text := strings.TrimSpace(texts[len(texts)-1].String())
+			if text != "" {
+				parsed.add(bodyKindXML, path[len(path)-1], path, text)
+			}
+			path = path[:len(path)-1]
+			texts = texts[:len(texts)-1]
+		}
+	}
+}
+
+// Parses the multipart body, the file fields have the file name as value (the content of the files is searched by the body matchers)
+func parseMultipartBody(body []byte, boundary string, parsed *parsedBody) error {
+	if boundary == "" {
+		return errors.New("multipart body has no boundary")
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New("invalid multipart body, " + err.Error())
+		}
+
+		name := part.FormName()
+		if fileName := part.FileName(); fileName != "" {
+			parsed.add(bodyKindMultipart, name, []string{name}, fileName)
+			continue
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return errors.New("invalid multipart body, " + err.Error())
+		}
+		parsed.add(bodyKindMultipart, name, []string{name}, string(value))
+	}
+}
+
+// Holds a selector which targets the values of a JSON or XML body
+// The JSONPath selectors start with $ ($.user.name, $.items[*].id, $..password, $['key'])
+// The XPath selectors start with / (/user/name, //password, /user/@id, /*/name)
+type bodySelector struct {
+	kind     string   //The kind of body the selector applies to (json or xml)
+	segments []string //The segments of the path, * matches any segment and ** matches any number of segments
+}
+
+// Compiles a JSONPath or XPath style selector
+// @param selector - the selector from the rule
+// Returns the compiled selector or an error if the selector is not valid or uses unsupported syntax
+func compileBodySelector(selector string) (*bodySelector, error) {
+	switch {
+	case strings.HasPrefix(selector, "$"):
+		return compileJSONSelector(selector)
+	case strings.HasPrefix(selector, "/"):
+		return compileXMLSelector(selector)
+	default:
+		return nil, errors.New("selector " + selector + " should start with $ (JSONPath) or / (XPath)")
+	}
+}
+
+// Compiles a JSONPath selector
+func compileJSONSelector(selector string) (*bodySelector, error) {
+	compiled := &bodySelector{kind: bodyKindJSON, segments: make([]string, 0)}
+	rest := selector[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			compiled.segments = append(compiled.segments, "**")
+			rest = rest[2:]
+			//The recursive descent should be followed by a name or a bracket
+			if rest == "" || rest[0] != '[' {
+				name, remaining := readJSONSelectorName(rest)
+				if name == "" {
+					return nil, errors.New("selector " + selector + " has an empty name after ..")
+				}
+				compiled.segments = append(compiled.segments, name)
+				rest = remaining
+			}
+		case rest[0] == '.':
+			name, remaining := readJSONSelectorName(rest[1:])
+			if name == "" {
+				return nil, errors.New("selector " + selector + " has an empty name after .")
+			}
+			compiled.segments = append(compiled.segments, name)
+			rest = remaining
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, errors.New("selector " + selector + " has an unclosed [")
+			}
+			inside := strings.TrimSpace(rest[1:end])
+			switch {
+			case inside == "*":
+				compiled.segments = append(compiled.segments, "*")
+			case len(inside) >= 2 && (inside[0] == '\'' || inside[0] == '"') && inside[len(inside)-1] == inside[0]:
+				compiled.segments = append(compiled.segments, inside[1:len(inside)-1])
+			default:
+				if _, err := strconv.Atoi(inside); err != nil {
+					return nil, errors.New("selector " + selector + " has an unsupported index [" + inside + "], use a number, * or a quoted key")
+				}
+				compiled.segments = append(compiled.segments, inside)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, errors.New("selector " + selector + " has an unexpected character " + rest[:1])
+		}
+	}
+	return compiled, nil
+}
+
+// Reads a name from the JSONPath selector until the next . or [
+func readJSONSelectorName(rest string) (string, string) {
+	end := strings.IndexAny(rest, ".[")
+	if end == -1 {
+		return rest, ""
+	}
+	return rest[:end], rest[end:]
+}
+
+// Compiles an XPath selector
+func compileXMLSelector(selector string) (*bodySelector, error) {
+	compiled := &bodySelector{kind: bodyKindXML, segments: make([]string, 0)}
+	//The // separator selects the descendants, so it becomes an empty step
+	steps := strings.Split(strings.TrimPrefix(selector, "/"), "/")
+	for i, step := range steps {
+		if step == "" {
+			//The selector ends with / or contains /// which is not valid
+			if i == len(steps)-1 || (i > 0 && steps[i-1] == "") {
+				return nil, errors.New("selector " + selector + " has an empty step")
+			}
+			compiled.segments = append(compiled.segments, "**")
+			continue
+		}
+		if strings.ContainsAny(step, "[]()") {
+			return nil, errors.New("selector " + selector + " uses unsupported predicates or functions in " + step)
+		}
+		//The values are named by the local name, so the namespace prefix is removed
+		attribute := strings.HasPrefix(step, "@")
+		step = strings.TrimPrefix(step, "@")
+		if index := strings.Index(step, ":"); index != -1 {
+			step = step[index+1:]
+		}
+		if attribute {
+			step = "@" + step
+		}
+		if i < len(steps)-1 && attribute {
+			return nil, errors.New("selector " + selector + " can select an attribute only in the last step")
+		}
+		compiled.segments = append(compiled.segments, step)
+	}
+	return compiled, nil
+}
+
+// Checks if the selector targets the field
+func (bs *bodySelector) matches(field bodyField) bool {
+	if field.kind != bs.kind {
+		return false
+	}
+	return bs.matchSegments(bs.segments, field.path)
+}
+
+// Checks if the segments of the selector match the path
+func (bs *bodySelector) matchSegments(segments []string, path []string) bool {
+	if len(segments) == 0 {
+		return len(path) == 0
+	}
+
+	if segments[0] == "**" {
+		//Try to match the rest of the selector at every depth
+		for skip := 0; skip <= len(path); skip++ {
+			if bs.matchSegments(segments[1:], path[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(path) == 0 || !bs.matchSegment(segments[0], path[0]) {
+		return false
+	}
+	return bs.matchSegments(segments[1:], path[1:])
+}
+
+// Checks if a segment of the selector matches a segment of the path
+func (bs *bodySelector) matchSegment(segment string, pathSegment string) bool {
+	if bs.kind == bodyKindXML {
+		//In XPath * selects only the elements and @* only the attributes
+		isAttribute := strings.HasPrefix(pathSegment, "@")
+		switch segment {
+		case "*":
+			return !isAttribute
+		case "@*":
+			return isAttribute
+		}
+		return segment == pathSegment
+	}
+	return segment == "*" || segment == pathSegment
+}
@@ -0,0 +1,157 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompileJSONSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		segments []string
+		invalid  bool
+	}{
+		{selector: "$", segments: []string{}},
+		{selector: "$.user.name", segments: []string{"user", "name"}},
+		{selector: "$.items[*].id", segments: []string{"items", "*", "id"}},
+		{selector: "$.items[0]", segments: []string{"items", "0"}},
+		{selector: "$..password", segments: []string{"**", "password"}},
+		{selector: "$..[0]", segments: []string{"**", "0"}},
+		{selector: "$['user']['first name']", segments: []string{"user", "first name"}},
+		{selector: `$["user"].name`, segments: []string{"user", "name"}},
+		{selector: "$.", invalid: true},
+		{selector: "$..", invalid: true},
+		{selector: "$.user[0", invalid: true},
+		{selector: "$.items[-1:]", invalid: true},
+		{selector: "$.items[?(@.id)]", invalid: true},
+		{selector: "$user", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			compiled, err := compileJSONSelector(test.selector)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("compileJSONSelector(%q) = %v, expected an error", test.selector, compiled.segments)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileJSONSelector(%q) error = %v", test.selector, err)
+			}
+			if !reflect.DeepEqual(compiled.segments, test.segments) {
+				t.Errorf("compileJSONSelector(%q) = %q, expected %q", test.selector, compiled.segments, test.segments)
+			}
+		})
+	}
+}
+
+func TestCompileXMLSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		segments []string
+		invalid  bool
+	}{
+		{selector: "/user/name", segments: []string{"user", "name"}},
+		{selector: "//password", segments: []string{"**", "password"}},
+		{selector: "/user//id", segments: []string{"user", "**", "id"}},
+		{selector: "/user/@id", segments: []string{"user", "@id"}},
+		{selector: "/*/name", segments: []string{"*", "name"}},
+		{selector: "/user/@*", segments: []string{"user", "@*"}},
+		{selector: "/soap:Envelope/soap:Body/@xsi:type", segments: []string{"Envelope", "Body", "@type"}},
+		{selector: "/user/", invalid: true},
+		{selector: "///user", invalid: true},
+		{selector: "/user[1]/name", invalid: true},
+		{selector: "/user/text()", invalid: true},
+		{selector: "/user/@id/name", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			compiled, err := compileXMLSelector(test.selector)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("compileXMLSelector(%q) = %v, expected an error", test.selector, compiled.segments)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileXMLSelector(%q) error = %v", test.selector, err)
+			}
+			if !reflect.DeepEqual(compiled.segments, test.segments) {
+				t.Errorf("compileXMLSelector(%q) = %q, expected %q", test.selector, compiled.segments, test.segments)
+			}
+		})
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		path     []string
+		expected bool
+	}{
+		{name: "json exact path", selector: "$.user.name", path: []string{"user", "name"}, expected: true},
+		{name: "json shorter path", selector: "$.user.name", path: []string{"user"}},
+		{name: "json longer path", selector: "$.user", path: []string{"user", "name"}},
+		{name: "json wildcard", selector: "$.items[*].id", path: []string{"items", "3", "id"}, expected: true},
+		{name: "json index", selector: "$.items[1]", path: []string{"items", "0"}},
+		{name: "json recursive descent at the root", selector: "$..password", path: []string{"password"}, expected: true},
+		{name: "json recursive descent", selector: "$..password", path: []string{"users", "0", "password"}, expected: true},
+		{name: "json recursive descent in the middle", selector: "$.users..id", path: []string{"users", "0", "group", "id"}, expected: true},
+		{name: "json recursive descent on another key", selector: "$..password", path: []string{"password", "hash"}},
+		{name: "xml wildcard element", selector: "/*/name", path: []string{"user", "name"}, expected: true},
+		{name: "xml wildcard on an attribute", selector: "/user/*", path: []string{"user", "@id"}},
+		{name: "xml wildcard attribute", selector: "/user/@*", path: []string{"user", "@id"}, expected: true},
+		{name: "xml wildcard attribute on an element", selector: "/user/@*", path: []string{"user", "name"}},
+		{name: "xml descendants", selector: "//id", path: []string{"users", "user", "id"}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := compileBodySelector(test.selector)
+			if err != nil {
+				t.Fatalf("compileBodySelector(%q) error = %v", test.selector, err)
+			}
+			if result := selector.matchSegments(selector.segments, test.path); result != test.expected {
+				t.Errorf("matchSegments(%q, %q) = %v, expected %v", test.selector, test.path, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseJSONValueDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxDepth int
+		fields   int
+		invalid  bool
+	}{
+		{name: "scalar at the root", body: `"value"`, maxDepth: 0, fields: 1},
+		{name: "object within the depth", body: `{"a":{"b":"c"}}`, maxDepth: 2, fields: 1},
+		{name: "object deeper than the depth", body: `{"a":{"b":"c"}}`, maxDepth: 1, invalid: true},
+		{name: "array within the depth", body: `[[1,2],[3]]`, maxDepth: 2, fields: 3},
+		{name: "array deeper than the depth", body: `[[[1]]]`, maxDepth: 2, invalid: true},
+		{name: "values kept before the depth error", body: `{"a":"b","c":[[1]]}`, maxDepth: 2, fields: 1, invalid: true},
+		{name: "truncated body", body: `{"a":`, maxDepth: 2, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed := &parsedBody{fields: make([]bodyField, 0), parameters: make(map[string][]string)}
+			decoder := json.NewDecoder(bytes.NewReader([]byte(test.body)))
+			decoder.UseNumber()
+			err := parseJSONValue(decoder, "", make([]string, 0), 0, test.maxDepth, parsed)
+			if (err != nil) != test.invalid {
+				t.Errorf("parseJSONValue(%s) error = %v, expected an error %v", test.body, err, test.invalid)
+			}
+			if len(parsed.fields) != test.fields {
+				t.Errorf("parseJSONValue(%s) extracted %d values, expected %d", test.body, len(parsed.fields), test.fields)
+			}
+		})
+	}
+}
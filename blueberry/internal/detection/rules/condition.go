@@ -0,0 +1,304 @@
+package detection
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Holds the boolean condition which combines the named matchers of a rule
+// Every operator which is specified has to be true for the condition to be true
+// all - every operand has to be true
+// any - at least one operand has to be true
+// none - no operand can be true
+type RuleCondition struct {
+	All  []*RuleConditionOperand `yaml:"all"`  //The operands which all have to be true
+	Any  []*RuleConditionOperand `yaml:"any"`  //The operands from which at least one has to be true
+	None []*RuleConditionOperand `yaml:"none"` //The operands which all have to be false
+}
+
+// Holds an operand of a condition which is either the id of a matcher or a nested condition
+type RuleConditionOperand struct {
+	Matcher   string         //The id of the matcher (empty if the operand is a nested condition)
+	Condition *RuleCondition //The nested condition (nil if the operand is a matcher id)
+}
+
+// Parses the operand which can be a string (the matcher id) or a nested condition
+func (rco *RuleConditionOperand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var matcher string
+	if err := unmarshal(&matcher); err == nil {
+		rco.Matcher = matcher
+		return nil
+	}
+
+	condition := &RuleCondition{}
+	if err := unmarshal(condition); err != nil {
+		return errors.New("condition operand should be a matcher id or a nested condition")
+	}
+	rco.Condition = condition
+	return nil
+}
+
+// Checks if the condition is valid
+// @param condition - the condition to be checked
+// @param matcherIds - the ids of the matchers defined in the rule
+// Returns an error if the condition is empty or uses a matcher which is not defined
+func CheckCondition(condition *RuleCondition, matcherIds map[string]bool) error {
+	if len(condition.All) == 0 && len(condition.Any) == 0 && len(condition.None) == 0 {
+		return errors.New("condition should contain at least one of all, any or none")
+	}
+
+	for _, operands := range [][]*RuleConditionOperand{condition.All, condition.Any, condition.None} {
+		for _, operand := range operands {
+			//Check the nested condition
+			if operand.Condition != nil {
+				if err := CheckCondition(operand.Condition, matcherIds); err != nil {
+					return err
+				}
+				continue
+			}
+			if operand.Matcher == "" {
+				return errors.New("condition operand cannot be empty")
+			}
+			if !matcherIds[operand.Matcher] {
+				return errors.New("condition uses undefined matcher, " + operand.Matcher)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Holds a matcher of the rule which can be used in the condition
+type conditionMatcher struct {
+	id    string //The id of the matcher (empty if not specified)
+	field string //The field of the rule the matcher belongs to
+}
+
+// Gets the request matchers of the rule which can be used in the condition
+func requestConditionMatchers(rule Rule) []conditionMatcher {
+	matchers := make([]conditionMatcher, 0)
+	if rule.Request == nil {
+		return matchers
+	}
+	if rule.Request.Method != nil {
+		matchers = append(matchers, conditionMatcher{id: rule.Request.Method.Id, field: "method"})
+	}
+	for _, urlRule := range rule.Request.URL {
+		matchers = append(matchers, conditionMatcher{id: urlRule.Id, field: "url"})
+	}
+	for _, header := range rule.Request.Headers {
+		matchers = append(matchers, conditionMatcher{id: header.Id, field: "header"})
+	}
+	for _, parameter := range rule.Request.Parameters {
+		matchers = append(matchers, conditionMatcher{id: parameter.Id, field: "parameter"})
+	}
+	for _, bodyRule := range rule.Request.Body {
+		matchers = append(matchers, conditionMatcher{id: bodyRule.Id, field: "body"})
+	}
+	for _, target := range rule.Request.Targets() {
+		for _, targetRule := range target.Matchers {
+			matchers = append(matchers, conditionMatcher{id: targetRule.Id, field: target.Name})
+		}
+	}
+	for _, cookie := range rule.Request.Cookies {
+		matchers = append(matchers, conditionMatcher{id: cookie.Id, field: "cookie"})
+	}
+	return matchers
+}
+
+// Gets the response matchers of the rule which can be used in the condition
+func responseConditionMatchers(rule Rule) []conditionMatcher {
+	matchers := make([]conditionMatcher, 0)
+	if rule.Response == nil {
+		return matchers
+	}
+	if rule.Response.Code != nil {
+		matchers = append(matchers, conditionMatcher{id: rule.Response.Code.Id, field: "code"})
+	}
+	for _, header := range rule.Response.Headers {
+		matchers = append(matchers, conditionMatcher{id: header.Id, field: "header"})
+	}
+	for _, bodyRule := range rule.Response.Body {
+		matchers = append(matchers, conditionMatcher{id: bodyRule.Id, field: "body"})
+	}
+	if rule.Response.Latency != nil {
+		matchers = append(matchers, conditionMatcher{id: rule.Response.Latency.Id, field: "latency"})
+	}
+	if rule.Response.TTFB != nil {
+		matchers = append(matchers, conditionMatcher{id: rule.Response.TTFB.Id, field: "ttfb"})
+	}
+	return matchers
+}
+
+// Checks the condition of the rule against the request and response matchers of the rule
+// When the condition is specified every request and response matcher should have an unique id
+// and the condition can use either the request matchers or the response matchers
+// @param rule - the rule to be checked
+// Returns an error if the condition is not valid
+func CheckRuleCondition(rule Rule) error {
+	if rule.Condition == nil {
+		return nil
+	}
+
+	requestIds := make(map[string]bool)
+	responseIds := make(map[string]bool)
+	//Adds the id of the matcher to the set, checking it is specified and unique
+	addIds := func(ids map[string]bool, matchers []conditionMatcher) error {
+		for _, matcher := range matchers {
+			if matcher.id == "" {
+				return errors.New("every " + matcher.field + " matcher should have an id when condition is specified")
+			}
+			if requestIds[matcher.id] || responseIds[matcher.id] {
+				return errors.New("duplicate matcher id, " + matcher.id)
+			}
+			ids[matcher.id] = true
+		}
+		return nil
+	}
+	if err := addIds(requestIds, requestConditionMatchers(rule)); err != nil {
+		return err
+	}
+	if err := addIds(responseIds, responseConditionMatchers(rule)); err != nil {
+		return err
+	}
+
+	//The condition is evaluated on a single message so it cannot combine request and response matchers,
+	//except for the transaction rules which are evaluated on the request and the response together
+	allIds := make(map[string]bool)
+	for id := range requestIds {
+		allIds[id] = true
+	}
+	for id := range responseIds {
+		allIds[id] = true
+	}
+	if err := CheckCondition(rule.Condition, allIds); err != nil {
+		return err
+	}
+	usesRequest, usesResponse := false, false
+	for _, id := range rule.Condition.MatcherIds() {
+		usesRequest = usesRequest || requestIds[id]
+		usesResponse = usesResponse || responseIds[id]
+	}
+	if usesRequest && usesResponse && !rule.Transaction {
+		return errors.New("condition cannot combine request and response matchers")
+	}
+
+	return nil
+}
+
+// Checks if the condition of the rule uses the response matchers, so it is evaluated on the response instead of the request
+// The matchers of the other phase are never run, so the condition is evaluated only on the phase of its matchers
+// @param rule - the rule with the condition
+func conditionOnResponse(rule Rule) bool {
+	if rule.Condition == nil {
+		return false
+	}
+	ids := rule.Condition.MatcherIds()
+	for _, matcher := range responseConditionMatchers(rule) {
+		if slices.Contains(ids, matcher.id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the ids of all the matchers used in the condition
+func (rc *RuleCondition) MatcherIds() []string {
+	ids := make([]string, 0)
+	for _, operands := range [][]*RuleConditionOperand{rc.All, rc.Any, rc.None} {
+		for _, operand := range operands {
+			if operand.Condition != nil {
+				ids = append(ids, operand.Condition.MatcherIds()...)
+				continue
+			}
+			ids = append(ids, operand.Matcher)
+		}
+	}
+	return ids
+}
+
+// Evaluates the condition based on the matchers which matched
+// @param matched - the ids of the matchers which found at least one match
+// Returns if the condition is true and the ids of the matchers which made it true (the matchers under none are never included)
+func (rc *RuleCondition) Evaluate(matched map[string]bool) (bool, map[string]bool) {
+	contributing := make(map[string]bool)
+
+	//Every operand from all has to be true
+	for _, operand := range rc.All {
+		result, ids := operand.evaluate(matched)
+		if !result {
+			return false, nil
+		}
+		for id := range ids {
+			contributing[id] = true
+		}
+	}
+
+	//At least one operand from any has to be true
+	if len(rc.Any) > 0 {
+		anyResult := false
+		for _, operand := range rc.Any {
+			result, ids := operand.evaluate(matched)
+			if !result {
+				continue
+			}
+			anyResult = true
+			for id := range ids {
+				contributing[id] = true
+			}
+		}
+		if !anyResult {
+			return false, nil
+		}
+	}
+
+	//No operand from none can be true
+	for _, operand := range rc.None {
+		if result, _ := operand.evaluate(matched); result {
+			return false, nil
+		}
+	}
+
+	return true, contributing
+}
+
+// Evaluates a single operand of the condition
+func (rco *RuleConditionOperand) evaluate(matched map[string]bool) (bool, map[string]bool) {
+	if rco.Condition != nil {
+		return rco.Condition.Evaluate(matched)
+	}
+	if matched[rco.Matcher] {
+		return true, map[string]bool{rco.Matcher: true}
+	}
+	return false, nil
+}
+
+// Gets the condition as text, like all(a, b) any(c) none(d), used as the matched string of the condition findings
+func (rc *RuleCondition) String() string {
+	parts := make([]string, 0, 3)
+	for _, group := range []struct {
+		name     string
+		operands []*RuleConditionOperand
+	}{{"all", rc.All}, {"any", rc.Any}, {"none", rc.None}} {
+		if len(group.operands) == 0 {
+			continue
+		}
+		operands := make([]string, 0, len(group.operands))
+		for _, operand := range group.operands {
+			if operand.Condition != nil {
+				operands = append(operands, "("+operand.Condition.String()+")")
+				continue
+			}
+			operands = append(operands, operand.Matcher)
+		}
+		parts = append(parts, group.name+"("+strings.Join(operands, ", ")+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Creates the match of a condition which is true without any matcher contributing to it (like a condition with only none operands)
+// The match is not part of the raw data so it is not located
+func newConditionMatch(condition *RuleCondition) searchMatch {
+	return searchMatch{matchedString: condition.String(), decodingChain: []string{}, unlocated: true, target: evidenceTargetCondition}
+}
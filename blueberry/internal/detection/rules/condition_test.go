@@ -0,0 +1,181 @@
+package detection
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Creates the operands of the matchers with the ids
+func matcherOperands(ids ...string) []*RuleConditionOperand {
+	operands := make([]*RuleConditionOperand, 0, len(ids))
+	for _, id := range ids {
+		operands = append(operands, &RuleConditionOperand{Matcher: id})
+	}
+	return operands
+}
+
+// Creates the set of the ids
+func idSet(ids ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func TestRuleConditionEvaluate(t *testing.T) {
+	nested := &RuleCondition{Any: matcherOperands("c", "d")}
+	tests := []struct {
+		name         string
+		condition    *RuleCondition
+		matched      map[string]bool
+		expected     bool
+		contributing map[string]bool
+	}{
+		{
+			name:         "all true",
+			condition:    &RuleCondition{All: matcherOperands("a", "b")},
+			matched:      idSet("a", "b"),
+			expected:     true,
+			contributing: idSet("a", "b"),
+		},
+		{
+			name:      "all with a missing matcher",
+			condition: &RuleCondition{All: matcherOperands("a", "b")},
+			matched:   idSet("a"),
+		},
+		{
+			name:         "any keeps every true operand",
+			condition:    &RuleCondition{Any: matcherOperands("a", "b", "c")},
+			matched:      idSet("a", "c"),
+			expected:     true,
+			contributing: idSet("a", "c"),
+		},
+		{
+			name:      "any without a true operand",
+			condition: &RuleCondition{Any: matcherOperands("a", "b")},
+			matched:   idSet("c"),
+		},
+		{
+			name:      "none with a true operand",
+			condition: &RuleCondition{All: matcherOperands("a"), None: matcherOperands("b")},
+			matched:   idSet("a", "b"),
+		},
+		{
+			name:         "none operands do not contribute",
+			condition:    &RuleCondition{All: matcherOperands("a"), None: matcherOperands("b")},
+			matched:      idSet("a"),
+			expected:     true,
+			contributing: idSet("a"),
+		},
+		{
+			name:         "only none operands",
+			condition:    &RuleCondition{None: matcherOperands("a")},
+			matched:      idSet(),
+			expected:     true,
+			contributing: idSet(),
+		},
+		{
+			name:         "nested condition",
+			condition:    &RuleCondition{All: []*RuleConditionOperand{{Matcher: "a"}, {Condition: nested}}},
+			matched:      idSet("a", "d"),
+			expected:     true,
+			contributing: idSet("a", "d"),
+		},
+		{
+			name:      "false nested condition",
+			condition: &RuleCondition{All: []*RuleConditionOperand{{Matcher: "a"}, {Condition: nested}}},
+			matched:   idSet("a", "b"),
+		},
+		{
+			name:      "nested condition under none",
+			condition: &RuleCondition{All: matcherOperands("a"), None: []*RuleConditionOperand{{Condition: nested}}},
+			matched:   idSet("a", "c"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, contributing := test.condition.Evaluate(test.matched)
+			if result != test.expected {
+				t.Errorf("Evaluate() = %v, expected %v", result, test.expected)
+			}
+			if !reflect.DeepEqual(contributing, test.contributing) {
+				t.Errorf("Evaluate() contributing = %v, expected %v", contributing, test.contributing)
+			}
+		})
+	}
+}
+
+func TestRuleConditionString(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition *RuleCondition
+		expected  string
+	}{
+		{
+			name:      "every operator",
+			condition: &RuleCondition{All: matcherOperands("a", "b"), Any: matcherOperands("c"), None: matcherOperands("d")},
+			expected:  "all(a, b) any(c) none(d)",
+		},
+		{
+			name:      "nested condition",
+			condition: &RuleCondition{None: []*RuleConditionOperand{{Matcher: "a"}, {Condition: &RuleCondition{Any: matcherOperands("b", "c")}}}},
+			expected:  "none(a, (any(b, c)))",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.condition.String(); result != test.expected {
+				t.Errorf("String() = %q, expected %q", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestConditionOnResponse(t *testing.T) {
+	request := &RequestRule{URL: []*RuleSearchMode{{Id: "admin", Match: "/admin"}}}
+	response := &ResponseRule{Code: &RuleSearchMode{Id: "ok", Match: "200"}, Body: []*BodyRule{{Id: "secret", Match: "password"}}}
+	tests := []struct {
+		name     string
+		rule     Rule
+		expected bool
+	}{
+		{
+			name: "no condition",
+			rule: Rule{Request: request, Response: response},
+		},
+		{
+			name: "request matchers",
+			rule: Rule{Request: request, Response: response, Condition: &RuleCondition{All: matcherOperands("admin")}},
+		},
+		{
+			name: "only none request matchers",
+			rule: Rule{Request: request, Response: response, Condition: &RuleCondition{None: matcherOperands("admin")}},
+		},
+		{
+			name:     "response matchers",
+			rule:     Rule{Request: request, Response: response, Condition: &RuleCondition{All: matcherOperands("ok", "secret")}},
+			expected: true,
+		},
+		{
+			name:     "only none response matchers",
+			rule:     Rule{Request: request, Response: response, Condition: &RuleCondition{None: matcherOperands("secret")}},
+			expected: true,
+		},
+		{
+			name:     "nested response matchers",
+			rule:     Rule{Request: request, Response: response, Condition: &RuleCondition{Any: []*RuleConditionOperand{{Condition: &RuleCondition{None: matcherOperands("secret")}}}}},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := conditionOnResponse(test.rule); result != test.expected {
+				t.Errorf("conditionOnResponse() = %v, expected %v", result, test.expected)
+			}
+		})
+	}
+}
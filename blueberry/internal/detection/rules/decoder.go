@@ -0,0 +1,90 @@
+package detection
+
+import (
+	"errors"
+	"strings"
+)
+
+// The limits of the decoding chains when they are not specified in the configuration
+const (
+	DefaultMaxDecodingDepth = 3       //The maximum number of decodings applied in a chain
+	DefaultMaxDecodedValues = 64      //The maximum number of decoded values of a value
+	DefaultMaxDecodedBytes  = 4194304 //The maximum number of bytes of all the decoded values of a value
+)
+
+// Holds the limits of the decoding chains explored for a value
+type decodingLimits struct {
+	maxDepth  int //The maximum number of decodings in a chain
+	maxValues int //The maximum number of decoded values (the original value is not counted)
+	maxBytes  int //The maximum number of bytes of all the decoded values (the original value is not counted)
+}
+
+// Holds a value obtained by decoding the original value and the decodings applied to get it
+type decodedValue struct {
+	value         string   //The decoded value
+	decodingChain []string //The decodings applied on the original value, in order (empty for the original value)
+}
+
+// Decodes the value using a single encoding
+// @param encoding - the name of the transformation to apply
+// @param value - the value to be decoded
+// Returns the decoded value or an error if the value is not valid for the encoding
+func decodeValue(encoding string, value string) (string, error) {
+	transformation, ok := GetTransformation(encoding)
+	if !ok {
+		return "", errors.New("unsupported encoding, " + encoding)
+	}
+	return transformation(value)
+}
+
+// Explores the decoding chains of the value breadth first
+// Every decoded value is kept only once (the one with the shortest chain) and a chain stops at the first decoding which fails,
+// so the results never contain partially decoded values
+// The exploration stops when the number of decoded values or their total size reaches the limits, so the shortest chains are always kept
+// @param value - the value to be decoded
+// @param encodings - the encodings which can be used in the chains (an encoding can appear multiple times in a chain)
+// @param limits - the limits of the decoding chains
+// Returns the original value followed by the distinct decoded values and if the exploration was stopped by the limits
+func decodeChains(value string, encodings []string, limits decodingLimits) ([]decodedValue, bool) {
+	//The first element in the list is the unmodified value
+	results := []decodedValue{{value: value, decodingChain: []string{}}}
+	if len(encodings) == 0 || limits.maxDepth <= 0 {
+		return results, false
+	}
+
+	//The values which were already reached, they are not decoded again
+	seen := map[string]bool{value: true}
+	frontier := results
+	decodedBytes := 0
+	for depth := 0; depth < limits.maxDepth && len(frontier) > 0; depth++ {
+		next := make([]decodedValue, 0)
+		for _, current := range frontier {
+			for _, encoding := range encodings {
+				decoded, err := decodeValue(strings.ToLower(encoding), current.value)
+				//The chain stops when the value cannot be decoded
+				if err != nil {
+					continue
+				}
+				//Skip the values which were already found with a shorter (or equal) chain
+				if seen[decoded] {
+					continue
+				}
+				//Stop when the decoded values would exceed the limits
+				if len(results)+len(next) > limits.maxValues || decodedBytes+len(decoded) > limits.maxBytes {
+					return append(results, next...), true
+				}
+				seen[decoded] = true
+				decodedBytes += len(decoded)
+
+				chain := make([]string, 0, len(current.decodingChain)+1)
+				chain = append(chain, current.decodingChain...)
+				chain = append(chain, strings.ToLower(encoding))
+				next = append(next, decodedValue{value: decoded, decodingChain: chain})
+			}
+		}
+		results = append(results, next...)
+		frontier = next
+	}
+
+	return results, false
+}
@@ -0,0 +1,105 @@
+package detection
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeChains(t *testing.T) {
+	defaultLimits := decodingLimits{maxDepth: DefaultMaxDecodingDepth, maxValues: DefaultMaxDecodedValues, maxBytes: DefaultMaxDecodedBytes}
+	tests := []struct {
+		name      string
+		value     string
+		encodings []string
+		limits    decodingLimits
+		expected  []decodedValue
+		truncated bool
+	}{
+		{
+			name:     "no encodings",
+			value:    "JTNDc2NyaXB0JTNF",
+			limits:   defaultLimits,
+			expected: []decodedValue{{value: "JTNDc2NyaXB0JTNF", decodingChain: []string{}}},
+		},
+		{
+			name:      "zero depth",
+			value:     "JTNDc2NyaXB0JTNF",
+			encodings: []string{"base64"},
+			limits:    decodingLimits{maxDepth: 0, maxValues: DefaultMaxDecodedValues, maxBytes: DefaultMaxDecodedBytes},
+			expected:  []decodedValue{{value: "JTNDc2NyaXB0JTNF", decodingChain: []string{}}},
+		},
+		{
+			name:      "chain of two encodings",
+			value:     "JTNDc2NyaXB0JTNF",
+			encodings: []string{"url", "BASE64"},
+			limits:    defaultLimits,
+			expected: []decodedValue{
+				{value: "JTNDc2NyaXB0JTNF", decodingChain: []string{}},
+				{value: "%3Cscript%3E", decodingChain: []string{"base64"}},
+				{value: "<script>", decodingChain: []string{"base64", "url"}},
+			},
+		},
+		{
+			name:      "same encoding repeated",
+			value:     "UEdFKw==",
+			encodings: []string{"base64"},
+			limits:    defaultLimits,
+			expected: []decodedValue{
+				{value: "UEdFKw==", decodingChain: []string{}},
+				{value: "PGE+", decodingChain: []string{"base64"}},
+				{value: "<a>", decodingChain: []string{"base64", "base64"}},
+			},
+		},
+		{
+			name:      "depth limit",
+			value:     "UEdFKw==",
+			encodings: []string{"base64"},
+			limits:    decodingLimits{maxDepth: 1, maxValues: DefaultMaxDecodedValues, maxBytes: DefaultMaxDecodedBytes},
+			expected: []decodedValue{
+				{value: "UEdFKw==", decodingChain: []string{}},
+				{value: "PGE+", decodingChain: []string{"base64"}},
+			},
+		},
+		{
+			name:      "invalid value stops the chain",
+			value:     "not base64!",
+			encodings: []string{"base64"},
+			limits:    defaultLimits,
+			expected:  []decodedValue{{value: "not base64!", decodingChain: []string{}}},
+		},
+		{
+			name:      "values limit",
+			value:     "UEdFKw==",
+			encodings: []string{"base64"},
+			limits:    decodingLimits{maxDepth: DefaultMaxDecodingDepth, maxValues: 1, maxBytes: DefaultMaxDecodedBytes},
+			expected: []decodedValue{
+				{value: "UEdFKw==", decodingChain: []string{}},
+				{value: "PGE+", decodingChain: []string{"base64"}},
+			},
+			truncated: true,
+		},
+		{
+			name:      "bytes limit",
+			value:     "UEdFKw==",
+			encodings: []string{"base64"},
+			limits:    decodingLimits{maxDepth: DefaultMaxDecodingDepth, maxValues: DefaultMaxDecodedValues, maxBytes: 5},
+			expected: []decodedValue{
+				{value: "UEdFKw==", decodingChain: []string{}},
+				{value: "PGE+", decodingChain: []string{"base64"}},
+			},
+			truncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, truncated := decodeChains(test.value, test.encodings, test.limits)
+			if !reflect.DeepEqual(results, test.expected) {
+				t.Errorf("decodeChains() = %v, expected %v", results, test.expected)
+			}
+			if truncated != test.truncated {
+				t.Errorf("decodeChains() truncated = %v, expected %v", truncated, test.truncated)
+			}
+		})
+	}
+}
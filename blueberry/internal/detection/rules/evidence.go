@@ -0,0 +1,267 @@
+package detection
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"blueberry/internal/models"
+)
+
+// The maximum length of the original snippet kept as evidence of a finding
+const MaxEvidenceLength = 256
+
+// The targets of the matches which are not part of a named field
+const (
+	evidenceTargetMethod     = "method"
+	evidenceTargetURL        = "url"
+	evidenceTargetStatus     = "status"
+	evidenceTargetLatency    = "latency"
+	evidenceTargetTTFB       = "ttfb"
+	evidenceTargetBody       = "body"
+	evidenceTargetExpression = "expr"
+	evidenceTargetCondition  = "condition"
+	evidenceTargetWebsocket  = "websocket"
+	evidenceTargetTCP        = "tcp"
+)
+
+// The prefixes of the targets of the matches found on a named field
+const (
+	evidenceHeaderPrefix = "header:"
+	evidenceCookiePrefix = "cookie:"
+	evidenceParamPrefix  = "param:"
+	evidenceBodyPrefix   = "body."
+)
+
+// The name=value pairs of a query string or of an urlencoded body
+var encodedParameterRegex = regexp.MustCompile(`[^?&;\s]+`)
+
+// Holds the value a finding was found in, used to locate the evidence of the finding when the evidence is repeated in the raw data
+type evidenceContext struct {
+	value  string //The original value which was searched
+	offset int    //The offset of the evidence in the value
+}
+
+// Holds a region of the raw message where the evidence of a target can be found
+type evidenceRegion struct {
+	start int //The offset of the first byte of the region
+	end   int //The offset after the last byte of the region
+}
+
+// Finds the substring in the string ignoring the case of the ASCII letters
+// Returns the byte offset of the first occurrence or -1 if it was not found
+func indexFold(s string, substr string) int {
+	if index := strings.Index(s, substr); index != -1 {
+		return index
+	}
+	//The offsets are kept when lowercasing does not change the length of the strings
+	lowerS, lowerSubstr := strings.ToLower(s), strings.ToLower(substr)
+	if len(lowerS) == len(s) && len(lowerSubstr) == len(substr) {
+		return strings.Index(lowerS, lowerSubstr)
+	}
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Cuts the original snippet of the match from the searched value
+// @param value - the original (not decoded) value which was searched
+// @param start - the offset of the match in the value
+// @param end - the offset after the match in the value
+func evidenceSnippet(value string, start int, end int) string {
+	if start < 0 || end > len(value) || start > end {
+		start, end = 0, len(value)
+	}
+	return value[start:min(end, start+MaxEvidenceLength)]
+}
+
+// Sets the target the matches were found on
+// @param matches - the matches found on the value of the target
+// @param target - the target the value was taken from (like header:User-Agent)
+func withTarget(matches []searchMatch, target string) []searchMatch {
+	for i := range matches {
+		matches[i].target = target
+	}
+	return matches
+}
+
+// Creates the match found on the value with the original snippet of the match as evidence
+// The matches found on a decoded value keep the whole original value as evidence, since the decodings do not keep the offsets
+// @param matcherId - the id of the matcher which found the match
+// @param matchedString - the string on which the rule matched
+// @param value - the original value which was searched
+// @param decoded - the decoded value the match was found on
+// @param start - the offset of the match in the decoded value (-1 if it is not known)
+// @param end - the offset after the match in the decoded value
+func newSearchMatch(matcherId string, matchedString string, value string, decoded decodedValue, start int, end int) searchMatch {
+	match := searchMatch{matcherId: matcherId, matchedString: matchedString, decodingChain: decoded.decodingChain, value: value}
+	if len(decoded.decodingChain) > 0 || start == -1 {
+		start, end = 0, len(value)
+	}
+	match.evidence = evidenceSnippet(value, start, end)
+	match.offset = start
+	return match
+}
+
+// Gets the target of a value extracted from a structured body (like body.json:$.user.name)
+func bodyFieldTarget(field bodyField) string {
+	switch field.kind {
+	case bodyKindJSON:
+		target := "$"
+		for _, segment := range field.path {
+			if _, err := strconv.Atoi(segment); err == nil {
+				target += "[" + segment + "]"
+			} else {
+				target += "." + segment
+			}
+		}
+		return evidenceBodyPrefix + field.kind + ":" + target
+	case bodyKindXML:
+		return evidenceBodyPrefix + field.kind + ":/" + strings.Join(field.path, "/")
+	default:
+		return evidenceBodyPrefix + field.kind + ":" + field.name
+	}
+}
+
+// Creates the finding of a match with the target and the original snippet of the match
+// The value the match was found in is kept until the finding is located in the raw data
+// @param rule - the rule which matched
+// @param match - the match
+func (rl *RuleRunner) newMatchFinding(rule *Rule, match searchMatch) *models.FindingData {
+	finding := &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match.matchedString, Length: int64(len(match.matchedString)), DecodingChain: match.decodingChain, Target: match.target, Evidence: match.evidence, Offset: -1}
+	//The matches which are not part of the raw data (like the expressions or the latency) are not located
+	if match.unlocated {
+		finding.Line = -1
+		finding.LineIndex = -1
+	} else if match.value != "" {
+		if rl.evidenceContexts == nil {
+			rl.evidenceContexts = make(map[*models.FindingData]evidenceContext)
+		}
+		rl.evidenceContexts[finding] = evidenceContext{value: match.value, offset: match.offset}
+	}
+	return finding
+}
+
+// Creates the finding of a match on a websocket or tcp message
+// The message is the raw data, so the offset of the match is already known
+// @param rule - the rule which matched
+// @param match - the match
+func newMessageFinding(rule *Rule, match searchMatch) *models.FindingData {
+	return &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match.matchedString, Length: int64(len(match.matchedString)), DecodingChain: match.decodingChain, Target: match.target, Offset: int64(match.offset), Evidence: match.evidence}
+}
+
+// Gets the regions of the raw request or response where the evidence of the target can be found
+// The raw data is dumped with the first line, the headers (one line per header name), an empty line and the body
+func evidenceRegions(rawData string, target string) []evidenceRegion {
+	firstLineEnd := strings.IndexByte(rawData, '\n')
+	if firstLineEnd == -1 {
+		firstLineEnd = len(rawData)
+	}
+	headersEnd := strings.Index(rawData, "\n\n")
+	bodyStart := headersEnd + 2
+	if headersEnd == -1 {
+		headersEnd, bodyStart = len(rawData), len(rawData)
+	}
+	firstLine := evidenceRegion{start: 0, end: firstLineEnd}
+	body := evidenceRegion{start: bodyStart, end: len(rawData)}
+
+	//Gets the lines of the headers with the name
+	headerLines := func(name string) []evidenceRegion {
+		regions := make([]evidenceRegion, 0)
+		for start := min(firstLineEnd+1, headersEnd); start < headersEnd; {
+			end := strings.IndexByte(rawData[start:headersEnd], '\n')
+			if end == -1 {
+				end = headersEnd - start
+			}
+			line := rawData[start : start+end]
+			if len(line) > len(name) && strings.EqualFold(line[:len(name)], name) && line[len(name)] == ':' {
+				regions = append(regions, evidenceRegion{start: start + len(name) + 1, end: start + end})
+			}
+			start += end + 1
+		}
+		return regions
+	}
+
+	switch {
+	case strings.HasPrefix(target, evidenceHeaderPrefix):
+		return headerLines(strings.TrimPrefix(target, evidenceHeaderPrefix))
+	case strings.HasPrefix(target, evidenceCookiePrefix):
+		return headerLines("Cookie")
+	case strings.HasPrefix(target, evidenceParamPrefix):
+		return []evidenceRegion{firstLine, body}
+	case target == evidenceTargetBody || strings.HasPrefix(target, evidenceBodyPrefix):
+		return []evidenceRegion{body}
+	case target == "":
+		return []evidenceRegion{{start: 0, end: len(rawData)}}
+	default:
+		//The method, the URL components, the request line and the status are on the first line
+		return []evidenceRegion{firstLine}
+	}
+}
+
+// Finds the urlencoded value of the parameter which decodes to a value containing the evidence
+// The values of the query and of the urlencoded bodies are decoded before the rules search them, so the evidence is not found as is
+// Returns the offset and the encoded value or -1 if the parameter was not found
+func locateEncodedParameter(rawData string, region evidenceRegion, name string, evidence string) (int, string) {
+	for _, pair := range encodedParameterRegex.FindAllStringIndex(rawData[region.start:region.end], -1) {
+		key, value, found := strings.Cut(rawData[region.start+pair[0]:region.start+pair[1]], "=")
+		if !found {
+			continue
+		}
+		decodedKey, errKey := url.QueryUnescape(key)
+		decodedValue, errValue := url.QueryUnescape(value)
+		if errKey != nil || errValue != nil || decodedKey != name || indexFold(decodedValue, evidence) == -1 {
+			continue
+		}
+		return region.start + pair[0] + len(key) + 1, evidenceSnippet(value, 0, len(value))
+	}
+	return -1, evidence
+}
+
+// Finds the offset of the evidence of the finding in the raw request or response
+// The value the evidence was found in is searched first in the part of the raw data where the target is, then the evidence itself
+// and at last the evidence is searched in the whole raw data
+// @param rawData - the raw request or response
+// @param target - the target of the finding
+// @param evidence - the original snippet of the match
+// @param context - the value the evidence was found in (can be nil)
+// Returns the offset and the evidence as found in the raw data or -1 if it was not found
+func locateEvidence(rawData string, target string, evidence string, context *evidenceContext) (int, string) {
+	if evidence == "" {
+		return -1, evidence
+	}
+	if context != nil && context.offset+len(evidence) <= len(context.value) {
+		for _, region := range evidenceRegions(rawData, target) {
+			if index := indexFold(rawData[region.start:region.end], context.value); index != -1 {
+				offset := region.start + index + context.offset
+				return offset, rawData[offset : offset+len(evidence)]
+			}
+		}
+	}
+	for _, region := range evidenceRegions(rawData, target) {
+		if index := indexFold(rawData[region.start:region.end], evidence); index != -1 {
+			return region.start + index, rawData[region.start+index : region.start+index+len(evidence)]
+		}
+	}
+	if strings.HasPrefix(target, evidenceParamPrefix) {
+		for _, region := range evidenceRegions(rawData, target) {
+			if offset, encoded := locateEncodedParameter(rawData, region, strings.TrimPrefix(target, evidenceParamPrefix), evidence); offset != -1 {
+				return offset, encoded
+			}
+		}
+	}
+	if index := indexFold(rawData, evidence); index != -1 {
+		return index, rawData[index : index+len(evidence)]
+	}
+	return -1, evidence
+}
+
+// Gets the line number and the offset from the start of the line of the byte offset in the raw data
+func offsetToLine(rawData string, offset int) (int64, int64) {
+	before := rawData[:offset]
+	return int64(strings.Count(before, "\n")), int64(offset - strings.LastIndexByte(before, '\n') - 1)
+}
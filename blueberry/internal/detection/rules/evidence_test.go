@@ -0,0 +1,88 @@
+package detection
+
+import "testing"
+
+func TestLocateEvidence(t *testing.T) {
+	rawData := "GET /search?q=evil&r=%3Cscript%3E HTTP/1.1\nHost: example.com\nUser-Agent: evil evil\n\nname=evil"
+	tests := []struct {
+		name             string
+		target           string
+		evidence         string
+		context          *evidenceContext
+		expectedOffset   int
+		expectedEvidence string
+	}{
+		{
+			name:             "empty evidence",
+			target:           evidenceHeaderPrefix + "User-Agent",
+			expectedOffset:   -1,
+			expectedEvidence: "",
+		},
+		{
+			name:             "evidence in the url",
+			target:           evidenceTargetURL,
+			evidence:         "evil",
+			expectedOffset:   14,
+			expectedEvidence: "evil",
+		},
+		{
+			name:             "evidence in the header of the target",
+			target:           evidenceHeaderPrefix + "user-agent",
+			evidence:         "evil",
+			expectedOffset:   73,
+			expectedEvidence: "evil",
+		},
+		{
+			name:             "evidence with a different case",
+			target:           evidenceHeaderPrefix + "User-Agent",
+			evidence:         "EVIL",
+			expectedOffset:   73,
+			expectedEvidence: "evil",
+		},
+		{
+			name:             "repeated evidence located by its value",
+			target:           evidenceHeaderPrefix + "User-Agent",
+			evidence:         "evil",
+			context:          &evidenceContext{value: "evil evil", offset: 5},
+			expectedOffset:   78,
+			expectedEvidence: "evil",
+		},
+		{
+			name:             "evidence in the body",
+			target:           evidenceTargetBody,
+			evidence:         "evil",
+			expectedOffset:   89,
+			expectedEvidence: "evil",
+		},
+		{
+			name:             "encoded parameter",
+			target:           evidenceParamPrefix + "r",
+			evidence:         "<script>",
+			expectedOffset:   21,
+			expectedEvidence: "%3Cscript%3E",
+		},
+		{
+			name:             "evidence outside of the target",
+			target:           evidenceHeaderPrefix + "Referer",
+			evidence:         "example.com",
+			expectedOffset:   49,
+			expectedEvidence: "example.com",
+		},
+		{
+			name:             "evidence not found",
+			target:           evidenceTargetBody,
+			evidence:         "<img>",
+			expectedOffset:   -1,
+			expectedEvidence: "<img>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offset, evidence := locateEvidence(rawData, test.target, test.evidence, test.context)
+			if offset != test.expectedOffset || evidence != test.expectedEvidence {
+				t.Errorf("locateEvidence() = (%d, %q), expected (%d, %q)", offset, evidence, test.expectedOffset, test.expectedEvidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package detection
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"blueberry/internal/models"
+)
+
+// Holds an exclusion of an allow rule, the matching findings of the excluded rules are suppressed when the allow rule matches
+type RuleExclusion struct {
+	RuleIds    []string `yaml:"rule_ids"`   //The ids of the rules to suppress
+	Tags       []string `yaml:"tags"`       //The tags of the rules to suppress (case insensitive)
+	Path       string   `yaml:"path"`       //The regex the decoded path of the request should match for the exclusion to apply (empty for any path)
+	Parameters []string `yaml:"parameters"` //The parameters the suppressed matches should be found on (empty to suppress all the findings of the rule)
+}
+
+// Holds an exclusion compiled when the rules are loaded
+type compiledExclusion struct {
+	ruleIds    []string       //The ids of the rules to suppress
+	tags       []string       //The tags of the rules to suppress
+	path       *regexp.Regexp //The regex for the path of the request (nil for any path)
+	parameters []string       //The parameters the suppressed matches should be found on
+}
+
+// Holds an exclusion which applies to the current transaction because its allow rule matched
+type activeExclusion struct {
+	allowRuleId string             //The id of the allow rule the exclusion belongs to
+	exclusion   *compiledExclusion //The exclusion
+}
+
+// Checks if the rule is an allow rule
+func isAllowRule(rule *Rule) bool {
+	return rule.Info != nil && strings.ToLower(rule.Info.Action) == "allow"
+}
+
+// Compiles the exclusions of a rule
+func compileExclusions(exclusions []*RuleExclusion) ([]*compiledExclusion, error) {
+	compiled := make([]*compiledExclusion, 0, len(exclusions))
+	for _, exclusion := range exclusions {
+		if exclusion == nil {
+			continue
+		}
+		if len(exclusion.RuleIds) == 0 && len(exclusion.Tags) == 0 {
+			return nil, errors.New("exclusion should specify at least one of rule_ids or tags")
+		}
+		compiledExclusion := &compiledExclusion{ruleIds: exclusion.RuleIds, tags: exclusion.Tags, parameters: exclusion.Parameters}
+		if exclusion.Path != "" {
+			pathRegex, err := regexp.Compile(exclusion.Path)
+			if err != nil {
+				return nil, errors.New("cannot compile exclusion path regex, " + err.Error())
+			}
+			compiledExclusion.path = pathRegex
+		}
+		compiled = append(compiled, compiledExclusion)
+	}
+	return compiled, nil
+}
+
+// Checks if the exclusions of the rule are valid
+// @param rule - the rule to check
+// Returns an error if the rule has exclusions but it is not an allow rule or an exclusion is not valid
+func CheckRuleExclusions(rule Rule) error {
+	if len(rule.Exclusions) == 0 {
+		return nil
+	}
+	if !isAllowRule(&rule) {
+		return errors.New("only the allow rules can have exclusions")
+	}
+	_, err := compileExclusions(rule.Exclusions)
+	return err
+}
+
+// Gets the priority of the rule (0 if not specified)
+func rulePriority(rule *Rule) int {
+	if rule.Info == nil {
+		return 0
+	}
+	return rule.Info.Priority
+}
+
+// Sorts the compiled rules by priority, the rules with the same priority keep the load order with the allow rules first
+func sortCompiledRules(compiledRules []*compiledRule) {
+	sort.SliceStable(compiledRules, func(i, j int) bool {
+		first, second := compiledRules[i].rule, compiledRules[j].rule
+		if rulePriority(first) != rulePriority(second) {
+			return rulePriority(first) > rulePriority(second)
+		}
+		return isAllowRule(first) && !isAllowRule(second)
+	})
+}
+
+// Checks if the exclusion applies to the rule on the path of the current transaction
+func (ce *compiledExclusion) appliesTo(rule *Rule, request *scopeRequest) bool {
+	if ce.path != nil && (request == nil || !ce.path.MatchString(request.path)) {
+		return false
+	}
+	return slices.Contains(ce.ruleIds, rule.Id) || ruleHasAnyTag(*rule, ce.tags)
+}
+
+// Activates the allow rule which matched
+// The exclusions of the rule apply to the rules evaluated after it on the same transaction, an allow rule without exclusions
+// allows the transaction so the remaining rules are not evaluated
+// @param rule - the allow rule which matched
+// @param exclusions - the compiled exclusions of the rule
+// Returns true if the evaluation of the remaining rules should stop
+func (rl *RuleRunner) activateAllowRule(rule *Rule, exclusions []*compiledExclusion) bool {
+	if len(exclusions) == 0 {
+		rl.allowedBy = rule.Id
+		return true
+	}
+	for _, exclusion := range exclusions {
+		rl.activeExclusions = append(rl.activeExclusions, activeExclusion{allowRuleId: rule.Id, exclusion: exclusion})
+	}
+	return false
+}
+
+// Checks if the rule would fire on the matches
+func ruleMatched(compiled *compiledRule, matches []searchMatch, hashMatches []BodyHashMatch) bool {
+	//The condition of the transaction rules needs the response matches, so it cannot be evaluated on the request
+	if compiled.condition != nil && !compiled.rule.Transaction {
+		conditionMet, _ := evaluateCondition(compiled.condition, matches, hashMatches)
+		return conditionMet
+	}
+	return len(matches)+len(hashMatches) > 0
+}
+
+// Removes the matches of the rule suppressed by the exclusions of the allow rules which matched on the transaction
+// The suppression is recorded only if the rule would have fired without the exclusions
+// @param compiled - the compiled rule
+// @param direction - the direction of the data the matches were found on (ingress or egress)
+// @param matches - the matches of the rule
+// @param bodyMatches - the body matches of the rule (kept separately by the request)
+// @param hashMatches - the body hash matches of the rule
+// Returns the matches, the body matches and the body hash matches which were not suppressed
+func (rl *RuleRunner) applyExclusions(compiled *compiledRule, direction string, matches []searchMatch, bodyMatches []searchMatch, hashMatches []BodyHashMatch) ([]searchMatch, []searchMatch, []BodyHashMatch) {
+	if len(rl.activeExclusions) == 0 || len(matches)+len(bodyMatches)+len(hashMatches) == 0 {
+		return matches, bodyMatches, hashMatches
+	}
+
+	//Get the exclusions which apply to the rule
+	exclusions := make([]activeExclusion, 0)
+	for _, active := range rl.activeExclusions {
+		if active.exclusion.appliesTo(compiled.rule, rl.request) {
+			exclusions = append(exclusions, active)
+		}
+	}
+	if len(exclusions) == 0 || !ruleMatched(compiled, append(slices.Clone(matches), bodyMatches...), hashMatches) {
+		return matches, bodyMatches, hashMatches
+	}
+
+	//The exclusions without parameters suppress all the findings of the rule
+	for _, active := range exclusions {
+		if len(active.exclusion.parameters) == 0 {
+			rl.suppressions = append(rl.suppressions, &models.SuppressionData{RuleId: compiled.rule.Id, SuppressedBy: active.allowRuleId, Direction: direction, Parameters: []string{}, Matches: int64(len(matches) + len(bodyMatches) + len(hashMatches))})
+			return make([]searchMatch, 0), make([]searchMatch, 0), make([]BodyHashMatch, 0)
+		}
+	}
+
+	//Remove the matches found on the excluded parameters
+	suppressions := make(map[string]*models.SuppressionData)
+	filter := func(matches []searchMatch) []searchMatch {
+		kept := make([]searchMatch, 0, len(matches))
+		for _, match := range matches {
+			index := slices.IndexFunc(exclusions, func(active activeExclusion) bool {
+				return match.parameter != "" && slices.Contains(active.exclusion.parameters, match.parameter)
+			})
+			if index == -1 {
+				kept = append(kept, match)
+				continue
+			}
+			allowRuleId := exclusions[index].allowRuleId
+			suppression, ok := suppressions[allowRuleId]
+			if !ok {
+				suppression = &models.SuppressionData{RuleId: compiled.rule.Id, SuppressedBy: allowRuleId, Direction: direction, Parameters: []string{}}
+				suppressions[allowRuleId] = suppression
+				rl.suppressions = append(rl.suppressions, suppression)
+			}
+			if !slices.Contains(suppression.Parameters, match.parameter) {
+				suppression.Parameters = append(suppression.Parameters, match.parameter)
+			}
+			suppression.Matches++
+		}
+		return kept
+	}
+	return filter(matches), filter(bodyMatches), hashMatches
+}
+
+// Gets the rule findings suppressed by the exclusions of the allow rules on the data checked by the runner
+func (rl *RuleRunner) Suppressions() []*models.SuppressionData {
+	return rl.suppressions
+}
+
+// Gets the id of the allow rule which stopped the evaluation of the remaining rules (empty if no such rule matched)
+func (rl *RuleRunner) AllowedBy() string {
+	return rl.allowedBy
+}
@@ -0,0 +1,101 @@
+package detection
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"blueberry/internal/models"
+)
+
+func TestApplyExclusions(t *testing.T) {
+	rule := &Rule{Id: "sqli-1", Info: &RuleInfo{Name: "SQL injection", Tags: []string{"SQLi"}, Action: "drop"}}
+	matches := []searchMatch{{matcherId: "query", parameter: "q"}, {matcherId: "query", parameter: "id"}, {matcherId: "agent", target: "header:User-Agent"}}
+	bodyMatches := []searchMatch{{matcherId: "body", parameter: "comment"}}
+	hashMatches := []BodyHashMatch{{MatcherId: "hash"}}
+	tests := []struct {
+		name         string
+		exclusions   []*compiledExclusion
+		condition    *RuleCondition
+		path         string
+		matches      int
+		bodyMatches  int
+		hashMatches  int
+		suppressions []*models.SuppressionData
+	}{
+		{
+			name:         "no exclusions",
+			matches:      3,
+			bodyMatches:  1,
+			hashMatches:  1,
+			suppressions: []*models.SuppressionData{},
+		},
+		{
+			name:         "excluded rule id",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"sqli-1"}}},
+			suppressions: []*models.SuppressionData{{RuleId: "sqli-1", SuppressedBy: "allow-1", Direction: "ingress", Parameters: []string{}, Matches: 5}},
+		},
+		{
+			name:         "excluded tag",
+			exclusions:   []*compiledExclusion{{tags: []string{"sqli"}}},
+			suppressions: []*models.SuppressionData{{RuleId: "sqli-1", SuppressedBy: "allow-1", Direction: "ingress", Parameters: []string{}, Matches: 5}},
+		},
+		{
+			name:         "other rule",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"xss-1"}, tags: []string{"xss"}}},
+			matches:      3,
+			bodyMatches:  1,
+			hashMatches:  1,
+			suppressions: []*models.SuppressionData{},
+		},
+		{
+			name:         "path of the exclusion",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"sqli-1"}, path: regexp.MustCompile(`^/search$`)}},
+			path:         "/search",
+			suppressions: []*models.SuppressionData{{RuleId: "sqli-1", SuppressedBy: "allow-1", Direction: "ingress", Parameters: []string{}, Matches: 5}},
+		},
+		{
+			name:         "other path",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"sqli-1"}, path: regexp.MustCompile(`^/search$`)}},
+			path:         "/login",
+			matches:      3,
+			bodyMatches:  1,
+			hashMatches:  1,
+			suppressions: []*models.SuppressionData{},
+		},
+		{
+			name:         "excluded parameters",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"sqli-1"}, parameters: []string{"q", "comment"}}},
+			matches:      2,
+			hashMatches:  1,
+			suppressions: []*models.SuppressionData{{RuleId: "sqli-1", SuppressedBy: "allow-1", Direction: "ingress", Parameters: []string{"q", "comment"}, Matches: 2}},
+		},
+		{
+			name:         "rule which would not fire",
+			exclusions:   []*compiledExclusion{{ruleIds: []string{"sqli-1"}}},
+			condition:    &RuleCondition{All: matcherOperands("query", "cookie")},
+			matches:      3,
+			bodyMatches:  1,
+			hashMatches:  1,
+			suppressions: []*models.SuppressionData{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rl := &RuleRunner{request: &scopeRequest{host: "example.com", path: test.path, method: "GET"}, suppressions: make([]*models.SuppressionData, 0)}
+			for _, exclusion := range test.exclusions {
+				rl.activeExclusions = append(rl.activeExclusions, activeExclusion{allowRuleId: "allow-1", exclusion: exclusion})
+			}
+			compiled := &compiledRule{rule: rule, condition: test.condition}
+
+			keptMatches, keptBodyMatches, keptHashMatches := rl.applyExclusions(compiled, "ingress", matches, bodyMatches, hashMatches)
+			if len(keptMatches) != test.matches || len(keptBodyMatches) != test.bodyMatches || len(keptHashMatches) != test.hashMatches {
+				t.Errorf("applyExclusions() kept (%d, %d, %d) matches, expected (%d, %d, %d)", len(keptMatches), len(keptBodyMatches), len(keptHashMatches), test.matches, test.bodyMatches, test.hashMatches)
+			}
+			if !reflect.DeepEqual(rl.Suppressions(), test.suppressions) {
+				t.Errorf("applyExclusions() suppressions = %+v, expected %+v", rl.Suppressions(), test.suppressions)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package detection
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"blueberry/internal/models"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
+)
+
+// The maximum cost of the expression of a rule, every node of the expression costs 1
+// The cost is only checked when the expression is compiled, the evaluation is bounded by the memory budget and the collection sizes
+const MaxExpressionCost = 1000
+
+// The memory budget of the evaluation of an expression, counted by the expression VM as the number of elements of the collections
+// iterated or created by the expression (the nested predicates count the elements of every iteration), the evaluation fails when it is exceeded
+const ExpressionMemoryBudget = 100000
+
+// The maximum number of entries of a collection exposed to the expressions (the names of a map and the values of a name)
+// The remaining entries are dropped and the truncated field of the request or the response is set
+const MaxExpressionCollectionSize = 256
+
+// The cost multiplier of the nodes inside the predicates (like all or filter), since they run for every element of the collection
+const expressionPredicateCost = 20
+
+// The builtins which are not available in the expressions, they are not deterministic or can allocate a lot of memory
+var disabledExpressionBuiltins = []string{"now", "date", "timezone", "repeat"}
+
+// The memory budget is a setting of the expression VM shared by all the evaluations
+func init() {
+	vm.MemoryBudget = ExpressionMemoryBudget
+}
+
+// The phases of the transaction the expression is evaluated in
+const (
+	ExpressionPhaseRequest  = "request"
+	ExpressionPhaseResponse = "response"
+)
+
+// Holds the values of the transaction the expressions of the rules are evaluated on
+type ExpressionEnvironment struct {
+	Phase    string              `expr:"phase"`     //The phase of the transaction (request or response)
+	Request  ExpressionRequest   `expr:"request"`   //The values of the request
+	Response ExpressionResponse  `expr:"response"`  //The values of the response (empty in the request phase)
+	RemoteIP string              `expr:"remote_ip"` //The IP address of the client
+	Findings []ExpressionFinding `expr:"findings"`  //The findings of the rules evaluated before on the same transaction
+}
+
+// Holds the values of the request exposed to the expressions
+type ExpressionRequest struct {
+	Method      string              `expr:"method"`       //The method of the request
+	URL         string              `expr:"url"`          //The request URI (path and query)
+	Scheme      string              `expr:"scheme"`       //The scheme of the request (http or https)
+	Host        string              `expr:"host"`         //The lowercase host without the port
+	Path        string              `expr:"path"`         //The decoded path
+	Query       string              `expr:"query"`        //The raw query string
+	Headers     map[string][]string `expr:"headers"`      //The values of the headers by their lowercase name
+	Params      map[string][]string `expr:"params"`       //The values of the query, form and body parameters by their name
+	Cookies     map[string]string   `expr:"cookies"`      //The values of the cookies by their name
+	BodySize    int                 `expr:"body_size"`    //The size of the body in bytes
+	ContentType string              `expr:"content_type"` //The content type of the body
+	Truncated   bool                `expr:"truncated"`    //If the headers, the parameters or the cookies were limited to the maximum collection size
+}
+
+// Holds the values of the response exposed to the expressions
+type ExpressionResponse struct {
+	Status      int                 `expr:"status"`       //The status code
+	Headers     map[string][]string `expr:"headers"`      //The values of the headers by their lowercase name
+	BodySize    int                 `expr:"body_size"`    //The size of the body in bytes
+	ContentType string              `expr:"content_type"` //The content type of the body
+	Truncated   bool                `expr:"truncated"`    //If the headers were limited to the maximum collection size
+}
+
+// Holds a finding exposed to the expressions
+type ExpressionFinding struct {
+	RuleId         string `expr:"rule_id"`        //The id of the rule which found it
+	Classification string `expr:"classification"` //The classification of the finding
+	Severity       string `expr:"severity"`       //The severity of the finding (low, medium, high or critical)
+}
+
+// Counts the cost of the nodes of an expression
+type expressionCostVisitor struct {
+	cost int
+}
+
+// Adds the cost of the node, the nodes of the predicates are counted once by the walk and multiplied here
+func (ecv *expressionCostVisitor) Visit(node *ast.Node) {
+	ecv.cost++
+	if closure, ok := (*node).(*ast.ClosureNode); ok {
+		ecv.cost += expressionCost(closure.Node) * (expressionPredicateCost - 1)
+	}
+}
+
+// Gets the cost of the expression tree
+func expressionCost(node ast.Node) int {
+	visitor := &expressionCostVisitor{}
+	ast.Walk(&node, visitor)
+	return visitor.cost
+}
+
+// Compiles and type checks the expression, which should evaluate to a boolean
+// @param source - the expression from the rule file
+// Returns the compiled program or an error if the expression is invalid or exceeds the maximum cost
+func compileExpression(source string) (*vm.Program, error) {
+	options := []expr.Option{expr.Env(ExpressionEnvironment{}), expr.AsBool()}
+	for _, builtin := range disabledExpressionBuiltins {
+		options = append(options, expr.DisableBuiltin(builtin))
+	}
+	program, err := expr.Compile(source, options...)
+	if err != nil {
+		return nil, errors.New("cannot compile expression, " + err.Error())
+	}
+	if cost := expressionCost(program.Node()); cost > MaxExpressionCost {
+		return nil, errors.New("expression cost " + strconv.Itoa(cost) + " exceeds the maximum of " + strconv.Itoa(MaxExpressionCost))
+	}
+	return program, nil
+}
+
+// Checks if the expression of the rule is valid
+// @param rule - the rule to check
+// Returns an error if the expression does not compile, does not evaluate to a boolean, is too expensive or the rule is not an http rule
+func CheckRuleExpression(rule Rule) error {
+	if rule.Expr == "" {
+		return nil
+	}
+	if len(rule.Websocket)+len(rule.TCP) > 0 {
+		return errors.New("expr can be used only by the http rules")
+	}
+	_, err := compileExpression(rule.Expr)
+	return err
+}
+
+// Checks if the rule has only an expression, such rules are evaluated on the request
+func isExpressionOnlyRule(rule *Rule) bool {
+	return rule.Expr != "" && rule.Request == nil && rule.Response == nil
+}
+
+// Gets the values of the headers by their lowercase name
+func lowercaseHeaders(headers http.Header) map[string][]string {
+	lowercase := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		lowercase[strings.ToLower(name)] = append(lowercase[strings.ToLower(name)], values...)
+	}
+	return lowercase
+}
+
+// Limits the collection exposed to the expressions to the maximum collection size
+// The names are kept in sorted order so the same names are kept for the same collection, and the first values of every name are kept
+// Returns the limited collection and if any name or value was dropped
+func limitExpressionCollection(collection map[string][]string) (map[string][]string, bool) {
+	truncated := len(collection) > MaxExpressionCollectionSize
+	names := slices.Sorted(maps.Keys(collection))
+	limited := make(map[string][]string, min(len(collection), MaxExpressionCollectionSize))
+	for _, name := range names[:min(len(names), MaxExpressionCollectionSize)] {
+		values := collection[name]
+		if len(values) > MaxExpressionCollectionSize {
+			values = values[:MaxExpressionCollectionSize]
+			truncated = true
+		}
+		limited[name] = values
+	}
+	return limited, truncated
+}
+
+// Creates the request values exposed to the expressions
+// @param r - the http request
+// @param bodySize - the size of the request body
+// @param parameters - the lists of parameters of the request (query, form and body)
+func newExpressionRequest(r *http.Request, bodySize int, parameters ...map[string][]string) ExpressionRequest {
+	request := ExpressionRequest{Method: r.Method, URL: r.URL.RequestURI(), Scheme: "http", Host: newScopeRequest(r).host, Path: r.URL.Path, Query: r.URL.RawQuery, Cookies: make(map[string]string), BodySize: bodySize, ContentType: r.Header.Get("Content-Type")}
+	if r.TLS != nil {
+		request.Scheme = "https"
+	}
+	params := make(map[string][]string)
+	for _, parameterList := range parameters {
+		for name, values := range parameterList {
+			params[name] = append(params[name], values...)
+		}
+	}
+	cookies := make(map[string][]string)
+	for _, cookie := range r.Cookies() {
+		cookies[cookie.Name] = append(cookies[cookie.Name], cookie.Value)
+	}
+
+	var headersTruncated, paramsTruncated, cookiesTruncated bool
+	request.Headers, headersTruncated = limitExpressionCollection(lowercaseHeaders(r.Header))
+	request.Params, paramsTruncated = limitExpressionCollection(params)
+	cookies, cookiesTruncated = limitExpressionCollection(cookies)
+	//The last cookie with the same name is kept, as before the limit
+	for name, values := range cookies {
+		request.Cookies[name] = values[len(values)-1]
+	}
+	request.Truncated = headersTruncated || paramsTruncated || cookiesTruncated
+	return request
+}
+
+// Gets the IP address of the client from the remote address of the request
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Adds the values of the response to the values the expressions are evaluated on
+// If the request was not checked by the runner only the values of the request which do not need the body are available
+// @param r - the http response
+// @param bodySize - the size of the response body
+func (rl *RuleRunner) addResponseExpressionValues(r *http.Response, bodySize int) {
+	if rl.expression == nil {
+		rl.expression = &ExpressionEnvironment{}
+		if r.Request != nil && r.Request.URL != nil {
+			rl.expression.Request = newExpressionRequest(r.Request, max(int(r.Request.ContentLength), 0), r.Request.URL.Query())
+			rl.expression.RemoteIP = remoteIP(r.Request)
+		}
+	}
+
+	headers, truncated := limitExpressionCollection(lowercaseHeaders(r.Header))
+	rl.expression.Response = ExpressionResponse{Status: r.StatusCode, Headers: headers, BodySize: bodySize, ContentType: r.Header.Get("Content-Type"), Truncated: truncated}
+}
+
+// Evaluates the expression of the rule on the transaction
+// The findings of the request and the findings found before in the current phase are exposed to the expression
+// @param compiled - the compiled rule
+// @param phase - the phase of the transaction (request or response)
+// @param findings - the findings found before in the current phase
+// Returns true if the expression evaluated to true, the errors are logged and the expression is considered false
+func (rl *RuleRunner) evaluateExpression(compiled *compiledRule, phase string, findings []*models.FindingData) bool {
+	if rl.expression == nil {
+		return false
+	}
+
+	environment := *rl.expression
+	environment.Phase = phase
+	environment.Findings = make([]ExpressionFinding, 0, len(rl.requestFindings)+len(findings))
+	previous := findings
+	if phase == ExpressionPhaseResponse {
+		previous = append(append(make([]*models.FindingData, 0, len(rl.requestFindings)+len(findings)), rl.requestFindings...), findings...)
+	}
+	//The findings are limited to the maximum collection size, like the other collections
+	for _, finding := range previous[:min(len(previous), MaxExpressionCollectionSize)] {
+		environment.Findings = append(environment.Findings, ExpressionFinding{RuleId: finding.RuleId, Classification: finding.Classification, Severity: ConvertSeverityIntegerToString(finding.Severity)})
+	}
+
+	//The VM bounds the evaluation with the memory budget, so the predicates over large collections cannot run unbounded
+	result, err := rl.expressionVM.Run(compiled.expression, environment)
+	if err != nil {
+		//The expression which runs out of memory is handled like the evaluation which exceeds the time budget
+		if strings.Contains(err.Error(), "memory budget exceeded") {
+			rl.exceedBudget("Expression of rule " + compiled.rule.Id + " exceeded the memory budget, the remaining rules were skipped")
+			return false
+		}
+		rl.logger.Debug("Error occured when evaluating the expression of rule", compiled.rule.Id, err.Error())
+		return false
+	}
+	matched, _ := result.(bool)
+	return matched
+}
+
+// Creates the finding of a rule which has only an expression, it is not located since the expression does not match a string
+func newExpressionFinding(rule *Rule) *models.FindingData {
+	return &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: rule.Expr, Length: int64(len(rule.Expr)), Target: evidenceTargetExpression, Offset: -1}
+}
@@ -0,0 +1,110 @@
+package detection
+
+import (
+	"errors"
+	"time"
+)
+
+// Holds the timings of the upstream response used by the latency matchers
+type UpstreamTimings struct {
+	ResponseTime    time.Duration //The time from sending the request until the whole response was received
+	TimeToFirstByte time.Duration //The time from sending the request until the first byte of the response was received
+}
+
+// Holds the comparisons made on a duration of the upstream response
+// The durations are written like 5s, 1500ms or 1m, all the specified comparisons should be true for the matcher to match
+type DurationRule struct {
+	Id  string `yaml:"id"`  //The id of the matcher used in the rule condition
+	Gt  string `yaml:"gt"`  //The duration should be greater than this value
+	Gte string `yaml:"gte"` //The duration should be greater than or equal to this value
+	Lt  string `yaml:"lt"`  //The duration should be less than this value
+	Lte string `yaml:"lte"` //The duration should be less than or equal to this value
+}
+
+// Holds a duration matcher compiled when the rules are loaded
+type compiledDurationMatcher struct {
+	id  string         //The id of the matcher used in the rule condition (empty if not specified)
+	gt  *time.Duration //The exclusive lower bound (nil if not specified)
+	gte *time.Duration //The inclusive lower bound (nil if not specified)
+	lt  *time.Duration //The exclusive upper bound (nil if not specified)
+	lte *time.Duration //The inclusive upper bound (nil if not specified)
+}
+
+// Parses a bound of the duration rule, the empty bounds are not specified
+func parseDurationBound(name string, value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, errors.New("invalid " + name + " duration, " + err.Error())
+	}
+	if duration < 0 {
+		return nil, errors.New("invalid " + name + " duration, it cannot be negative")
+	}
+	return &duration, nil
+}
+
+// Compiles the duration rule
+// @param durationRule - the duration rule from the rule file
+// Returns the compiled matcher or an error if a bound is not a valid duration or no bound is specified
+func compileDurationMatcher(durationRule *DurationRule) (*compiledDurationMatcher, error) {
+	var err error
+	matcher := &compiledDurationMatcher{id: durationRule.Id}
+	if matcher.gt, err = parseDurationBound("gt", durationRule.Gt); err != nil {
+		return nil, err
+	}
+	if matcher.gte, err = parseDurationBound("gte", durationRule.Gte); err != nil {
+		return nil, err
+	}
+	if matcher.lt, err = parseDurationBound("lt", durationRule.Lt); err != nil {
+		return nil, err
+	}
+	if matcher.lte, err = parseDurationBound("lte", durationRule.Lte); err != nil {
+		return nil, err
+	}
+	if matcher.gt == nil && matcher.gte == nil && matcher.lt == nil && matcher.lte == nil {
+		return nil, errors.New("duration matcher should specify at least one of gt, gte, lt or lte")
+	}
+	return matcher, nil
+}
+
+// Checks if the duration rule is valid
+// @param durationRule - the duration rule from the rule file (can be nil)
+// Returns an error if the duration rule is not valid
+func CheckDurationRule(durationRule *DurationRule) error {
+	if durationRule == nil {
+		return nil
+	}
+	_, err := compileDurationMatcher(durationRule)
+	return err
+}
+
+// Checks if the duration satisfies all the bounds of the matcher
+func (dm *compiledDurationMatcher) matches(duration time.Duration) bool {
+	if dm.gt != nil && duration <= *dm.gt {
+		return false
+	}
+	if dm.gte != nil && duration < *dm.gte {
+		return false
+	}
+	if dm.lt != nil && duration >= *dm.lt {
+		return false
+	}
+	if dm.lte != nil && duration > *dm.lte {
+		return false
+	}
+	return true
+}
+
+// Checks if the duration of the upstream response matches the duration matcher
+// The duration is not part of the response, so the match is not located in the raw response
+// @param duration - the measured duration
+// @param matcher - the compiled duration matcher (can be nil)
+// Returns the list of matches (the duration as string if it matched)
+func (rl *RuleRunner) checkDuration(duration time.Duration, matcher *compiledDurationMatcher) []searchMatch {
+	if matcher == nil || !matcher.matches(duration) {
+		return make([]searchMatch, 0)
+	}
+	return []searchMatch{{matcherId: matcher.id, matchedString: duration.String(), decodingChain: []string{}, unlocated: true}}
+}
@@ -0,0 +1,243 @@
+package detection
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"blueberry/internal/config"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The default directory of the wordlists and the macros, relative to the rules directory
+const DefaultLibraryDirectory = "library"
+
+// The reference to a macro inside the match and regex fields of the rules, like %{unix_files}
+var macroReferenceRegex = regexp.MustCompile(`%\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Holds the macros defined in a yaml file of the library
+type macrosFile struct {
+	Macros map[string]string `yaml:"macros"` //The values of the macros by their name
+}
+
+// Holds the macros and the wordlists shared by the rules
+// The macros are defined in the yaml files of the library directory and the wordlists are text files with one entry per line
+type RuleLibrary struct {
+	directory string              //The library directory
+	macros    map[string]string   //The values of the macros by their name
+	wordlists map[string][]string //The entries of the wordlists already loaded by their path
+}
+
+// Holds the fields of a matcher which can use the library
+type libraryMatcher struct {
+	match     *string   //The string to match exactly
+	regex     *string   //The regex used for searching
+	matchFile string    //The wordlist file (empty if not specified)
+	matchList *[]string //The entries of the wordlist file (nil if the matcher does not support wordlists)
+}
+
+// Gets the library directory from the configuration
+// A relative library directory is relative to the rules directory
+// @param configuration - the configuration of the agent
+func GetLibraryDirectory(configuration config.Configuration) string {
+	directory := configuration.RuleConfig.LibraryDirectory
+	if directory == "" {
+		directory = DefaultLibraryDirectory
+	}
+	if filepath.IsAbs(directory) {
+		return filepath.Clean(directory)
+	}
+	return filepath.Join(configuration.RuleConfig.RulesDirectory, directory)
+}
+
+// Checks if the path is the library directory, which does not contain rules
+// @param configuration - the configuration of the agent
+// @param path - the path of the directory
+func IsLibraryDirectory(configuration config.Configuration, path string) bool {
+	return filepath.Clean(path) == GetLibraryDirectory(configuration)
+}
+
+// Loads the macros from the yaml files of the library directory
+// The wordlists are loaded when the rules reference them
+// @param configuration - the configuration of the agent
+// Returns the library (empty if the library directory does not exist) or an error if a macros file is invalid or a macro is defined twice
+func LoadRuleLibrary(configuration config.Configuration) (*RuleLibrary, error) {
+	library := &RuleLibrary{directory: GetLibraryDirectory(configuration), macros: make(map[string]string), wordlists: make(map[string][]string)}
+	if _, err := os.Stat(library.directory); err != nil {
+		return library, nil
+	}
+
+	err := filepath.WalkDir(library.directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".yaml") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.New("could not read macros file " + path + ", " + err.Error())
+		}
+		file := macrosFile{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return errors.New("could not parse macros file " + path + ", " + err.Error())
+		}
+		for name, value := range file.Macros {
+			if !macroReferenceRegex.MatchString("%{" + name + "}") {
+				return errors.New("invalid macro name " + name + " in " + path + ", it can contain only letters, digits and underscores")
+			}
+			if _, ok := library.macros[name]; ok {
+				return errors.New("macro " + name + " from " + path + " is already defined")
+			}
+			library.macros[name] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return library, nil
+}
+
+// Loads the entries of the wordlist, the empty lines and the lines starting with # are skipped
+// Returns the entries or an error if the file is outside the library directory or cannot be read
+func (rl *RuleLibrary) loadWordlist(file string) ([]string, error) {
+	path := filepath.Join(rl.directory, filepath.FromSlash(file))
+	relativePath, err := filepath.Rel(rl.directory, path)
+	if filepath.IsAbs(file) || err != nil || strings.HasPrefix(relativePath, "..") {
+		return nil, errors.New("wordlist " + file + " should be inside the library directory")
+	}
+	if entries, ok := rl.wordlists[path]; ok {
+		return entries, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	rl.wordlists[path] = entries
+	return entries, nil
+}
+
+// Expands the macros and loads the wordlists of the rule
+// The undefined macros are kept and the missing wordlists are not loaded, so they are reported by CheckRule
+// @param rule - the rule to resolve in place
+func (rl *RuleLibrary) ResolveRule(rule *Rule) {
+	expand := func(value *string) {
+		*value = macroReferenceRegex.ReplaceAllStringFunc(*value, func(reference string) string {
+			if macro, ok := rl.macros[macroReferenceRegex.FindStringSubmatch(reference)[1]]; ok {
+				return macro
+			}
+			return reference
+		})
+	}
+
+	for _, matcher := range ruleLibraryMatchers(rule) {
+		expand(matcher.match)
+		expand(matcher.regex)
+		if matcher.matchFile != "" && matcher.matchList != nil {
+			if entries, err := rl.loadWordlist(matcher.matchFile); err == nil {
+				*matcher.matchList = entries
+			}
+		}
+	}
+}
+
+// Checks if all the macros of the rule were expanded and all the wordlists were loaded
+// @param rule - the rule resolved with the library
+// Returns an error with the first undefined macro or missing wordlist
+func CheckRuleLibrary(rule Rule) error {
+	if errs := ruleLibraryErrors(rule); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Gets the errors for every undefined macro and missing wordlist of the rule
+func ruleLibraryErrors(rule Rule) []error {
+	errs := make([]error, 0)
+	for _, matcher := range ruleLibraryMatchers(&rule) {
+		for _, value := range []string{*matcher.match, *matcher.regex} {
+			for _, reference := range macroReferenceRegex.FindAllStringSubmatch(value, -1) {
+				errs = append(errs, errors.New("macro "+reference[1]+" is not defined in the rule library"))
+			}
+		}
+		if matcher.matchFile != "" && matcher.matchList != nil && len(*matcher.matchList) == 0 {
+			errs = append(errs, errors.New("match_file "+matcher.matchFile+" was not found in the rule library or it is empty"))
+		}
+	}
+	return errs
+}
+
+// Gets the fields of all the matchers of the rule which can use the library
+func ruleLibraryMatchers(rule *Rule) []libraryMatcher {
+	matchers := make([]libraryMatcher, 0)
+	searchMode := func(mode *RuleSearchMode) {
+		matchers = append(matchers, libraryMatcher{match: &mode.Match, regex: &mode.Regex, matchFile: mode.MatchFile, matchList: &mode.MatchList})
+	}
+	headers := func(headers []*HeadersRule) {
+		for _, header := range headers {
+			matchers = append(matchers, libraryMatcher{match: &header.Match, regex: &header.Regex, matchFile: header.MatchFile, matchList: &header.MatchList})
+		}
+	}
+	body := func(bodyRules []*BodyRule) {
+		for _, bodyRule := range bodyRules {
+			matchers = append(matchers, libraryMatcher{match: &bodyRule.Match, regex: &bodyRule.Regex, matchFile: bodyRule.MatchFile, matchList: &bodyRule.MatchList})
+		}
+	}
+
+	if rule.Request != nil {
+		if rule.Request.Method != nil {
+			searchMode(rule.Request.Method)
+		}
+		for _, urlRule := range rule.Request.URL {
+			searchMode(urlRule)
+		}
+		for _, target := range rule.Request.Targets() {
+			for _, targetRule := range target.Matchers {
+				searchMode(targetRule)
+			}
+		}
+		headers(rule.Request.Headers)
+		for _, parameter := range rule.Request.Parameters {
+			matchers = append(matchers, libraryMatcher{match: &parameter.Match, regex: &parameter.Regex, matchFile: parameter.MatchFile, matchList: &parameter.MatchList})
+		}
+		for _, cookie := range rule.Request.Cookies {
+			matchers = append(matchers, libraryMatcher{match: &cookie.Match, regex: &cookie.Regex, matchFile: cookie.MatchFile, matchList: &cookie.MatchList})
+		}
+		body(rule.Request.Body)
+	}
+	if rule.Response != nil {
+		if rule.Response.Code != nil {
+			searchMode(rule.Response.Code)
+		}
+		headers(rule.Response.Headers)
+		body(rule.Response.Body)
+	}
+	for _, wsRule := range rule.Websocket {
+		matchers = append(matchers, libraryMatcher{match: &wsRule.Match, regex: &wsRule.Regex}, libraryMatcher{match: &wsRule.HexMatch, regex: &wsRule.HexRegex})
+	}
+	for _, tcpRule := range rule.TCP {
+		matchers = append(matchers, libraryMatcher{match: &tcpRule.Match, regex: &tcpRule.Regex}, libraryMatcher{match: &tcpRule.HexMatch, regex: &tcpRule.HexRegex})
+	}
+	return matchers
+}
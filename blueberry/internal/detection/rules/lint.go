@@ -0,0 +1,617 @@
+package detection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+
+	"blueberry/internal/config"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// The severities of the lint diagnostics
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// Holds a problem found in a rule file
+type LintDiagnostic struct {
+	File     string `json:"file"`     //The path of the rule file
+	Line     int    `json:"line"`     //The line of the problem (1 based, 0 if unknown)
+	Column   int    `json:"column"`   //The column of the problem (1 based, 0 if unknown)
+	RuleId   string `json:"ruleId"`   //The id of the rule (empty if it could not be parsed)
+	Severity string `json:"severity"` //The severity of the problem (error or warning)
+	Message  string `json:"message"`  //The description of the problem
+}
+
+// Formats the diagnostic as file:line:column: severity: message
+func (ld LintDiagnostic) String() string {
+	ruleId := ""
+	if ld.RuleId != "" {
+		ruleId = "[" + ld.RuleId + "] "
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s%s", ld.File, ld.Line, ld.Column, ld.Severity, ruleId, ld.Message)
+}
+
+// Holds the state of the linter for a single rule file
+type ruleFileLinter struct {
+	path        string           //The path of the rule file
+	rule        Rule             //The rule decoded from the file
+	diagnostics []LintDiagnostic //The problems found in the file
+}
+
+// Adds a diagnostic at the position of the node (or at the start of the file if the node is missing)
+func (rfl *ruleFileLinter) report(node *yamlv3.Node, severity string, message string) {
+	diagnostic := LintDiagnostic{File: rfl.path, Line: 1, Column: 1, RuleId: rfl.rule.Id, Severity: severity, Message: message}
+	if node != nil {
+		diagnostic.Line = node.Line
+		diagnostic.Column = node.Column
+	}
+	rfl.diagnostics = append(rfl.diagnostics, diagnostic)
+}
+
+// Lints all the rule files in the rules directory, the ignored directories from the configuration are skipped
+// Unlike the loader every problem is reported, with the position in the file where it was found
+// @param configuration - the configuration with the rules options
+// Returns the problems found in the rule files sorted by file and line or an error if the directory cannot be walked
+func LintRulesDirectory(configuration config.Configuration) ([]LintDiagnostic, error) {
+	rulesDirectory := configuration.RuleConfig.RulesDirectory
+	if _, err := os.Stat(rulesDirectory); err != nil {
+		return nil, errors.New("rules directory does not exist")
+	}
+	library, err := LoadRuleLibrary(configuration)
+	if err != nil {
+		return nil, errors.New("could not load the rule library, " + err.Error())
+	}
+
+	diagnostics := make([]LintDiagnostic, 0)
+	//The position of the first definition of every rule id
+	definitions := make(map[string]LintDiagnostic)
+
+	err = filepath.WalkDir(rulesDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if IsLibraryDirectory(configuration, path) || IsIgnoredRulesDirectory(configuration, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".yaml") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			diagnostics = append(diagnostics, LintDiagnostic{File: path, Severity: LintError, Message: "could not read the file, " + err.Error()})
+			return nil
+		}
+
+		linter, idNode := lintRuleFile(path, data, library)
+		diagnostics = append(diagnostics, linter.diagnostics...)
+
+		//Check if the rule id was already defined in another file
+		if linter.rule.Id != "" {
+			if first, ok := definitions[linter.rule.Id]; ok {
+				linter.diagnostics = nil
+				linter.report(idNode, LintError, fmt.Sprintf("duplicate rule id, already defined in %s:%d", first.File, first.Line))
+				diagnostics = append(diagnostics, linter.diagnostics...)
+			} else {
+				definition := LintDiagnostic{File: path, Line: 1}
+				if idNode != nil {
+					definition.Line = idNode.Line
+				}
+				definitions[linter.rule.Id] = definition
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("could not walk rules directory, " + err.Error())
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return diagnostics, nil
+}
+
+// The line number in the yaml parser errors
+var yamlErrorLineRegex = regexp.MustCompile(`line (\d+)`)
+
+// Lints a single rule file, the macros and the wordlists are resolved with the library before the checks
+// Returns the linter with the decoded rule and the diagnostics, and the node of the rule id (nil if missing)
+func lintRuleFile(path string, data []byte, library *RuleLibrary) (*ruleFileLinter, *yamlv3.Node) {
+	linter := &ruleFileLinter{path: path}
+
+	//Parse the file keeping the positions of the nodes
+	document := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, document); err != nil {
+		diagnostic := LintDiagnostic{File: path, Severity: LintError, Message: "invalid yaml, " + err.Error()}
+		if match := yamlErrorLineRegex.FindStringSubmatch(err.Error()); match != nil {
+			diagnostic.Line, _ = strconv.Atoi(match[1])
+		}
+		linter.diagnostics = append(linter.diagnostics, diagnostic)
+		return linter, nil
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yamlv3.MappingNode {
+		linter.report(nil, LintError, "rule file should contain a mapping")
+		return linter, nil
+	}
+	root := document.Content[0]
+
+	//Decode the rule the same way the loader does
+	if err := linter.rule.FromYAML(bytes.NewReader(data)); err != nil {
+		linter.report(root, LintError, "cannot decode the rule, "+err.Error())
+		return linter, lintMappingValue(root, "id")
+	}
+	library.ResolveRule(&linter.rule)
+
+	idNode := lintMappingValue(root, "id")
+	if linter.rule.Id == "" {
+		linter.report(root, LintError, "rule id is missing")
+	}
+
+	linter.lintUnknownKeys(root, reflect.TypeOf(Rule{}))
+	linter.lintInfo(lintMappingValue(root, "info"))
+	linter.lintSections(root)
+
+	//Check the condition and the tests with the same checks as the loader
+	if linter.rule.Condition != nil {
+		if err := CheckRuleCondition(linter.rule); err != nil {
+			linter.report(lintMappingValue(root, "condition"), LintError, "invalid condition, "+err.Error())
+		}
+	}
+	if err := CheckRuleTests(linter.rule.Tests); err != nil {
+		linter.report(lintMappingValue(root, "tests"), LintError, "invalid tests, "+err.Error())
+	}
+
+	return linter, idNode
+}
+
+// Gets the value node of the key from the mapping node
+// Returns nil if the node is not a mapping or the key is missing
+func lintMappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Gets the item of the sequence node
+// Returns nil if the node is not a sequence or the index is out of range
+func lintSequenceItem(node *yamlv3.Node, index int) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.SequenceNode || index >= len(node.Content) {
+		return nil
+	}
+	return node.Content[index]
+}
+
+// Gets the name of the field as it appears in the yaml file
+func lintYAMLFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Reports the keys which do not correspond to any field of the type
+func (rfl *ruleFileLinter) lintUnknownKeys(node *yamlv3.Node, t reflect.Type) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	//The condition operands are either matcher ids or nested conditions
+	if t == reflect.TypeOf(RuleConditionOperand{}) {
+		if node.Kind == yamlv3.MappingNode {
+			rfl.lintUnknownKeys(node, reflect.TypeOf(RuleCondition{}))
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			found := false
+			for f := 0; f < t.NumField(); f++ {
+				field := t.Field(f)
+				if field.Tag.Get("yaml") == "-" || lintYAMLFieldName(field) != key.Value {
+					continue
+				}
+				found = true
+				rfl.lintUnknownKeys(node.Content[i+1], field.Type)
+				break
+			}
+			if !found {
+				rfl.report(key, LintError, "unknown key "+key.Value)
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			rfl.lintUnknownKeys(item, t.Elem())
+		}
+	}
+}
+
+// Checks the info section of the rule
+func (rfl *ruleFileLinter) lintInfo(node *yamlv3.Node) {
+	info := rfl.rule.Info
+	if info == nil {
+		rfl.report(nil, LintError, "rule cannot have empty info")
+		return
+	}
+
+	switch strings.ToLower(info.Severity) {
+	case "low", "medium", "high", "critical":
+	default:
+		rfl.report(lintValueOrParent(node, "severity"), LintError, "unknown severity "+strconv.Quote(info.Severity)+", allowed values are low, medium, high, critical")
+	}
+
+	if info.Action != "" && strings.ToLower(info.Action) != "allow" && strings.ToLower(info.Action) != "drop" {
+		rfl.report(lintMappingValue(node, "action"), LintError, "unknown action "+strconv.Quote(info.Action)+", allowed values are allow, drop")
+	}
+
+	rfl.lintEncodings(lintMappingValue(node, "encodings"), info.Encodings)
+
+	for _, list := range ruleMetadataLists(info) {
+		listNode := lintMappingValue(node, list.key)
+		for i, value := range list.values {
+			if err := list.check(value); err != nil {
+				rfl.report(lintSequenceItem(listNode, i), LintError, err.Error())
+			}
+		}
+	}
+}
+
+// Gets the value node of the key or the parent node if the key is missing
+func lintValueOrParent(node *yamlv3.Node, key string) *yamlv3.Node {
+	if value := lintMappingValue(node, key); value != nil {
+		return value
+	}
+	return node
+}
+
+// Reports the encodings which are not registered transformations
+func (rfl *ruleFileLinter) lintEncodings(node *yamlv3.Node, encodings []string) {
+	for i, encoding := range encodings {
+		if !IsSupportedEncoding(encoding) {
+			rfl.report(lintSequenceItem(node, i), LintError, "unknown encoding "+strconv.Quote(encoding))
+		}
+	}
+}
+
+// Checks the request, response, websocket and tcp sections of the rule
+func (rfl *ruleFileLinter) lintSections(root *yamlv3.Node) {
+	rule := rfl.rule
+	if rule.Request == nil && rule.Response == nil && len(rule.Websocket) == 0 && len(rule.TCP) == 0 && rule.Expr == "" {
+		rfl.report(root, LintError, "rule has no request, response, websocket, tcp section or expr")
+		return
+	}
+
+	if err := CheckTransactionRule(rule); err != nil {
+		rfl.report(lintMappingValue(root, "transaction"), LintError, err.Error())
+	}
+	for _, err := range ruleLibraryErrors(rule) {
+		rfl.report(root, LintError, err.Error())
+	}
+	if err := CheckRuleExpression(rule); err != nil {
+		rfl.report(lintMappingValue(root, "expr"), LintError, "invalid expr, "+err.Error())
+	}
+	if err := CheckRuleExclusions(rule); err != nil {
+		rfl.report(lintMappingValue(root, "exclusions"), LintError, "invalid exclusions, "+err.Error())
+	}
+	if err := CheckRuleScope(rule.Scope); err != nil {
+		rfl.report(lintMappingValue(root, "scope"), LintError, "invalid scope, "+err.Error())
+	} else if rule.Scope != nil && rule.Request == nil && rule.Response == nil && rule.Expr == "" && len(rule.Scope.Hosts)+len(rule.Scope.Paths)+len(rule.Scope.PathRegexes)+len(rule.Scope.Methods) > 0 {
+		rfl.report(lintMappingValue(root, "scope"), LintWarning, "the hosts, paths and methods of the scope apply only to http, the rule will never be evaluated")
+	}
+
+	if rule.Request != nil {
+		node := lintMappingValue(root, "request")
+		hasTargets := len(rule.Request.Cookies) > 0
+		for _, target := range rule.Request.Targets() {
+			hasTargets = hasTargets || len(target.Matchers) > 0
+		}
+		if rule.Request.Method == nil && len(rule.Request.URL) == 0 && len(rule.Request.Headers) == 0 && len(rule.Request.Parameters) == 0 && len(rule.Request.Body) == 0 && !hasTargets {
+			rfl.report(node, LintError, "request section has no matchers")
+		}
+		if rule.Request.Method != nil {
+			method := lintMappingValue(node, "method")
+			rfl.lintMatcher(method, "method", rule.Request.Method.Match, rule.Request.Method.MatchFile, rule.Request.Method.Regex)
+			rfl.lintEncodings(lintMappingValue(method, "encodings"), rule.Request.Method.Encodings)
+		}
+		for i, urlRule := range rule.Request.URL {
+			item := lintSequenceItem(lintMappingValue(node, "url"), i)
+			rfl.lintMatcher(item, "url", urlRule.Match, urlRule.MatchFile, urlRule.Regex)
+			rfl.lintEncodings(lintMappingValue(item, "encodings"), urlRule.Encodings)
+		}
+		rfl.lintHeaders(lintMappingValue(node, "headers"), rule.Request.Headers)
+		for i, parameter := range rule.Request.Parameters {
+			item := lintSequenceItem(lintMappingValue(node, "params"), i)
+			if parameter.Name == "" && parameter.Selector == "" {
+				rfl.report(item, LintError, "parameter matcher has no name or selector, use any to match all the parameters")
+			}
+			if parameter.Name != "" && parameter.Selector != "" {
+				rfl.report(item, LintWarning, "parameter matcher has both name and selector, the name is ignored")
+			}
+			if parameter.Selector != "" {
+				if _, err := compileBodySelector(parameter.Selector); err != nil {
+					rfl.report(lintMappingValue(item, "selector"), LintError, err.Error())
+				}
+			}
+			rfl.lintMatcher(item, "parameter", parameter.Match, parameter.MatchFile, parameter.Regex)
+			rfl.lintEncodings(lintMappingValue(item, "encodings"), parameter.Encodings)
+		}
+		rfl.lintBody(lintMappingValue(node, "body"), rule.Request.Body)
+		for _, target := range rule.Request.Targets() {
+			for i, targetRule := range target.Matchers {
+				item := lintSequenceItem(lintMappingValue(node, target.Name), i)
+				rfl.lintMatcher(item, target.Name, targetRule.Match, targetRule.MatchFile, targetRule.Regex)
+				rfl.lintEncodings(lintMappingValue(item, "encodings"), targetRule.Encodings)
+			}
+		}
+		for i, cookie := range rule.Request.Cookies {
+			item := lintSequenceItem(lintMappingValue(node, "cookies"), i)
+			if cookie.Name == "" {
+				rfl.report(item, LintError, "cookie matcher has no name, use any to match all the cookies")
+			}
+			rfl.lintMatcher(item, "cookie", cookie.Match, cookie.MatchFile, cookie.Regex)
+			rfl.lintEncodings(lintMappingValue(item, "encodings"), cookie.Encodings)
+		}
+	}
+
+	if rule.Response != nil {
+		node := lintMappingValue(root, "response")
+		if rule.Response.Code == nil && len(rule.Response.Headers) == 0 && len(rule.Response.Body) == 0 && rule.Response.Latency == nil && rule.Response.TTFB == nil {
+			rfl.report(node, LintError, "response section has no matchers")
+		}
+		if rule.Response.Code != nil {
+			code := lintMappingValue(node, "code")
+			rfl.lintMatcher(code, "code", rule.Response.Code.Match, rule.Response.Code.MatchFile, rule.Response.Code.Regex)
+			rfl.lintEncodings(lintMappingValue(code, "encodings"), rule.Response.Code.Encodings)
+		}
+		rfl.lintHeaders(lintMappingValue(node, "headers"), rule.Response.Headers)
+		rfl.lintBody(lintMappingValue(node, "body"), rule.Response.Body)
+		if err := CheckDurationRule(rule.Response.Latency); err != nil {
+			rfl.report(lintMappingValue(node, "latency"), LintError, err.Error())
+		}
+		if err := CheckDurationRule(rule.Response.TTFB); err != nil {
+			rfl.report(lintMappingValue(node, "ttfb"), LintError, err.Error())
+		}
+	}
+
+	websocketNode := lintMappingValue(root, "websocket")
+	for i, wsRule := range rule.Websocket {
+		item := lintSequenceItem(websocketNode, i)
+		if wsRule.Match == "" && wsRule.Regex == "" && wsRule.HexMatch == "" && wsRule.HexRegex == "" {
+			rfl.report(item, LintError, "websocket matcher has no match, regex, hexmatch or hexregex")
+			continue
+		}
+		rfl.lintRegex(lintMappingValue(item, "regex"), "websocket", wsRule.Regex)
+		rfl.lintRegex(lintMappingValue(item, "hexregex"), "websocket", wsRule.HexRegex)
+	}
+
+	tcpNode := lintMappingValue(root, "tcp")
+	for i, tcpRule := range rule.TCP {
+		item := lintSequenceItem(tcpNode, i)
+		if tcpRule.Direction != "ingress" && tcpRule.Direction != "egress" {
+			rfl.report(lintValueOrParent(item, "direction"), LintError, "tcp direction should be ingress or egress")
+		}
+		if tcpRule.Match == "" && tcpRule.Regex == "" && tcpRule.HexMatch == "" && tcpRule.HexRegex == "" {
+			rfl.report(item, LintError, "tcp matcher has no match, regex, hexmatch or hexregex")
+			continue
+		}
+		rfl.lintRegex(lintMappingValue(item, "regex"), "tcp", tcpRule.Regex)
+		rfl.lintRegex(lintMappingValue(item, "hexregex"), "tcp", tcpRule.HexRegex)
+	}
+}
+
+// Checks the header matchers
+func (rfl *ruleFileLinter) lintHeaders(node *yamlv3.Node, headers []*HeadersRule) {
+	for i, header := range headers {
+		item := lintSequenceItem(node, i)
+		if header.Name == "" {
+			rfl.report(item, LintError, "header matcher has no name")
+		}
+		rfl.lintMatcher(item, "header", header.Match, header.MatchFile, header.Regex)
+		rfl.lintEncodings(lintMappingValue(item, "encodings"), header.Encodings)
+	}
+}
+
+// Checks the body matchers, which can also match on the hash of the body
+func (rfl *ruleFileLinter) lintBody(node *yamlv3.Node, bodyRules []*BodyRule) {
+	for i, bodyRule := range bodyRules {
+		item := lintSequenceItem(node, i)
+		if bodyRule.MD5Sum == "" && bodyRule.SHA256Sum == "" {
+			rfl.lintMatcher(item, "body", bodyRule.Match, bodyRule.MatchFile, bodyRule.Regex)
+		} else {
+			rfl.lintRegex(lintMappingValue(item, "regex"), "body", bodyRule.Regex)
+		}
+		rfl.lintEncodings(lintMappingValue(item, "encodings"), bodyRule.Encodings)
+	}
+}
+
+// Checks a matcher which should have a match string, a wordlist or a regex
+func (rfl *ruleFileLinter) lintMatcher(node *yamlv3.Node, kind string, match string, matchFile string, regex string) {
+	if match == "" && matchFile == "" && regex == "" {
+		rfl.report(node, LintError, kind+" matcher has no match, match_file or regex")
+		return
+	}
+	rfl.lintRegex(lintMappingValue(node, "regex"), kind, regex)
+}
+
+// Checks if the regex compiles, can match anything and is not prone to catastrophic backtracking
+func (rfl *ruleFileLinter) lintRegex(node *yamlv3.Node, kind string, regex string) {
+	if regex == "" {
+		return
+	}
+	if _, err := regexp.Compile(regex); err != nil {
+		rfl.report(node, LintError, "cannot compile "+kind+" regex, "+err.Error())
+		return
+	}
+	re, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return
+	}
+	if regexNeverMatches(re.Simplify()) {
+		rfl.report(node, LintError, kind+" regex "+strconv.Quote(regex)+" can never match")
+	}
+	if regexHasNestedQuantifiers(re, false) {
+		rfl.report(node, LintWarning, kind+" regex "+strconv.Quote(regex)+" has nested quantifiers, it is prone to catastrophic backtracking in backtracking regex engines")
+	}
+}
+
+// Checks if the regex can never match any string
+func regexNeverMatches(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return regexNeverMatches(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && regexNeverMatches(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !regexNeverMatches(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpConcat:
+		consumed := false
+		endReached := false
+		for _, sub := range re.Sub {
+			if regexNeverMatches(sub) {
+				return true
+			}
+			//The beginning of the text cannot follow characters
+			if sub.Op == syntax.OpBeginText && consumed {
+				return true
+			}
+			//Characters cannot follow the end of the text
+			if endReached && regexMinLength(sub) > 0 {
+				return true
+			}
+			if sub.Op == syntax.OpEndText {
+				endReached = true
+			}
+			if regexMinLength(sub) > 0 {
+				consumed = true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Gets the minimum number of characters a match of the regex has
+func regexMinLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return regexMinLength(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min * regexMinLength(re.Sub[0])
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			total += regexMinLength(sub)
+		}
+		return total
+	case syntax.OpAlternate:
+		minimum := -1
+		for _, sub := range re.Sub {
+			if length := regexMinLength(sub); minimum == -1 || length < minimum {
+				minimum = length
+			}
+		}
+		if minimum < 0 {
+			return 0
+		}
+		return minimum
+	default:
+		return 0
+	}
+}
+
+// Checks if the regex is an unbounded quantifier
+func regexIsUnbounded(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpStar || re.Op == syntax.OpPlus || (re.Op == syntax.OpRepeat && re.Max == -1)
+}
+
+// Checks if an unbounded quantifier is applied on an expression which is itself only an unbounded quantifier, like (a+)+ or (a*b?)*
+// Such expressions can split the same input in exponentially many ways, the expressions separated by a literal like (a+/)+ are not reported
+// @param re - the parsed regex
+// @param insideQuantifier - if the expression is the body of an unbounded quantifier
+func regexHasNestedQuantifiers(re *syntax.Regexp, insideQuantifier bool) bool {
+	//Only the captures are transparent, anything else breaks the nesting
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+
+	if insideQuantifier {
+		if regexIsUnbounded(re) {
+			return true
+		}
+		//A concatenation where everything except an unbounded quantifier can be empty
+		if re.Op == syntax.OpConcat {
+			unbounded := 0
+			for _, sub := range re.Sub {
+				for sub.Op == syntax.OpCapture {
+					sub = sub.Sub[0]
+				}
+				if regexIsUnbounded(sub) {
+					unbounded++
+				} else if regexMinLength(sub) > 0 {
+					unbounded = 0
+					break
+				}
+			}
+			if unbounded > 0 {
+				return true
+			}
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if regexHasNestedQuantifiers(sub, regexIsUnbounded(re)) {
+			return true
+		}
+	}
+	return false
+}
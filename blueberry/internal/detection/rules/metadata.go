@@ -0,0 +1,85 @@
+package detection
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"blueberry/internal/models"
+)
+
+// The formats of the identifiers in the metadata of the rules
+var (
+	cweRegex   = regexp.MustCompile(`^CWE-[0-9]+$`)                 //Like CWE-22
+	owaspRegex = regexp.MustCompile(`^(A|API)[0-9]{1,2}:[0-9]{4}$`) //Like A03:2021 or API1:2023
+	cveRegex   = regexp.MustCompile(`^CVE-[0-9]{4}-[0-9]{4,}$`)     //Like CVE-2021-44228
+)
+
+// Holds a list of the metadata of the rule with the check of its values
+type ruleMetadataList struct {
+	key    string               //The key of the list in the info section
+	values []string             //The values of the list
+	check  func(v string) error //Checks if a value of the list is valid
+}
+
+// Checks if the reference is an absolute http or https URL
+func checkReference(reference string) error {
+	parsed, err := url.Parse(reference)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("reference " + strconv.Quote(reference) + " should be an http or https URL")
+	}
+	return nil
+}
+
+// Creates the check of an identifier which should match the format
+func checkIdentifier(kind string, format *regexp.Regexp, example string) func(v string) error {
+	return func(value string) error {
+		if !format.MatchString(value) {
+			return errors.New(kind + " " + strconv.Quote(value) + " should be formatted like " + example)
+		}
+		return nil
+	}
+}
+
+// Gets the lists of the metadata of the rule info with the checks of their values
+func ruleMetadataLists(info *RuleInfo) []ruleMetadataList {
+	return []ruleMetadataList{
+		{key: "references", values: info.References, check: checkReference},
+		{key: "cwe", values: info.CWE, check: checkIdentifier("cwe", cweRegex, "CWE-22")},
+		{key: "owasp", values: info.OWASP, check: checkIdentifier("owasp", owaspRegex, "A03:2021")},
+		{key: "cve", values: info.CVE, check: checkIdentifier("cve", cveRegex, "CVE-2021-44228")},
+	}
+}
+
+// Checks if the metadata of the rule info is valid
+// @param info - the rule information structure
+// Returns an error if a reference is not an URL or a CWE, OWASP or CVE identifier is not formatted correctly
+func CheckRuleMetadata(info *RuleInfo) error {
+	for _, list := range ruleMetadataLists(info) {
+		for _, value := range list.values {
+			if err := list.check(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Copies the metadata of the rules into their findings
+// @param findings - the findings of the rules from the rule set of the runner
+func (rl *RuleRunner) addRuleMetadata(findings []*models.FindingData) {
+	for _, finding := range findings {
+		rule := rl.ruleSet.ruleById(finding.RuleId)
+		if rule == nil || rule.Info == nil {
+			continue
+		}
+		finding.References = rule.Info.References
+		finding.CWE = rule.Info.CWE
+		finding.OWASP = rule.Info.OWASP
+		finding.CVE = rule.Info.CVE
+		finding.Tags = rule.Info.Tags
+		finding.RuleAuthor = rule.Info.Author
+		finding.RuleVersion = rule.Info.Version
+	}
+}
@@ -0,0 +1,319 @@
+package detection
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+	"blueberry/internal/models"
+)
+
+// The upper bounds of the buckets of the rule evaluation time histogram, the last bucket holds the longer evaluations
+var ProfileHistogramBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	time.Second,
+}
+
+// The minimum number of evaluations of a rule before its percentiles are used to flag it as slow
+const SlowRuleMinEvaluations = 100
+
+// Holds the evaluation statistics of a rule
+type ruleProfile struct {
+	mutex       sync.Mutex              //Protects the statistics
+	evaluations int64                   //The number of evaluations
+	matches     int64                   //The number of evaluations which produced findings
+	total       time.Duration           //The total evaluation time
+	max         time.Duration           //The longest evaluation time
+	histogram   []int64                 //The number of evaluations in every bucket (one more than the bucket bounds)
+	slow        bool                    //If the 99th percentile exceeded the threshold
+	services    map[string]*ruleProfile //The statistics of the rule for every service, used to disable the rule only for the service it is slow on
+}
+
+// The key of a rule disabled for a service
+type disabledRuleKey struct {
+	service string //The name of the service (empty for the rule set with all the rules)
+	ruleId  string //The id of the rule
+}
+
+// Collects the evaluation statistics of the rules from all the handlers
+// The statistics are kept by rule id, they are reset when the rules are reloaded
+// A slow rule is disabled only for the service it was slow on, so the traffic of a service cannot disable the rules of the other services
+type RuleProfiler struct {
+	logger         logging.ILogger         //The logger interface
+	slowThreshold  time.Duration           //The 99th percentile above which a rule is flagged as slow
+	disableSlow    bool                    //If the slow rules should be disabled
+	mutex          sync.RWMutex            //Protects the profiles map
+	profiles       map[string]*ruleProfile //The statistics by rule id
+	disabled       sync.Map                //The rules disabled for a service (disabledRuleKey to true)
+	since          atomic.Int64            //The timestamp when the statistics started to be collected
+	budgetExceeded atomic.Int64            //The number of evaluations stopped by the time budget
+}
+
+// Creates a new rule profiler with the thresholds from the configuration
+// @param logger - the logger interface
+// @param configuration - the configuration structure
+func NewRuleProfiler(logger logging.ILogger, configuration config.Configuration) *RuleProfiler {
+	profiler := &RuleProfiler{logger: logger, profiles: make(map[string]*ruleProfile)}
+	if configuration.RuleConfig != nil {
+		profiler.slowThreshold = time.Duration(configuration.RuleConfig.SlowRuleThreshold * float64(time.Millisecond))
+		profiler.disableSlow = configuration.RuleConfig.DisableSlowRules
+	}
+	profiler.since.Store(time.Now().Unix())
+	return profiler
+}
+
+// Gets the statistics of the rule, creating them if the rule was not evaluated before
+func (rp *RuleProfiler) profile(ruleId string) *ruleProfile {
+	rp.mutex.RLock()
+	profile, ok := rp.profiles[ruleId]
+	rp.mutex.RUnlock()
+	if ok {
+		return profile
+	}
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	if profile, ok = rp.profiles[ruleId]; !ok {
+		profile = newRuleProfile()
+		profile.services = make(map[string]*ruleProfile)
+		rp.profiles[ruleId] = profile
+	}
+	return profile
+}
+
+// Creates the empty statistics of a rule
+func newRuleProfile() *ruleProfile {
+	return &ruleProfile{histogram: make([]int64, len(ProfileHistogramBuckets)+1)}
+}
+
+// Adds an evaluation to the statistics
+func (rpr *ruleProfile) add(duration time.Duration, matched bool) {
+	rpr.evaluations++
+	if matched {
+		rpr.matches++
+	}
+	rpr.total += duration
+	rpr.max = max(rpr.max, duration)
+	bucket := sort.Search(len(ProfileHistogramBuckets), func(i int) bool { return duration <= ProfileHistogramBuckets[i] })
+	rpr.histogram[bucket]++
+}
+
+// Checks if the statistics have enough evaluations and the 99th percentile exceeds the threshold
+// Returns if the rule is slow and the 99th percentile
+func (rpr *ruleProfile) isSlow(threshold time.Duration) (bool, time.Duration) {
+	if threshold <= 0 || rpr.evaluations < SlowRuleMinEvaluations {
+		return false, 0
+	}
+	p99 := rpr.percentile(0.99)
+	return p99 > threshold, p99
+}
+
+// Gets the upper bound of the bucket which contains the percentile of the evaluations
+// The evaluations in the last bucket do not have an upper bound so the longest evaluation is used
+func (rpr *ruleProfile) percentile(p float64) time.Duration {
+	if rpr.evaluations == 0 {
+		return 0
+	}
+	rank := int64(float64(rpr.evaluations)*p + 0.999999)
+	cumulative := int64(0)
+	for i, count := range rpr.histogram {
+		cumulative += count
+		if cumulative >= rank {
+			if i < len(ProfileHistogramBuckets) {
+				return min(ProfileHistogramBuckets[i], rpr.max)
+			}
+			break
+		}
+	}
+	return rpr.max
+}
+
+// Records an evaluation of the rule
+// The rule is flagged as slow when the 99th percentile of all the evaluations exceeds the threshold,
+// it is disabled (if configured) only for the service on which the 99th percentile of its evaluations exceeds the threshold
+// @param service - the name of the service the rule was evaluated for (empty for the rule set with all the rules)
+// @param ruleId - the id of the rule
+// @param duration - the time the evaluation took
+// @param matched - if the evaluation produced findings
+func (rp *RuleProfiler) record(service string, ruleId string, duration time.Duration, matched bool) {
+	if rp == nil {
+		return
+	}
+	profile := rp.profile(ruleId)
+
+	profile.mutex.Lock()
+	defer profile.mutex.Unlock()
+	profile.add(duration, matched)
+	serviceProfile, ok := profile.services[service]
+	if !ok {
+		serviceProfile = newRuleProfile()
+		profile.services[service] = serviceProfile
+	}
+	serviceProfile.add(duration, matched)
+
+	//Flag the rule only once, until the statistics are reset
+	if !profile.slow {
+		if slow, p99 := profile.isSlow(rp.slowThreshold); slow {
+			profile.slow = true
+			rp.logger.Warning("Rule", ruleId, "is slow, the 99th percentile of the evaluation time is", p99, "over", profile.evaluations, "evaluations")
+		}
+	}
+	//Disable the rule only for the service it is slow on
+	if !rp.disableSlow || serviceProfile.slow {
+		return
+	}
+	if slow, p99 := serviceProfile.isSlow(rp.slowThreshold); slow {
+		serviceProfile.slow = true
+		rp.disabled.Store(disabledRuleKey{service: service, ruleId: ruleId}, true)
+		rp.logger.Warning("Rule", ruleId, "was disabled for service", service, "because the 99th percentile of its evaluation time is", p99.String()+",", "it will be enabled again when the rules are reloaded")
+	}
+}
+
+// Records an evaluation stopped because it exceeded the time budget
+func (rp *RuleProfiler) recordBudgetExceeded() {
+	if rp == nil {
+		return
+	}
+	rp.budgetExceeded.Add(1)
+}
+
+// Checks if the rule was disabled for the service because it is slow
+// @param service - the name of the service (empty for the rule set with all the rules)
+// @param ruleId - the id of the rule
+func (rp *RuleProfiler) isDisabled(service string, ruleId string) bool {
+	if rp == nil {
+		return false
+	}
+	_, disabled := rp.disabled.Load(disabledRuleKey{service: service, ruleId: ruleId})
+	return disabled
+}
+
+// Removes all the statistics and enables the disabled rules
+// It is called when the rules are reloaded, since the rules could have been changed
+func (rp *RuleProfiler) Reset() {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	rp.profiles = make(map[string]*ruleProfile)
+	rp.disabled.Clear()
+	rp.budgetExceeded.Store(0)
+	rp.since.Store(time.Now().Unix())
+}
+
+// Converts the duration to milliseconds
+func durationToMilliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// Creates the report with the statistics of all the evaluated rules, the slowest rules (by the 99th percentile) first
+// @param agentId - the UUID of the agent
+func (rp *RuleProfiler) Report(agentId string) models.RulesProfileData {
+	report := models.RulesProfileData{AgentId: agentId, Timestamp: time.Now().Unix(), Since: rp.since.Load(), BudgetExceeded: rp.budgetExceeded.Load(), Rules: make([]*models.RuleProfileData, 0)}
+	for _, bound := range ProfileHistogramBuckets {
+		report.HistogramBuckets = append(report.HistogramBuckets, durationToMilliseconds(bound))
+	}
+
+	rp.mutex.RLock()
+	defer rp.mutex.RUnlock()
+	for ruleId, profile := range rp.profiles {
+		profile.mutex.Lock()
+		ruleReport := &models.RuleProfileData{RuleId: ruleId, Evaluations: profile.evaluations, Matches: profile.matches, TotalTime: durationToMilliseconds(profile.total), MaxTime: durationToMilliseconds(profile.max), P50: durationToMilliseconds(profile.percentile(0.5)), P90: durationToMilliseconds(profile.percentile(0.9)), P99: durationToMilliseconds(profile.percentile(0.99)), Histogram: append([]int64{}, profile.histogram...), Slow: profile.slow, DisabledServices: make([]string, 0)}
+		if profile.evaluations > 0 {
+			ruleReport.AverageTime = ruleReport.TotalTime / float64(profile.evaluations)
+		}
+		for service := range profile.services {
+			if rp.isDisabled(service, ruleId) {
+				ruleReport.DisabledServices = append(ruleReport.DisabledServices, service)
+			}
+		}
+		profile.mutex.Unlock()
+		sort.Strings(ruleReport.DisabledServices)
+		ruleReport.Disabled = len(ruleReport.DisabledServices) > 0
+		report.Rules = append(report.Rules, ruleReport)
+	}
+	sort.Slice(report.Rules, func(i, j int) bool {
+		if report.Rules[i].P99 != report.Rules[j].P99 {
+			return report.Rules[i].P99 > report.Rules[j].P99
+		}
+		return report.Rules[i].RuleId < report.Rules[j].RuleId
+	})
+	return report
+}
+
+// Starts the time budget of an evaluation of the rules
+func (rl *RuleRunner) startTimeBudget() {
+	rl.evaluation = nil
+	rl.deadline = time.Time{}
+	rl.outOfBudget = false
+	if rl.configuration.RuleConfig != nil && rl.configuration.RuleConfig.EvaluationTimeBudget > 0 {
+		rl.deadline = time.Now().Add(time.Duration(rl.configuration.RuleConfig.EvaluationTimeBudget) * time.Millisecond)
+	}
+}
+
+// Checks if the evaluation of the rules is still within the time budget
+// It is checked between the rules and while a rule searches the values, so a single slow rule is stopped as well
+// Once the budget is exceeded the remaining rules and values should be skipped until the next evaluation starts
+func (rl *RuleRunner) withinTimeBudget() bool {
+	if rl.outOfBudget {
+		return false
+	}
+	if rl.deadline.IsZero() || time.Now().Before(rl.deadline) {
+		return true
+	}
+	rl.exceedBudget("Rules evaluation exceeded the time budget of " + strconv.Itoa(rl.configuration.RuleConfig.EvaluationTimeBudget) + " ms, the remaining rules were skipped")
+	return false
+}
+
+// Stops the evaluation of the rules because it exceeded its budget, it is logged and counted
+// The action the service takes on the data is decided by the budget exceeded action of the service
+// @param reason - the message logged
+func (rl *RuleRunner) exceedBudget(reason string) {
+	rl.logger.Warning(reason)
+	rl.outOfBudget = true
+	rl.budgetExceeded = true
+	rl.ruleSet.profiler.recordBudgetExceeded()
+}
+
+// Starts timing the evaluation of the rule
+// @param compiled - the compiled rule
+// @param findings - the number of findings before the rule is evaluated
+// Returns false if the rule was disabled because it is slow, so it should be skipped
+func (rl *RuleRunner) startRuleEvaluation(compiled *compiledRule, findings int) bool {
+	if rl.ruleSet.profiler.isDisabled(rl.ruleSet.service, compiled.rule.Id) {
+		return false
+	}
+	if rl.ruleSet.profiler != nil {
+		rl.evaluation = &ruleEvaluation{ruleId: compiled.rule.Id, start: time.Now(), findings: findings}
+	}
+	return true
+}
+
+// Records the evaluation of the rule which is timed, if any
+// @param findings - the number of findings after the rule was evaluated
+func (rl *RuleRunner) finishRuleEvaluation(findings int) {
+	if rl.evaluation == nil {
+		return
+	}
+	rl.ruleSet.profiler.record(rl.ruleSet.service, rl.evaluation.ruleId, time.Since(rl.evaluation.start), findings > rl.evaluation.findings)
+	rl.evaluation = nil
+}
+
+// Checks if the evaluation of the rules was stopped because it exceeded the time budget
+func (rl *RuleRunner) BudgetExceeded() bool {
+	return rl.budgetExceeded
+}
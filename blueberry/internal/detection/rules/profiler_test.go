@@ -0,0 +1,73 @@
+package detection
+
+import (
+	"testing"
+	"time"
+)
+
+// Creates the statistics of a rule with the evaluation times
+func profileWith(durations ...time.Duration) *ruleProfile {
+	profile := newRuleProfile()
+	for _, duration := range durations {
+		profile.add(duration, false)
+	}
+	return profile
+}
+
+// Creates the evaluation times with the count of every duration
+func repeatDuration(duration time.Duration, count int) []time.Duration {
+	durations := make([]time.Duration, count)
+	for i := range durations {
+		durations[i] = duration
+	}
+	return durations
+}
+
+func TestRuleProfilePercentile(t *testing.T) {
+	tests := []struct {
+		name       string
+		durations  []time.Duration
+		percentile float64
+		expected   time.Duration
+	}{
+		{name: "no evaluations", percentile: 0.99, expected: 0},
+		{name: "bucket bound", durations: []time.Duration{30 * time.Microsecond, 3 * time.Second}, percentile: 0.5, expected: 50 * time.Microsecond},
+		{name: "bucket bound above the longest evaluation", durations: []time.Duration{3 * time.Microsecond, 4 * time.Microsecond}, percentile: 0.99, expected: 4 * time.Microsecond},
+		{name: "median", durations: append(repeatDuration(5*time.Microsecond, 50), repeatDuration(3*time.Millisecond, 50)...), percentile: 0.5, expected: 10 * time.Microsecond},
+		{name: "rank rounded up", durations: append(repeatDuration(5*time.Microsecond, 50), repeatDuration(3*time.Millisecond, 51)...), percentile: 0.5, expected: 3 * time.Millisecond},
+		{name: "99th percentile", durations: append(repeatDuration(5*time.Microsecond, 98), repeatDuration(3*time.Millisecond, 2)...), percentile: 0.99, expected: 3 * time.Millisecond},
+		{name: "last bucket", durations: []time.Duration{5 * time.Microsecond, 3 * time.Second}, percentile: 0.99, expected: 3 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := profileWith(test.durations...).percentile(test.percentile); result != test.expected {
+				t.Errorf("percentile(%v) = %v, expected %v", test.percentile, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestRuleProfileIsSlow(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		threshold time.Duration
+		expected  bool
+	}{
+		{name: "fast", durations: repeatDuration(5*time.Microsecond, SlowRuleMinEvaluations), threshold: time.Millisecond},
+		{name: "slow", durations: repeatDuration(3*time.Millisecond, SlowRuleMinEvaluations), threshold: time.Millisecond, expected: true},
+		{name: "too few evaluations", durations: repeatDuration(3*time.Millisecond, SlowRuleMinEvaluations-1), threshold: time.Millisecond},
+		{name: "disabled threshold", durations: repeatDuration(3*time.Millisecond, SlowRuleMinEvaluations)},
+		{name: "slow outliers below the 99th percentile", durations: append(repeatDuration(5*time.Microsecond, 99), 3*time.Millisecond), threshold: time.Millisecond},
+		{name: "slow outliers above the 99th percentile", durations: append(repeatDuration(5*time.Microsecond, 98), 3*time.Millisecond, 3*time.Millisecond), threshold: time.Millisecond, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if slow, _ := profileWith(test.durations...).isSlow(test.threshold); slow != test.expected {
+				t.Errorf("isSlow(%v) = %v, expected %v", test.threshold, slow, test.expected)
+			}
+		})
+	}
+}
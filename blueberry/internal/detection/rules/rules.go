@@ -14,6 +14,14 @@ type RuleInfo struct {
 	Classification string   `yaml:"classification"` //The classification if it matches, in the string representation
 	Action         string   `yaml:"action"`         //The action that should be taken if anything matches the rule (only for waf operation mode) (drop or allow)
 	Encodings      []string `yaml:"encodings"`      //The encodings supported when searching (this will apply to all the fields)
+	Tags           []string `yaml:"tags"`           //The tags used by the services to select the rules (case insensitive)
+	Priority       int      `yaml:"priority"`       //The rules with higher priority are evaluated first (the rules with the same priority keep the load order, the allow rules first)
+	References     []string `yaml:"references"`     //The URLs of the advisories and the documentation of the attack
+	CWE            []string `yaml:"cwe"`            //The CWE identifiers of the weakness (like CWE-22)
+	OWASP          []string `yaml:"owasp"`          //The OWASP Top 10 categories (like A03:2021)
+	CVE            []string `yaml:"cve"`            //The CVE identifiers of the vulnerabilities the rule detects (like CVE-2021-44228)
+	Author         string   `yaml:"author"`         //The author of the rule
+	Version        string   `yaml:"version"`        //The version of the rule
 }
 
 // Holds all the modes the hex search can be made
@@ -24,34 +32,58 @@ type RuleHexSearchMod struct {
 
 // Holds all the modes the search can be made
 type RuleSearchMode struct {
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Id        string   `yaml:"id"`         //The id of the matcher used in the rule condition
+	Match     string   `yaml:"match"`      //The string to match exactly
+	MatchFile string   `yaml:"match_file"` //The wordlist file (relative to the library directory) with the strings to match exactly, one per line
+	MatchList []string `yaml:"-"`          //The strings loaded from the wordlist file
+	Regex     string   `yaml:"regex"`      //The regex used for searching
+	Encodings []string `yaml:"encodings"`  //The encodings supported when searching
 }
 
 // Holds all the information about headers
 type HeadersRule struct {
-	Name      string   `yaml:"name"`      //The name of the search to search for matches
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Id        string   `yaml:"id"`         //The id of the matcher used in the rule condition
+	Name      string   `yaml:"name"`       //The name of the search to search for matches
+	Match     string   `yaml:"match"`      //The string to match exactly
+	MatchFile string   `yaml:"match_file"` //The wordlist file (relative to the library directory) with the strings to match exactly, one per line
+	MatchList []string `yaml:"-"`          //The strings loaded from the wordlist file
+	Regex     string   `yaml:"regex"`      //The regex used for searching
+	Encodings []string `yaml:"encodings"`  //The encodings supported when searching
 }
 
 // Holds all the information about request parameters
 type RequestParametersRule struct {
-	Name      string   `yaml:"name"`      //The name of the query variable (can be any which means look through all the query variable names for a match)
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Id        string   `yaml:"id"`         //The id of the matcher used in the rule condition
+	Name      string   `yaml:"name"`       //The name of the query variable (can be any which means look through all the query variable names for a match)
+	Selector  string   `yaml:"selector"`   //The JSONPath ($.user.name) or XPath (/user/name) selector of the body value to search (used instead of the name)
+	Match     string   `yaml:"match"`      //The string to match exactly
+	MatchFile string   `yaml:"match_file"` //The wordlist file (relative to the library directory) with the strings to match exactly, one per line
+	MatchList []string `yaml:"-"`          //The strings loaded from the wordlist file
+	Regex     string   `yaml:"regex"`      //The regex used for searching
+	Encodings []string `yaml:"encodings"`  //The encodings supported when searching
+}
+
+// Holds all the information about cookies
+type CookiesRule struct {
+	Id        string   `yaml:"id"`         //The id of the matcher used in the rule condition
+	Name      string   `yaml:"name"`       //The name of the cookie (can be any which means look through all the cookies for a match)
+	Match     string   `yaml:"match"`      //The string to match exactly
+	MatchFile string   `yaml:"match_file"` //The wordlist file (relative to the library directory) with the strings to match exactly, one per line
+	MatchList []string `yaml:"-"`          //The strings loaded from the wordlist file
+	Regex     string   `yaml:"regex"`      //The regex used for searching
+	Encodings []string `yaml:"encodings"`  //The encodings supported when searching
 }
 
 // Holds all the information about the body
 type BodyRule struct {
-	SHA256Sum string   `yaml:"sha256sum"` //The SHA256 hash of the body to match
-	MD5Sum    string   `yaml:"md5sum"`    //The MD5 hash of the body
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Id        string   `yaml:"id"`         //The id of the matcher used in the rule condition
+	SHA256Sum string   `yaml:"sha256sum"`  //The SHA256 hash of the body to match
+	MD5Sum    string   `yaml:"md5sum"`     //The MD5 hash of the body
+	Match     string   `yaml:"match"`      //The string to match exactly
+	MatchFile string   `yaml:"match_file"` //The wordlist file (relative to the library directory) with the strings to match exactly, one per line
+	MatchList []string `yaml:"-"`          //The strings loaded from the wordlist file
+	Regex     string   `yaml:"regex"`      //The regex used for searching
+	Encodings []string `yaml:"encodings"`  //The encodings supported when searching
 }
 
 // Holds all the information about the websocket rule
@@ -79,6 +111,38 @@ type RequestRule struct {
 	Headers    []*HeadersRule           `yaml:"headers"` //The headers to be checked
 	Parameters []*RequestParametersRule `yaml:"params"`  //The request parameters (both from URL and body)
 	Body       []*BodyRule              `yaml:"body"`    //The string to search for in the body
+	//URL components and request line
+	Path        []*RuleSearchMode `yaml:"path"`         //The modes to search on the decoded path
+	RawURI      []*RuleSearchMode `yaml:"raw_uri"`      //The modes to search on the request URI as sent by the client (path and query)
+	Query       []*RuleSearchMode `yaml:"query"`        //The modes to search on the raw query string
+	Filename    []*RuleSearchMode `yaml:"filename"`     //The modes to search on the last segment of the decoded path
+	Extension   []*RuleSearchMode `yaml:"extension"`    //The modes to search on the extension of the filename (without the dot)
+	Cookies     []*CookiesRule    `yaml:"cookies"`      //The cookies to be checked
+	RequestLine []*RuleSearchMode `yaml:"request_line"` //The modes to search on the request line (method, request URI and protocol)
+	Protocol    []*RuleSearchMode `yaml:"protocol"`     //The modes to search on the protocol version (HTTP/1.1)
+}
+
+// Holds the search modes of a request target which is a single value (like the path or the query string)
+type RequestTarget struct {
+	Name     string            //The name of the target in the rule file
+	Matchers []*RuleSearchMode //The modes to search on the value of the target
+}
+
+// Gets the URL components and the request line targets with their names from the rule file
+// Returns nil if the rule has no request section
+func (rr *RequestRule) Targets() []RequestTarget {
+	if rr == nil {
+		return nil
+	}
+	return []RequestTarget{
+		{Name: "path", Matchers: rr.Path},
+		{Name: "raw_uri", Matchers: rr.RawURI},
+		{Name: "query", Matchers: rr.Query},
+		{Name: "filename", Matchers: rr.Filename},
+		{Name: "extension", Matchers: rr.Extension},
+		{Name: "request_line", Matchers: rr.RequestLine},
+		{Name: "protocol", Matchers: rr.Protocol},
+	}
 }
 
 // Holds all the information in the response field of the rule YAML file
@@ -86,6 +150,8 @@ type ResponseRule struct {
 	Code    *RuleSearchMode `yaml:"code"`    //The modes to search on the status code
 	Headers []*HeadersRule  `yaml:"headers"` //The headers to be checked
 	Body    []*BodyRule     `yaml:"body"`    //The string to search for in the body
+	Latency *DurationRule   `yaml:"latency"` //The comparisons made on the time the upstream took to send the whole response
+	TTFB    *DurationRule   `yaml:"ttfb"`    //The comparisons made on the time the upstream took to send the first byte of the response
 }
 
 // Structure which holds all the information about the rule parsed from the rule.yaml file
@@ -96,6 +162,15 @@ type Rule struct {
 	Response  *ResponseRule    `yaml:"response"`  //The response matchers
 	Websocket []*WebsocketRule `yaml:"websocket"` //The websocket matchers
 	TCP       []*TCPRule       `yaml:"tcp"`       //The tcp matchers
+	Condition *RuleCondition   `yaml:"condition"` //The condition which combines the named request or response matchers (if missing any matcher is enough)
+	Scope     *RuleScope       `yaml:"scope"`     //The services, hosts, paths and methods the rule is evaluated on (if missing the rule applies to everything)
+	Expr      string           `yaml:"expr"`      //The expression which should be true on the transaction for the rule to fire (a rule with only an expression is evaluated on the request)
+	Tests     []*RuleTest      `yaml:"tests"`     //The test cases which prove the rule matches what it should
+	//The findings suppressed when the allow rule matches (if missing the allow rule stops the evaluation of the remaining rules)
+	Exclusions []*RuleExclusion `yaml:"exclusions"`
+	//If the rule fires only when the request and the response of the same transaction match (the successful exploitation of the request)
+	Transaction bool   `yaml:"transaction"`
+	Path        string `yaml:"-"` //The path of the rule file relative to the rules directory (set when the rule is loaded)
 }
 
 // Function to read the yaml rule from a reader into the struct
@@ -108,4 +183,5 @@ func (yr *Rule) FromYAML(r io.Reader) error {
 type BodyHashMatch struct {
 	BodyHash          string
 	BodyHashAlgorithm string
+	MatcherId         string
 }
@@ -0,0 +1,424 @@
+package detection
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/textproto"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/ahocorasick"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Holds a matcher (match string, regex and encodings) compiled when the rules are loaded
+type compiledMatcher struct {
+	id             string          //The id of the matcher used in the rule condition (empty if not specified)
+	name           string          //The name of the header or parameter the matcher applies to (empty if not applicable)
+	match          string          //The string to match exactly (case insensitive)
+	matchId        int             //The index of the match string in the literals automaton (-1 if there is no match string)
+	wordlist       []wordlistEntry //The entries of the match_file wordlist, matched like the match string
+	regex          *regexp.Regexp  //The compiled regex (nil if there is no regex)
+	regexLiteralId int             //The index of the literal every regex match contains in the literals automaton (-1 if the regex should always be run)
+	encodings      []string        //The encodings supported when searching
+	selector       *bodySelector   //The selector of the body values the parameter matcher applies to (nil if the matcher uses the name)
+}
+
+// Holds an entry of a wordlist with the index of the entry in the literals automaton
+type wordlistEntry struct {
+	match string //The entry to match exactly (case insensitive)
+	id    int    //The index of the entry in the literals automaton
+}
+
+// Holds a body matcher which can also match on the hash of the body
+type compiledBodyMatcher struct {
+	*compiledMatcher
+	md5Sum    string //The MD5 hash of the body to match (lowercase hex)
+	sha256Sum string //The SHA256 hash of the body to match (lowercase hex)
+}
+
+// Holds a tcp matcher with the direction it applies to
+type compiledTCPMatcher struct {
+	direction  string           //The direction of the communication (ingress or egress)
+	matcher    *compiledMatcher //The matcher built from match and regex
+	hexMatcher *compiledMatcher //The matcher built from hexmatch and hexregex
+}
+
+// Holds all the matchers of a rule compiled
+type compiledRule struct {
+	rule            *Rule                         //The rule the matchers were compiled from
+	method          *compiledMatcher              //The matcher for the request method
+	url             []*compiledMatcher            //The matchers for the request URL
+	requestHeaders  []*compiledMatcher            //The matchers for the request headers
+	parameters      []*compiledMatcher            //The matchers for the request parameters
+	requestBody     []*compiledBodyMatcher        //The matchers for the request body
+	targets         map[string][]*compiledMatcher //The matchers for the URL components and the request line by the name of the target
+	cookies         []*compiledMatcher            //The matchers for the request cookies
+	code            *compiledMatcher              //The matcher for the response status code
+	latency         *compiledDurationMatcher      //The matcher for the upstream response time
+	ttfb            *compiledDurationMatcher      //The matcher for the upstream time to first byte
+	responseHeaders []*compiledMatcher            //The matchers for the response headers
+	responseBody    []*compiledBodyMatcher        //The matchers for the response body
+	websocket       []*compiledMatcher            //The matchers for the websocket messages
+	tcp             []*compiledTCPMatcher         //The matchers for the tcp messages
+	condition       *RuleCondition                //The condition which combines the request or response matchers (nil if any matcher is enough)
+	responsePhase   bool                          //If the condition uses the response matchers, so it is evaluated on the response instead of the request
+	exclusions      []*compiledExclusion          //The exclusions applied when the allow rule matches
+	scope           *compiledScope                //The hosts, paths and methods the rule is evaluated on (nil if the rule applies to every request)
+	expression      *vm.Program                   //The compiled expression of the rule (nil if the rule has no expression)
+}
+
+// Immutable set of rules compiled when the rules are loaded
+// All the match strings and the literals required by the regexes are searched with a single automaton,
+// so a regex is only run when the literal it requires is present in the value
+type RuleSet struct {
+	rules          []Rule               //The rules the set was compiled from
+	compiledRules  []*compiledRule      //The compiled rules (in the order they are evaluated, by priority)
+	literals       *ahocorasick.Matcher //The automaton with all the lowercase literals (nil if there are no literals)
+	literalsCount  int                  //The number of literals in the automaton
+	rulesById      map[string]*Rule     //The rules by their id
+	profiler       *RuleProfiler        //The profiler which collects the evaluation statistics of the rules (nil if the rules are not profiled)
+	service        string               //The name of the service the rules were selected for (empty for the rule set with all the rules)
+	hasExpressions bool                 //If any rule has an expression
+}
+
+// Assigns the same index to identical literals when compiling the rule set
+type literalTable struct {
+	ids      map[string]int
+	literals []string
+}
+
+// Gets the index of the literal, adding it to the table if it is not already present
+func (lt *literalTable) add(literal string) int {
+	if id, ok := lt.ids[literal]; ok {
+		return id
+	}
+	lt.ids[literal] = len(lt.literals)
+	lt.literals = append(lt.literals, literal)
+	return len(lt.literals) - 1
+}
+
+// Compiles the rules into an immutable rule set
+// @param rules - the list of rules loaded from disk
+// Returns the rule set or an error if a regex cannot be compiled
+func NewRuleSet(rules []Rule) (*RuleSet, error) {
+	if rules == nil {
+		rules = make([]Rule, 0)
+	}
+	ruleSet := &RuleSet{rules: rules, compiledRules: make([]*compiledRule, 0, len(rules)), rulesById: make(map[string]*Rule, len(rules))}
+	table := &literalTable{ids: make(map[string]int), literals: make([]string, 0)}
+
+	for i := range ruleSet.rules {
+		compiled, err := compileRule(&ruleSet.rules[i], table)
+		if err != nil {
+			return nil, errors.New("cannot compile rule " + ruleSet.rules[i].Id + ", " + err.Error())
+		}
+		ruleSet.compiledRules = append(ruleSet.compiledRules, compiled)
+		ruleSet.rulesById[ruleSet.rules[i].Id] = &ruleSet.rules[i]
+		ruleSet.hasExpressions = ruleSet.hasExpressions || compiled.expression != nil
+	}
+	//Evaluate the rules with higher priority first so the allow rules can suppress the findings of the rules after them
+	sortCompiledRules(ruleSet.compiledRules)
+
+	//Build the automaton with all the literals of all the rules
+	if len(table.literals) > 0 {
+		ruleSet.literals = ahocorasick.NewStringMatcher(table.literals)
+	}
+
+	return ruleSet, nil
+}
+
+// Gets the rules the rule set was compiled from
+// The returned slice must not be modified, it is shared between all the handlers
+func (rs *RuleSet) Rules() []Rule {
+	return rs.rules
+}
+
+// Gets the rule with the id (nil if the rule is not part of the rule set)
+func (rs *RuleSet) ruleById(id string) *Rule {
+	return rs.rulesById[id]
+}
+
+// Gets the number of rules in the rule set
+func (rs *RuleSet) Count() int {
+	return len(rs.rules)
+}
+
+// Searches for all the literals in the value
+// @param value - the value to be searched uppon
+// Returns the set of the indexes of the literals found in the value (case insensitive)
+func (rs *RuleSet) findLiterals(value string) map[int]bool {
+	hits := make(map[int]bool)
+	if rs.literals == nil {
+		return hits
+	}
+	for _, id := range rs.literals.MatchThreadSafe([]byte(strings.ToLower(value))) {
+		hits[id] = true
+	}
+	return hits
+}
+
+// Compiles all the matchers of a rule
+func compileRule(rule *Rule, table *literalTable) (*compiledRule, error) {
+	compiled := &compiledRule{rule: rule, condition: rule.Condition, responsePhase: conditionOnResponse(*rule)}
+	var err error
+
+	compiled.exclusions, err = compileExclusions(rule.Exclusions)
+	if err != nil {
+		return nil, err
+	}
+	compiled.scope, err = compileScope(rule.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Expr != "" {
+		compiled.expression, err = compileExpression(rule.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.Request != nil {
+		if rule.Request.Method != nil {
+			compiled.method, err = compileMatcher(rule.Request.Method.Id, "", rule.Request.Method.Match, rule.Request.Method.MatchList, rule.Request.Method.Regex, rule.Request.Method.Encodings, table)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, urlRule := range rule.Request.URL {
+			matcher, err := compileMatcher(urlRule.Id, "", urlRule.Match, urlRule.MatchList, urlRule.Regex, urlRule.Encodings, table)
+			if err != nil {
+				return nil, err
+			}
+			compiled.url = append(compiled.url, matcher)
+		}
+		compiled.requestHeaders, err = compileHeaderMatchers(rule.Request.Headers, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, parameter := range rule.Request.Parameters {
+			matcher, err := compileMatcher(parameter.Id, parameter.Name, parameter.Match, parameter.MatchList, parameter.Regex, parameter.Encodings, table)
+			if err != nil {
+				return nil, err
+			}
+			if parameter.Selector != "" {
+				matcher.selector, err = compileBodySelector(parameter.Selector)
+				if err != nil {
+					return nil, err
+				}
+			}
+			compiled.parameters = append(compiled.parameters, matcher)
+		}
+		compiled.requestBody, err = compileBodyMatchers(rule.Request.Body, table)
+		if err != nil {
+			return nil, err
+		}
+		compiled.targets = make(map[string][]*compiledMatcher)
+		for _, target := range rule.Request.Targets() {
+			for _, targetRule := range target.Matchers {
+				matcher, err := compileMatcher(targetRule.Id, "", targetRule.Match, targetRule.MatchList, targetRule.Regex, targetRule.Encodings, table)
+				if err != nil {
+					return nil, err
+				}
+				compiled.targets[target.Name] = append(compiled.targets[target.Name], matcher)
+			}
+		}
+		for _, cookie := range rule.Request.Cookies {
+			matcher, err := compileMatcher(cookie.Id, cookie.Name, cookie.Match, cookie.MatchList, cookie.Regex, cookie.Encodings, table)
+			if err != nil {
+				return nil, err
+			}
+			compiled.cookies = append(compiled.cookies, matcher)
+		}
+	}
+
+	if rule.Response != nil {
+		if rule.Response.Code != nil {
+			compiled.code, err = compileMatcher(rule.Response.Code.Id, "", rule.Response.Code.Match, rule.Response.Code.MatchList, rule.Response.Code.Regex, rule.Response.Code.Encodings, table)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if rule.Response.Latency != nil {
+			compiled.latency, err = compileDurationMatcher(rule.Response.Latency)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if rule.Response.TTFB != nil {
+			compiled.ttfb, err = compileDurationMatcher(rule.Response.TTFB)
+			if err != nil {
+				return nil, err
+			}
+		}
+		compiled.responseHeaders, err = compileHeaderMatchers(rule.Response.Headers, table)
+		if err != nil {
+			return nil, err
+		}
+		compiled.responseBody, err = compileBodyMatchers(rule.Response.Body, table)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, wsRule := range rule.Websocket {
+		matcher, err := compileMatcher("", "", wsRule.Match, nil, wsRule.Regex, nil, table)
+		if err != nil {
+			return nil, err
+		}
+		compiled.websocket = append(compiled.websocket, matcher)
+	}
+
+	for _, tcpRule := range rule.TCP {
+		tcpMatcher := &compiledTCPMatcher{direction: tcpRule.Direction}
+		if tcpRule.Match != "" || tcpRule.Regex != "" {
+			tcpMatcher.matcher, err = compileMatcher("", "", tcpRule.Match, nil, tcpRule.Regex, nil, table)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if tcpRule.HexMatch != "" || tcpRule.HexRegex != "" {
+			tcpMatcher.hexMatcher, err = compileMatcher("", "", tcpRule.HexMatch, nil, tcpRule.HexRegex, nil, table)
+			if err != nil {
+				return nil, err
+			}
+		}
+		compiled.tcp = append(compiled.tcp, tcpMatcher)
+	}
+
+	return compiled, nil
+}
+
+// Compiles the header matchers, the header names are canonicalized so they can be compared with the request headers
+func compileHeaderMatchers(headers []*HeadersRule, table *literalTable) ([]*compiledMatcher, error) {
+	matchers := make([]*compiledMatcher, 0, len(headers))
+	for _, header := range headers {
+		matcher, err := compileMatcher(header.Id, textproto.CanonicalMIMEHeaderKey(header.Name), header.Match, header.MatchList, header.Regex, header.Encodings, table)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// Compiles the body matchers
+func compileBodyMatchers(bodyRules []*BodyRule, table *literalTable) ([]*compiledBodyMatcher, error) {
+	matchers := make([]*compiledBodyMatcher, 0, len(bodyRules))
+	for _, bodyRule := range bodyRules {
+		matcher, err := compileMatcher(bodyRule.Id, "", bodyRule.Match, bodyRule.MatchList, bodyRule.Regex, bodyRule.Encodings, table)
+		if err != nil {
+			return nil, err
+		}
+		md5Sum, err := normalizeBodyHash(bodyRule.MD5Sum, md5.Size, "md5sum")
+		if err != nil {
+			return nil, err
+		}
+		sha256Sum, err := normalizeBodyHash(bodyRule.SHA256Sum, sha256.Size, "sha256sum")
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, &compiledBodyMatcher{compiledMatcher: matcher, md5Sum: md5Sum, sha256Sum: sha256Sum})
+	}
+	return matchers, nil
+}
+
+// Normalizes the hash of the body to lowercase hex, so it is compared with the hash of the body as is
+// @param hash - the hex hash from the rule (empty if not specified)
+// @param size - the number of bytes of the hash
+// @param field - the name of the field of the hash, used in the error
+// Returns the normalized hash or an error if the hash is not a valid hex string of the size
+func normalizeBodyHash(hash string, size int, field string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if hash == "" {
+		return "", nil
+	}
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != size {
+		return "", errors.New(field + " should be a hex string of " + strconv.Itoa(size) + " bytes, " + hash)
+	}
+	return hash, nil
+}
+
+// Compiles a single matcher and adds its literals to the literals table
+// The entries of the wordlist are added to the same table as the match strings
+func compileMatcher(id string, name string, match string, matchList []string, regex string, encodings []string, table *literalTable) (*compiledMatcher, error) {
+	matcher := &compiledMatcher{id: id, name: name, matchId: -1, regexLiteralId: -1, encodings: encodings}
+
+	if match != "" {
+		matcher.match = match
+		matcher.matchId = table.add(strings.ToLower(match))
+	}
+
+	for _, entry := range matchList {
+		matcher.wordlist = append(matcher.wordlist, wordlistEntry{match: entry, id: table.add(strings.ToLower(entry))})
+	}
+
+	if regex != "" {
+		r, err := regexp.Compile(regex)
+		if err != nil {
+			return nil, errors.New("invalid regex " + regex + ", " + err.Error())
+		}
+		matcher.regex = r
+
+		//Use the literal every match of the regex contains as a prefilter
+		literal := requiredLiteral(regex)
+		if literal != "" {
+			matcher.regexLiteralId = table.add(literal)
+		}
+	}
+
+	return matcher, nil
+}
+
+// Gets the longest literal which is part of every match of the regex (lowercase)
+// Returns empty string if the regex does not require any literal
+func requiredLiteral(regex string) string {
+	re, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(longestRequiredLiteral(re.Simplify()))
+}
+
+// Walks the regex syntax tree to find the longest literal which has to be present in every match
+func longestRequiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return longestRequiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return longestRequiredLiteral(re.Sub[0])
+		}
+		return ""
+	case syntax.OpConcat:
+		longest := ""
+		current := ""
+		for _, sub := range re.Sub {
+			//Adjacent literals form a longer literal
+			if sub.Op == syntax.OpLiteral {
+				current += string(sub.Rune)
+				continue
+			}
+			if len(current) > len(longest) {
+				longest = current
+			}
+			current = ""
+			if literal := longestRequiredLiteral(sub); len(literal) > len(longest) {
+				longest = literal
+			}
+		}
+		if len(current) > len(longest) {
+			longest = current
+		}
+		return longest
+	default:
+		return ""
+	}
+}
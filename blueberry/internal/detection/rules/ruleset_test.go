@@ -0,0 +1,117 @@
+package detection
+
+import (
+	"testing"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+)
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		name     string
+		regex    string
+		expected string
+	}{
+		{name: "literal", regex: `etc/passwd`, expected: "etc/passwd"},
+		{name: "longest literal", regex: `union\s+select`, expected: "select"},
+		{name: "case insensitive literal", regex: `(?i)UNION\s+SELECT`, expected: "select"},
+		{name: "adjacent literals", regex: `/etc/(?i:PASSWD)`, expected: "/etc/passwd"},
+		{name: "adjacent literals with different flags", regex: `abc(?i:DEF)ghi`, expected: "abcdefghi"},
+		{name: "literal split by a capture", regex: `<scr(i)pt>`, expected: "<scr"},
+		{name: "literal before a group", regex: `/etc/(passwd|shadow)`, expected: "/etc/"},
+		{name: "literal in a repetition", regex: `(?:abc){2,}`, expected: "abc"},
+		{name: "literal in a plus", regex: `x(abc)+`, expected: "abc"},
+		{name: "last character repeated", regex: `abc+`, expected: "ab"},
+		{name: "alternation", regex: `admin|root`, expected: ""},
+		{name: "optional literal", regex: `(?:passwd)?`, expected: ""},
+		{name: "optional repetition", regex: `(?:passwd){0,3}`, expected: ""},
+		{name: "star", regex: `(?:passwd)*`, expected: ""},
+		{name: "character class", regex: `[a-z]+`, expected: ""},
+		{name: "invalid regex", regex: `(abc`, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if literal := requiredLiteral(test.regex); literal != test.expected {
+				t.Errorf("requiredLiteral(%q) = %q, expected %q", test.regex, literal, test.expected)
+			}
+		})
+	}
+}
+
+func TestSearchPrefilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		regex     string
+		encodings []string
+		value     string
+		matches   int
+	}{
+		{name: "literal in the value", regex: `union\s+select`, value: "1 union select 2", matches: 1},
+		{name: "case insensitive regex", regex: `(?i)union\s+select`, value: "1 UNION SELECT 2", matches: 1},
+		{name: "case sensitive regex", regex: `union\s+select`, value: "1 UNION SELECT 2"},
+		{name: "literal after url decoding", regex: `(?i)union\s+select`, encodings: []string{"url", "base64"}, value: "1%20UNION%20SEL%45CT%202", matches: 1},
+		{name: "literal after base64 decoding", regex: `(?i)union\s+select`, encodings: []string{"url", "base64"}, value: "MSBVTklPTiBTRUxFQ1QgMg==", matches: 1},
+		{name: "literal without the decoding", regex: `(?i)union\s+select`, value: "MSBVTklPTiBTRUxFQ1QgMg=="},
+		{name: "alternation", regex: `admin|root`, value: "user=root", matches: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := Rule{Id: "test", Info: &RuleInfo{Name: "test"}, Request: &RequestRule{URL: []*RuleSearchMode{{Regex: test.regex, Encodings: test.encodings}}}}
+			ruleSet, err := NewRuleSet([]Rule{rule})
+			if err != nil {
+				t.Fatalf("NewRuleSet() error = %v", err)
+			}
+			ruleRunner := NewRuleRunner(logging.NewDefaultLogger(), ruleSet, nil, config.Configuration{})
+			if matches := ruleRunner.search(test.value, ruleSet.compiledRules[0].url[0]); len(matches) != test.matches {
+				t.Errorf("search(%q) found %d matches, expected %d", test.value, len(matches), test.matches)
+			}
+		})
+	}
+}
+
+func TestCheckBodyHash(t *testing.T) {
+	tests := []struct {
+		name      string
+		bodyRule  *BodyRule
+		body      string
+		algorithm string
+	}{
+		{name: "md5", bodyRule: &BodyRule{MD5Sum: "5d41402abc4b2a76b9719d911017c592"}, body: "hello", algorithm: "MD5"},
+		{name: "uppercase md5", bodyRule: &BodyRule{MD5Sum: "5D41402ABC4B2A76B9719D911017C592"}, body: "hello", algorithm: "MD5"},
+		{name: "other body md5", bodyRule: &BodyRule{MD5Sum: "5d41402abc4b2a76b9719d911017c592"}, body: "hello world"},
+		{name: "sha256", bodyRule: &BodyRule{SHA256Sum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, body: "hello", algorithm: "SHA256"},
+		{name: "uppercase sha256", bodyRule: &BodyRule{SHA256Sum: "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"}, body: "hello", algorithm: "SHA256"},
+		{name: "other body sha256", bodyRule: &BodyRule{SHA256Sum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, body: "hello world"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := Rule{Id: "test", Info: &RuleInfo{Name: "test"}, Response: &ResponseRule{Body: []*BodyRule{test.bodyRule}}}
+			ruleSet, err := NewRuleSet([]Rule{rule})
+			if err != nil {
+				t.Fatalf("NewRuleSet() error = %v", err)
+			}
+			ruleRunner := NewRuleRunner(logging.NewDefaultLogger(), ruleSet, nil, config.Configuration{})
+			_, hashMatches, _ := ruleRunner.checkBody(test.body, ruleSet.compiledRules[0].responseBody)
+			algorithm := ""
+			if len(hashMatches) > 0 {
+				algorithm = hashMatches[0].BodyHashAlgorithm
+			}
+			if len(hashMatches) > 1 || algorithm != test.algorithm {
+				t.Errorf("checkBody() = %+v, expected a single %q hash match", hashMatches, test.algorithm)
+			}
+		})
+	}
+}
+
+func TestInvalidBodyHash(t *testing.T) {
+	for _, bodyRule := range []*BodyRule{{MD5Sum: "not a hash"}, {MD5Sum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, {SHA256Sum: "5d41402abc4b2a76b9719d911017c592"}} {
+		rule := Rule{Id: "test", Info: &RuleInfo{Name: "test"}, Response: &ResponseRule{Body: []*BodyRule{bodyRule}}}
+		if _, err := NewRuleSet([]Rule{rule}); err == nil {
+			t.Errorf("NewRuleSet() accepted the body hashes %q and %q", bodyRule.MD5Sum, bodyRule.SHA256Sum)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package detection
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+	"blueberry/internal/models"
+)
+
+// The expected outcomes of a rule test
+const (
+	RuleTestExpectMatch   = "match"
+	RuleTestExpectNoMatch = "no-match"
+)
+
+// Holds a tcp payload used in a rule test
+type RuleTestTCP struct {
+	Direction string `yaml:"direction"` //The direction of the payload (ingress or egress)
+	Data      string `yaml:"data"`      //The payload as text
+	HexData   string `yaml:"hexdata"`   //The payload as hex string (used when the payload is binary)
+}
+
+// Holds a test case embedded in the rule file
+// Exactly one of request, response or tcp should be specified, except for the transaction rules
+// which are tested with both the request and the response of the transaction
+type RuleTest struct {
+	Name     string       `yaml:"name"`     //The name of the test
+	Request  string       `yaml:"request"`  //The raw HTTP request
+	Response string       `yaml:"response"` //The raw HTTP response
+	TCP      *RuleTestTCP `yaml:"tcp"`      //The tcp payload
+	Latency  string       `yaml:"latency"`  //The simulated upstream response time (like 6s) used with the response
+	TTFB     string       `yaml:"ttfb"`     //The simulated upstream time to first byte (like 6s) used with the response
+	Expect   string       `yaml:"expect"`   //The expected outcome (match or no-match)
+}
+
+// Holds the outcome of a rule test
+type RuleTestResult struct {
+	RuleId   string //The id of the rule the test belongs to
+	TestName string //The name of the test
+	Passed   bool   //If the outcome was the expected one
+	Expected string //The expected outcome
+	Actual   string //The actual outcome (match, no-match or error)
+	Error    string //The error which occured when running the test (empty if no error occured)
+}
+
+// Checks if the tests of the rule are valid
+// @param tests - the tests specified in the rule
+// Returns an error if any of the tests is not valid
+func CheckRuleTests(tests []*RuleTest) error {
+	for i, test := range tests {
+		name := ruleTestName(test, i)
+
+		if test.Expect != RuleTestExpectMatch && test.Expect != RuleTestExpectNoMatch {
+			return errors.New("test " + name + " expect should be match or no-match")
+		}
+
+		//Count the payloads specified in the test
+		payloads := 0
+		if test.Request != "" {
+			payloads++
+		}
+		if test.Response != "" {
+			payloads++
+		}
+		if test.TCP != nil {
+			payloads++
+			if test.TCP.Direction != "ingress" && test.TCP.Direction != "egress" {
+				return errors.New("test " + name + " tcp direction can be ingress or egress")
+			}
+			if test.TCP.HexData != "" {
+				if _, err := hex.DecodeString(test.TCP.HexData); err != nil {
+					return errors.New("test " + name + " tcp hexdata is not valid, " + err.Error())
+				}
+			}
+		}
+		if (test.Latency != "" || test.TTFB != "") && test.Response == "" {
+			return errors.New("test " + name + " latency and ttfb can be specified only with a response")
+		}
+		if _, err := ruleTestTimings(test); err != nil {
+			return errors.New("test " + name + " " + err.Error())
+		}
+		//The request and the response of a transaction can be tested together
+		if payloads == 2 && test.Request != "" && test.Response != "" {
+			continue
+		}
+		if payloads != 1 {
+			return errors.New("test " + name + " should specify exactly one of request, response or tcp, or both request and response")
+		}
+	}
+	return nil
+}
+
+// Runs the tests embedded in the rules
+// Every rule is tested in isolation, a test passes if the rule has (or does not have) findings on the payload as expected
+// @param rules - the rules which contain the tests
+// @param logger - the logger used by the rule runner
+// @param configuration - the configuration used by the rule runner
+// Returns the results of all the tests in the order of the rules
+func RunRuleTests(rules []Rule, logger logging.ILogger, configuration config.Configuration) []RuleTestResult {
+	results := make([]RuleTestResult, 0)
+
+	for _, rule := range rules {
+		if len(rule.Tests) == 0 {
+			continue
+		}
+
+		//Compile only the tested rule so the findings of the other rules cannot influence the result
+		ruleSet, err := NewRuleSet([]Rule{rule})
+		if err != nil {
+			for i, test := range rule.Tests {
+				results = append(results, RuleTestResult{RuleId: rule.Id, TestName: ruleTestName(test, i), Expected: test.Expect, Actual: "error", Error: err.Error()})
+			}
+			continue
+		}
+
+		for i, test := range rule.Tests {
+			result := RuleTestResult{RuleId: rule.Id, TestName: ruleTestName(test, i), Expected: test.Expect}
+
+			//Every test has its own runner so the caches are not shared between the tests
+			ruleRunner := NewRuleRunner(logger, ruleSet, nil, configuration)
+			findings, err := runRuleTest(ruleRunner, test)
+			if err != nil {
+				result.Actual = "error"
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+
+			result.Actual = RuleTestExpectNoMatch
+			for _, finding := range findings {
+				if finding.RuleId == rule.Id {
+					result.Actual = RuleTestExpectMatch
+					break
+				}
+			}
+			result.Passed = result.Actual == result.Expected
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+// Gets the name of the test, the tests without a name are identified by their position in the rule
+func ruleTestName(test *RuleTest, index int) string {
+	if test.Name != "" {
+		return test.Name
+	}
+	return "#" + strconv.Itoa(index+1)
+}
+
+// Gets the simulated upstream timings of the test
+// Returns the timings (nil if the test does not specify them) or an error if a duration is not valid
+func ruleTestTimings(test *RuleTest) (*UpstreamTimings, error) {
+	if test.Latency == "" && test.TTFB == "" {
+		return nil, nil
+	}
+	timings := &UpstreamTimings{}
+	var err error
+	if test.Latency != "" {
+		if timings.ResponseTime, err = time.ParseDuration(test.Latency); err != nil {
+			return nil, errors.New("latency is not valid, " + err.Error())
+		}
+	}
+	if test.TTFB != "" {
+		if timings.TimeToFirstByte, err = time.ParseDuration(test.TTFB); err != nil {
+			return nil, errors.New("ttfb is not valid, " + err.Error())
+		}
+	}
+	return timings, nil
+}
+
+// Runs the rule runner on the payload of the test
+func runRuleTest(ruleRunner *RuleRunner, test *RuleTest) ([]*models.FindingData, error) {
+	timings, err := ruleTestTimings(test)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case test.Request != "" && test.Response != "":
+		//Check the request and the response with the same runner, like the http handler does
+		request, err := ParseRawRequest(test.Request)
+		if err != nil {
+			return nil, err
+		}
+		requestFindings, err := ruleRunner.RunRulesOnRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		response, err := ParseRawResponse(test.Response)
+		if err != nil {
+			return nil, err
+		}
+		responseFindings, err := ruleRunner.RunRulesOnResponse(response, timings)
+		if err != nil {
+			return nil, err
+		}
+		return append(requestFindings, responseFindings...), nil
+	case test.Request != "":
+		request, err := ParseRawRequest(test.Request)
+		if err != nil {
+			return nil, err
+		}
+		return ruleRunner.RunRulesOnRequest(request)
+	case test.Response != "":
+		response, err := ParseRawResponse(test.Response)
+		if err != nil {
+			return nil, err
+		}
+		return ruleRunner.RunRulesOnResponse(response, timings)
+	case test.TCP != nil:
+		data := []byte(test.TCP.Data)
+		if test.TCP.HexData != "" {
+			data, _ = hex.DecodeString(test.TCP.HexData)
+		}
+		return ruleRunner.ApplyRulesOnTCPMessage(test.TCP.Direction, data)
+	default:
+		return nil, errors.New("test does not contain a payload")
+	}
+}
+
+// Splits the raw HTTP message in the head and the body
+// The line endings of the head are normalized so the messages can be written in YAML without carriage returns
+func splitRawHTTPMessage(raw string) (string, string) {
+	normalized := strings.ReplaceAll(raw, "\r\n", "\n")
+	head, body, found := strings.Cut(normalized, "\n\n")
+	if !found {
+		return strings.ReplaceAll(strings.TrimRight(head, "\n"), "\n", "\r\n") + "\r\n\r\n", ""
+	}
+	//The YAML block scalars end with a new line which is not part of the body
+	body = strings.TrimSuffix(body, "\n")
+	return strings.ReplaceAll(head, "\n", "\r\n") + "\r\n\r\n", body
+}
+
+// Parses a raw HTTP request, the Content-Length is computed from the body
+// @param raw - the raw request (request line, headers, empty line and body)
+// Returns the parsed request or an error if the request line or the headers are not valid
+func ParseRawRequest(raw string) (*http.Request, error) {
+	head, body := splitRawHTTPMessage(raw)
+	request, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head)))
+	if err != nil {
+		return nil, errors.New("invalid raw request, " + err.Error())
+	}
+	request.Body = io.NopCloser(strings.NewReader(body))
+	request.ContentLength = int64(len(body))
+	if body != "" {
+		request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return request, nil
+}
+
+// Parses a raw HTTP response, the Content-Length is computed from the body
+// @param raw - the raw response (status line, headers, empty line and body)
+// Returns the parsed response or an error if the status line or the headers are not valid
+func ParseRawResponse(raw string) (*http.Response, error) {
+	head, body := splitRawHTTPMessage(raw)
+	response, err := http.ReadResponse(bufio.NewReader(strings.NewReader(head)), nil)
+	if err != nil {
+		return nil, errors.New("invalid raw response, " + err.Error())
+	}
+	response.Body = io.NopCloser(strings.NewReader(body))
+	response.ContentLength = int64(len(body))
+	if body != "" {
+		response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return response, nil
+}
@@ -4,13 +4,11 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"io"
 	"net/http"
-	"net/url"
-	"reflect"
-	"regexp"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,120 +18,157 @@ import (
 	"blueberry/internal/utils"
 	"blueberry/internal/websocket"
 
-	"github.com/mowshon/iterium"
+	"github.com/expr-lang/expr/vm"
 )
 
 // Structure which will hold all the necessary data to match the rules on the request and the response
+// A rule runner should be used for a single request/message, it caches the literals found in the values it searched
+// For HTTP the same rule runner should check the request and the response of a transaction, so the transaction rules can correlate them
 type RuleRunner struct {
-	logger        logging.ILogger
-	rules         []Rule
-	apiWsConn     *websocket.APIWebSocketConnection
-	configuration config.Configuration
+	logger              logging.ILogger
+	ruleSet             *RuleSet
+	apiWsConn           *websocket.APIWebSocketConnection
+	configuration       config.Configuration
+	literalsCache       map[string]map[int]bool                 //The literals found in the values which were already searched
+	decodeCache         map[decodeCacheKey][]decodedValue       //The decodings of the values which were already decoded
+	transactionRequests map[string][]transactionRequestMatch    //The request matches of the transaction rules by rule id
+	activeExclusions    []activeExclusion                       //The exclusions of the allow rules which matched on the transaction
+	suppressions        []*models.SuppressionData               //The rule findings suppressed by the exclusions
+	allowedBy           string                                  //The id of the allow rule which stopped the evaluation of the remaining rules
+	request             *scopeRequest                           //The values of the request used by the scope of the rules and the exclusions (nil if the data is not part of an HTTP request)
+	evaluation          *ruleEvaluation                         //The evaluation of the rule which is timed (nil if no rule is timed)
+	deadline            time.Time                               //The time the evaluation of the rules should stop (zero if there is no time budget)
+	outOfBudget         bool                                    //If the current evaluation of the rules exceeded its budget, the remaining rules and values are skipped
+	budgetExceeded      bool                                    //If any evaluation of the rules by the runner was stopped because it exceeded its budget
+	expression          *ExpressionEnvironment                  //The values of the transaction the expressions of the rules are evaluated on (nil until a rule with an expression is evaluated)
+	expressionVM        vm.VM                                   //The VM which evaluates the expressions of the rules, reused by all the evaluations of the runner
+	requestFindings     []*models.FindingData                   //The findings of the request, exposed to the expressions evaluated on the response
+	evidenceContexts    map[*models.FindingData]evidenceContext //The values the findings were found in, until the findings are located
+}
+
+// The key used to cache the decodings of a value
+type decodeCacheKey struct {
+	encodings string //The list of encodings joined by comma
+	value     string //The value which was decoded
+}
+
+// Holds a string on which a rule matched and the decodings which were applied on the value before matching
+type searchMatch struct {
+	matcherId     string   //The id of the matcher which found the match (empty if not specified)
+	matchedString string   //The string on which the rule matched
+	decodingChain []string //The decodings applied on the value (empty if the match was on the original value)
+	unlocated     bool     //If the match is not part of the raw data (like the upstream latency) so it cannot be located
+	parameter     string   //The name of the parameter the match was found on (empty if it was not found on a parameter)
+	target        string   //The target the match was found on (like header:User-Agent or body.json:$.user.name)
+	evidence      string   //The original (not decoded) snippet of the value which matched
+	offset        int      //The byte offset of the evidence in the searched value
+	value         string   //The original value which was searched
+}
+
+// Holds the evaluation of a rule which is timed for the rule profiler
+type ruleEvaluation struct {
+	ruleId   string    //The id of the rule
+	start    time.Time //The time the evaluation started
+	findings int       //The number of findings before the rule was evaluated
 }
 
 // Creates a new rule runner struct
-func NewRuleRunner(logger logging.ILogger, rules []Rule, apiWsConn *websocket.APIWebSocketConnection, configuration config.Configuration) *RuleRunner {
-	return &RuleRunner{logger: logger, rules: rules, apiWsConn: apiWsConn, configuration: configuration}
+func NewRuleRunner(logger logging.ILogger, ruleSet *RuleSet, apiWsConn *websocket.APIWebSocketConnection, configuration config.Configuration) *RuleRunner {
+	return &RuleRunner{logger: logger, ruleSet: ruleSet, apiWsConn: apiWsConn, configuration: configuration, literalsCache: make(map[string]map[int]bool), decodeCache: make(map[decodeCacheKey][]decodedValue), transactionRequests: make(map[string][]transactionRequestMatch), suppressions: make([]*models.SuppressionData, 0)}
+}
+
+// Gets the literals of the rule set found in the value
+// The automaton is run only once for every distinct value
+func (rl *RuleRunner) findLiterals(value string) map[int]bool {
+	if literals, ok := rl.literalsCache[value]; ok {
+		return literals
+	}
+	literals := rl.ruleSet.findLiterals(value)
+	rl.literalsCache[value] = literals
+	return literals
 }
 
 // Decodes the value string using the encodings of the rule
-// Creates all the possible permutation of the list of encodings specified (length 1, 2 ...)
+// The decoding chains are explored breadth first up to the maximum decoding depth, number of decoded values and decoded bytes from the configuration
+// The result is cached so a value is decoded only once for a list of encodings
 // @param value - the value to be decoded
 // @param encodings - the list of encodings
-// Returns the list of possible decodings of the value
-func (rl *RuleRunner) decodeString(value string, encodings []string) ([]string, error) {
-	//Create the return list of decoded strings
-	decodedStrings := make([]string, 0)
-	//The first element in the list should be the unmodified string (len 0 of the encodings permutations)
-	decodedStrings = append(decodedStrings, value)
-
-	//Check if the list of encodings is not empty
-	if encodings != nil {
-		//Create the list of possible permutations
-		for i := 0; i < len(encodings); i++ {
-			sizeIPermutations := iterium.Permutations(encodings, i)
-			encPermutations, err := sizeIPermutations.Slice()
-			//Check if an error occured
-			if err != nil {
-				//Return the original value and the error
-				return decodedStrings, err
-			}
-			//For each permutation of the possible encodings
-			for _, permutation := range encPermutations {
-				//Create the result so that chained decoding can be made
-				var decString string = value
-				//Apply the decodings specified in the permutation
-				for _, decoding := range permutation {
-					//Do the correct decoding
-					switch decoding {
-					case "base64":
-						//Decode the string from base64
-						decodedString, err := base64.StdEncoding.DecodeString(decString)
-						if err != nil {
-							//The decoding failed from base64 so continue with the other decodings
-							continue
-						}
-						//Update the final decoded string
-						decString = string(decodedString)
-					case "url":
-						//Decode the string from url
-						decodedString, err := url.QueryUnescape(decString)
-						if err != nil {
-							//The decoding failed from url so continue with other decodings
-							continue
-						}
-						//Update the final decoded string
-						decString = decodedString
-					}
-				}
-				//Apend the decoded string to the list of decoded strings
-				decodedStrings = append(decodedStrings, decString)
-			}
+// Returns the original value followed by the distinct decodings of the value
+func (rl *RuleRunner) decodeString(value string, encodings []string) []decodedValue {
+	//Nothing to decode
+	if len(encodings) == 0 {
+		return []decodedValue{{value: value, decodingChain: []string{}}}
+	}
+
+	key := decodeCacheKey{encodings: strings.Join(encodings, ","), value: value}
+	if decoded, ok := rl.decodeCache[key]; ok {
+		return decoded
+	}
+
+	//Get the limits of the decoding chains from the configuration
+	limits := decodingLimits{maxDepth: DefaultMaxDecodingDepth, maxValues: DefaultMaxDecodedValues, maxBytes: DefaultMaxDecodedBytes}
+	if rl.configuration.RuleConfig != nil {
+		if rl.configuration.RuleConfig.MaxDecodingDepth > 0 {
+			limits.maxDepth = rl.configuration.RuleConfig.MaxDecodingDepth
+		}
+		if rl.configuration.RuleConfig.MaxDecodedValues > 0 {
+			limits.maxValues = rl.configuration.RuleConfig.MaxDecodedValues
+		}
+		if rl.configuration.RuleConfig.MaxDecodedBytes > 0 {
+			limits.maxBytes = rl.configuration.RuleConfig.MaxDecodedBytes
 		}
 	}
 
-	//Return the list of decoded strings using the specified encodings
-	return decodedStrings, nil
+	decoded, truncated := decodeChains(value, encodings, limits)
+	if truncated {
+		rl.logger.Debug("Decoding chains of a value of", len(value), "bytes stopped after", len(decoded)-1, "decoded values, the limits were reached")
+	}
+	rl.decodeCache[key] = decoded
+	return decoded
 }
 
 // Searches for case insensitive match on the value or if the regex can find any matches on the given value
+// The regex is run only if the literal it requires was found in the value
 // @param value - the value to be searched uppon
-// @param mode - the rule search specification
-// Returns the list of matches it found
-func (rl *RuleRunner) search(value string, mode *RuleSearchMode) []string {
-	allMatches := make([]string, 0)
+// @param matcher - the compiled rule search specification
+// Returns the list of matches it found, with the decodings applied to find them
+func (rl *RuleRunner) search(value string, matcher *compiledMatcher) []searchMatch {
+	allMatches := make([]searchMatch, 0)
 
 	//Get all the possible decodings of the value
-	decodedStrings, _ := rl.decodeString(value, mode.Encodings)
+	decodedValues := rl.decodeString(value, matcher.encodings)
 
 	//Check if any of the decoded string matches the rule conditions
-	for _, decString := range decodedStrings {
-		//Check if the exact match is specified
-		if mode.Match != "" {
-			//Check if the value contains the match string (case insensitive)
-			if strings.Contains(strings.ToLower(decString), strings.ToLower(mode.Match)) {
-				//Add the match to the list of matches
-				allMatches = append(allMatches, mode.Match)
-			}
+	for _, decValue := range decodedValues {
+		//Stop searching when the evaluation exceeded the time budget
+		if !rl.withinTimeBudget() {
+			break
+		}
+		//Get the literals found in the decoded string
+		literals := rl.findLiterals(decValue.value)
+
+		//Check if the value contains the match string (case insensitive)
+		if matcher.matchId != -1 && literals[matcher.matchId] {
+			//Add the match to the list of matches
+			start := indexFold(decValue.value, matcher.match)
+			allMatches = append(allMatches, newSearchMatch(matcher.id, matcher.match, value, decValue, start, start+len(matcher.match)))
 		}
 
-		//Check if the regex match is specified
-		if mode.Regex != "" {
-			//Compile the regex
-			r, err := regexp.Compile(mode.Regex)
-			//Check if an error occured during the regex compilation
-			if err != nil {
-				rl.logger.Error("Could not the compile regex matcher from the rule, invalid regex:", mode.Regex)
-				return nil
+		//Check if the value contains any of the wordlist entries (case insensitive)
+		for _, entry := range matcher.wordlist {
+			if literals[entry.id] {
+				start := indexFold(decValue.value, entry.match)
+				allMatches = append(allMatches, newSearchMatch(matcher.id, entry.match, value, decValue, start, start+len(entry.match)))
 			}
+		}
+
+		//Check if the regex match is specified and the literal it requires is present
+		if matcher.regex != nil && (matcher.regexLiteralId == -1 || literals[matcher.regexLiteralId]) {
 			//Find all the matches for the regex
-			matches := r.FindAllString(decString, -1)
-			//rl.logger.Debug("Value:", value, "Regex:", mode.Regex, "Matches:", matches)
-			//Check if there were any matches
-			if len(matches) > 0 {
-				//rl.logger.Debug("Regex,", mode.Regex, "matched on", value)
-				//Add the matches to the list of matches
-				allMatches = append(allMatches, matches...)
+			matches := matcher.regex.FindAllStringIndex(decValue.value, -1)
+			//Add the matches to the list of matches
+			for _, match := range matches {
+				allMatches = append(allMatches, newSearchMatch(matcher.id, decValue.value[match[0]:match[1]], value, decValue, match[0], match[1]))
 			}
 		}
 	}
@@ -144,65 +179,140 @@ func (rl *RuleRunner) search(value string, mode *RuleSearchMode) []string {
 
 // Checks if the method matches any of the rule matching specification
 // @param method - the method to be searched uppon
-// @param ruleMethod - the rule search specification
+// @param ruleMethod - the compiled rule search specification
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkMethod(method string, ruleMethod *RuleSearchMode) ([]string, error) {
+func (rl *RuleRunner) checkMethod(method string, ruleMethod *compiledMatcher) ([]searchMatch, error) {
 	//Check if the rule has a method specification
 	if ruleMethod == nil {
 		//Return an empty list of matches
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 	//Search in the method for any matches
-	matches := rl.search(method, ruleMethod)
+	matches := withTarget(rl.search(method, ruleMethod), evidenceTargetMethod)
+	return matches, nil
+}
+
+// Checks if the status code of the response matches the rule matching specification
+// @param code - the status code to be searched uppon
+// @param ruleCode - the compiled rule search specification
+// Returns the list of matches or an error if something occured
+func (rl *RuleRunner) checkCode(code string, ruleCode *compiledMatcher) ([]searchMatch, error) {
+	//Check if the rule has a status code specification
+	if ruleCode == nil {
+		//Return an empty list of matches
+		return make([]searchMatch, 0), nil
+	}
+	//Search in the status code for any matches
+	matches := withTarget(rl.search(code, ruleCode), evidenceTargetStatus)
 	return matches, nil
 }
 
 // Checks if the URL of the request matches any of the rule matching specification
 // @param url - the URL to be searched uppon
-// @param ruleURL - the rule search specification
+// @param ruleURL - the compiled rule search specifications
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkURL(url string, ruleURL []*RuleSearchMode) ([]string, error) {
+func (rl *RuleRunner) checkURL(url string, ruleURL []*compiledMatcher) ([]searchMatch, error) {
 	//Check if the rule has a URL specification
 	if ruleURL == nil {
 		//Return an empty match list
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Initialize the return list of matches
-	ret_matches := make([]string, 0)
+	ret_matches := make([]searchMatch, 0)
 
 	for _, rule := range ruleURL {
 		//Search in the URL path for any matches
-		matches := rl.search(url, rule)
+		matches := withTarget(rl.search(url, rule), evidenceTargetURL)
 		ret_matches = append(ret_matches, matches...)
 	}
 
 	return ret_matches, nil
 }
 
+// Checks if the value of a request target (like the path or the query string) matches any of the rule specifications
+// The empty values (like the extension of a path without one) are not searched
+// @param value - the value of the target
+// @param ruleTarget - the compiled rule search specifications of the target
+// Returns the list of matches or an error if something occured
+func (rl *RuleRunner) checkTarget(value string, ruleTarget []*compiledMatcher) ([]searchMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	if value == "" {
+		return allMatches, nil
+	}
+	for _, matcher := range ruleTarget {
+		allMatches = append(allMatches, rl.search(value, matcher)...)
+	}
+	return allMatches, nil
+}
+
+// Gets the values of the URL components and the request line targets of the request by the name of the target
+// @param r - the http request
+// Returns the values of the targets
+func getRequestTargetValues(r *http.Request) map[string]string {
+	//The request URI is empty if the request was not received by a server
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+
+	//The filename is the last segment of the path, the paths which end with / do not have a filename
+	filename := ""
+	if r.URL.Path != "" && !strings.HasSuffix(r.URL.Path, "/") {
+		filename = path.Base(r.URL.Path)
+	}
+
+	return map[string]string{
+		"path":         r.URL.Path,
+		"raw_uri":      requestURI,
+		"query":        r.URL.RawQuery,
+		"filename":     filename,
+		"extension":    strings.TrimPrefix(path.Ext(filename), "."),
+		"request_line": r.Method + " " + requestURI + " " + r.Proto,
+		"protocol":     r.Proto,
+	}
+}
+
+// Checks if any of the cookies matches a rule specification for that cookie name
+// @param cookies - the cookies of the request
+// @param ruleCookies - the compiled rule search specifications (the name can be any)
+// Returns the list of matches or an error if something occured
+func (rl *RuleRunner) checkCookies(cookies []*http.Cookie, ruleCookies []*compiledMatcher) ([]searchMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	for _, cookie := range cookies {
+		for _, cookieSpec := range ruleCookies {
+			//The cookie names are case sensitive
+			if cookieSpec.name == "any" || cookieSpec.name == cookie.Name {
+				allMatches = append(allMatches, withTarget(rl.search(cookie.Value, cookieSpec), evidenceCookiePrefix+cookie.Name)...)
+			}
+		}
+	}
+	return allMatches, nil
+}
+
 // Check if any of the header value matches a rule specification for that header name
 // @param headers - the headers of the request as given by http.request package
-// @param ruleURL - the rule search specification
+// @param ruleHeaders - the compiled rule search specifications (the header names are canonical)
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*HeadersRule) ([]string, error) {
+func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*compiledMatcher) ([]searchMatch, error) {
 	//Check if the rule has the request headers specified
 	if ruleHeaders == nil {
 		//Return an empty list of matches
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Create the structure which will hold the findings list
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 
 	//Loop through each header
 	for headerName, headerValue := range headers {
 		//Check if the header name can be found in the list of header specifications of the rule
 		for _, headerSpec := range ruleHeaders {
-			if headerSpec.Name == headerName {
+			if headerSpec.name == headerName {
 				//Run the rule search for every value of this header
 				for _, headerVal := range headerValue {
 					//Call the search functions to get all the matches of the header with the rule header specifications
-					matches := rl.search(headerVal, &RuleSearchMode{Match: headerSpec.Match, Regex: headerSpec.Regex, Encodings: headerSpec.Encodings})
+					matches := withTarget(rl.search(headerVal, headerSpec), evidenceHeaderPrefix+headerName)
 					//Add the matches to the list of all matches
 					allMatches = append(allMatches, matches...)
 				}
@@ -215,36 +325,40 @@ func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*H
 }
 
 // Checks if any of the parameters matches a rule specification
-// @param url - the URL to be searched uppon
-// @param ruleURL - the rule search specification
+// @param parameters - the parameters to be searched uppon
+// @param ruleParameters - the compiled rule search specifications
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParameters []*RequestParametersRule) ([]string, error) {
+func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParameters []*compiledMatcher) ([]searchMatch, error) {
 	//Check if the parameters field is specified in the rule
 	if ruleParameters == nil {
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Create the structure which will hold all the matches
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 
 	//Loop through all the parameter names
 	for parameterName, parameterValues := range parameters {
 		//Check if the parameter name can be found in the list of parameters specified in the rule
 		for _, ruleParameter := range ruleParameters {
+			//The matchers with a selector are checked only on the body values
+			if ruleParameter.selector != nil {
+				continue
+			}
 			//If the rule parameter name is any then search through all the parameter names for a match
-			if ruleParameter.Name == "any" {
+			if ruleParameter.name == "any" {
 				//Check all the values for a matching string
 				for _, parameterValue := range parameterValues {
-					matches := rl.search(parameterValue, &RuleSearchMode{Match: ruleParameter.Match, Regex: ruleParameter.Regex, Encodings: ruleParameter.Encodings})
+					matches := withParameter(rl.search(parameterValue, ruleParameter), parameterName)
 					//Add the found matches to the list of all matches
 					allMatches = append(allMatches, matches...)
 				}
 			} else {
 				//Check if the parameter name matches the param name from the rule
-				if ruleParameter.Name == parameterName {
+				if ruleParameter.name == parameterName {
 					//Check all the values for a matching string
 					for _, parameterValue := range parameterValues {
-						matches := rl.search(parameterValue, &RuleSearchMode{Match: ruleParameter.Match, Regex: ruleParameter.Regex, Encodings: ruleParameter.Encodings})
+						matches := withParameter(rl.search(parameterValue, ruleParameter), parameterName)
 						//Add the found matches to the list of all matches
 						allMatches = append(allMatches, matches...)
 					}
@@ -256,50 +370,92 @@ func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParame
 	return allMatches, nil
 }
 
+// Sets the name of the parameter the matches were found on
+func withParameter(matches []searchMatch, parameter string) []searchMatch {
+	for i := range matches {
+		matches[i].parameter = parameter
+		matches[i].target = evidenceParamPrefix + parameter
+	}
+	return matches
+}
+
+// Checks if any of the values selected from the structured body matches a rule specification with a selector
+// @param fields - the values extracted from the body
+// @param ruleParameters - the compiled rule search specifications
+// Returns the list of matches or an error if something occured
+func (rl *RuleRunner) checkBodyFields(fields []bodyField, ruleParameters []*compiledMatcher) ([]searchMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	for _, ruleParameter := range ruleParameters {
+		if ruleParameter.selector == nil {
+			continue
+		}
+		for _, field := range fields {
+			if ruleParameter.selector.matches(field) {
+				allMatches = append(allMatches, withTarget(withParameter(rl.search(field.value, ruleParameter), field.name), bodyFieldTarget(field))...)
+			}
+		}
+	}
+	return allMatches, nil
+}
+
+// Parses the structured body of the request (JSON, XML or multipart) using the limits from the configuration
+// If the body cannot be fully parsed the values extracted before the problem are kept
+// @param contentType - the Content-Type header of the request
+// @param body - the body of the request
+// Returns the values extracted from the body
+func (rl *RuleRunner) parseRequestBody(contentType string, body []byte) *parsedBody {
+	//Get the limits from the configuration
+	maxSize := DefaultMaxBodyParseSize
+	maxDepth := DefaultMaxBodyParseDepth
+	if rl.configuration.RuleConfig != nil {
+		if rl.configuration.RuleConfig.MaxBodyParseSize > 0 {
+			maxSize = rl.configuration.RuleConfig.MaxBodyParseSize
+		}
+		if rl.configuration.RuleConfig.MaxBodyParseDepth > 0 {
+			maxDepth = rl.configuration.RuleConfig.MaxBodyParseDepth
+		}
+	}
+
+	parsed, err := parseRequestBody(contentType, body, maxSize, maxDepth)
+	if err != nil {
+		rl.logger.Debug("Could not fully parse the request body into parameters,", err.Error())
+	}
+	return parsed
+}
+
 // Checks if the body of the request/response matches any rule specification for the body
 // It can also check if the hash of the body (MD5 or SHA256) matches a specified hash
 // @param body - the body of the request
-// @param bodyRule - the list of rule specifications for the body
+// @param bodyRule - the list of compiled rule specifications for the body
 // Returns the list of matches, the list of hash matches or an error if something occured
-func (rl *RuleRunner) checkBody(body string, bodyRule []*BodyRule) ([]string, []BodyHashMatch, error) {
+func (rl *RuleRunner) checkBody(body string, bodyRule []*compiledBodyMatcher) ([]searchMatch, []BodyHashMatch, error) {
 	//Check if the bodyRules is not nil
 	if bodyRule == nil {
-		return make([]string, 0), make([]BodyHashMatch, 0), nil
+		return make([]searchMatch, 0), make([]BodyHashMatch, 0), nil
 	}
 
 	//Initialize the all matches structure
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 	//Initialize the slice which will hold all the hash matches
 	allHashMatches := make([]BodyHashMatch, 0)
 
 	//Loop through every body rule
 	for _, bRule := range bodyRule {
 		//Get the matches for the exact string search and regex
-		matches := rl.search(body, &RuleSearchMode{Match: bRule.Match, Regex: bRule.Regex, Encodings: bRule.Encodings})
+		matches := withTarget(rl.search(body, bRule.compiledMatcher), evidenceTargetBody)
 		allMatches = append(allMatches, matches...)
 
-		//Check if the any of the hash types matches
-		if bRule.MD5Sum != "" {
-			//Compute the MD5 hash
-			md5hasher := md5.New()
-			bodyMd5 := md5hasher.Sum([]byte(body))
-			//Decode the hex string of the hash from the rule
-			decRuleMd5sum, err := hex.DecodeString(bRule.MD5Sum)
-			//Check if an error occured
-			if err != nil {
-				rl.logger.Error("Could not decode md5sum from the rule", err.Error())
-			} else {
-				if reflect.DeepEqual(bodyMd5, decRuleMd5sum) {
-					allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.MD5Sum, BodyHashAlgorithm: "MD5"})
-				}
+		//Check if the any of the hash types matches, the hashes of the rule are normalized to lowercase hex when the rules are compiled
+		if bRule.md5Sum != "" {
+			bodyMd5 := md5.Sum([]byte(body))
+			if hex.EncodeToString(bodyMd5[:]) == bRule.md5Sum {
+				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.md5Sum, BodyHashAlgorithm: "MD5", MatcherId: bRule.id})
 			}
 		}
-		if bRule.SHA256Sum != "" {
-			//Compute the SHA256 sum
+		if bRule.sha256Sum != "" {
 			bodySha256 := sha256.Sum256([]byte(body))
-			hexBodySha256 := hex.EncodeToString(bodySha256[:])
-			if hexBodySha256 == bRule.SHA256Sum {
-				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.SHA256Sum, BodyHashAlgorithm: "SHA256"})
+			if hex.EncodeToString(bodySha256[:]) == bRule.sha256Sum {
+				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.sha256Sum, BodyHashAlgorithm: "SHA256", MatcherId: bRule.id})
 			}
 		}
 	}
@@ -307,6 +463,93 @@ func (rl *RuleRunner) checkBody(body string, bodyRule []*BodyRule) ([]string, []
 	return allMatches, allHashMatches, nil
 }
 
+// Evaluates the condition of the rule on the matches found by the matchers of the rule
+// @param condition - the condition of the rule
+// @param matches - the matches found by the matchers
+// @param hashMatches - the body hash matches
+// Returns if the condition is met and the ids of the matchers which made it true
+func evaluateCondition(condition *RuleCondition, matches []searchMatch, hashMatches []BodyHashMatch) (bool, map[string]bool) {
+	matched := make(map[string]bool)
+	for _, match := range matches {
+		matched[match.matcherId] = true
+	}
+	for _, hashMatch := range hashMatches {
+		matched[hashMatch.MatcherId] = true
+	}
+	return condition.Evaluate(matched)
+}
+
+// Keeps only the matches found by the specified matchers
+func filterMatches(matches []searchMatch, matcherIds map[string]bool) []searchMatch {
+	filtered := make([]searchMatch, 0, len(matches))
+	for _, match := range matches {
+		if matcherIds[match.matcherId] {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
+// Keeps only the body hash matches found by the specified matchers
+func filterHashMatches(hashMatches []BodyHashMatch, matcherIds map[string]bool) []BodyHashMatch {
+	filtered := make([]BodyHashMatch, 0, len(hashMatches))
+	for _, hashMatch := range hashMatches {
+		if matcherIds[hashMatch.MatcherId] {
+			filtered = append(filtered, hashMatch)
+		}
+	}
+	return filtered
+}
+
+// Finds the byte offset, the line number and the line offset of the findings in the raw request or response
+// The evidence of the finding is searched where its target is in the raw data, so the repeated or the decoded strings are located correctly
+// The body hash findings are not located since they do not have a matched string
+// @param rawData - the raw request or response
+// @param findings - the findings to locate
+func (rl *RuleRunner) locateFindings(rawData string, findings []*models.FindingData) {
+	for _, finding := range findings {
+		//Check if this finding is not a hash match
+		if finding.Line != -1 && finding.LineIndex != -1 {
+			var context *evidenceContext
+			if findingContext, ok := rl.evidenceContexts[finding]; ok {
+				context = &findingContext
+				delete(rl.evidenceContexts, finding)
+			}
+			offset, evidence := locateEvidence(rawData, finding.Target, finding.Evidence, context)
+			if offset == -1 {
+				//Fallback to the first occurrence of the matched string
+				offset, evidence = locateEvidence(rawData, "", finding.MatchedString, nil)
+			}
+			//Check if the match was found
+			if offset == -1 {
+				//Skip the match
+				rl.logger.Error("Skipping match,", finding.MatchedString, "it was not found in the raw data")
+				finding.Line = -1
+				finding.LineIndex = -1
+				continue
+			}
+			finding.Offset = int64(offset)
+			finding.Evidence = evidence
+			finding.Line, finding.LineIndex = offsetToLine(rawData, offset)
+		}
+	}
+}
+
+// Sends an alert to the API via WebSocket if the severity is at least high
+// @param rule - the rule which matched
+// @param classification - the classification of the findings
+// @param severity - the severity of the findings
+func (rl *RuleRunner) sendDetectionAlert(rule *Rule, classification string, severity int64) {
+	if severity < models.HIGH || rl.apiWsConn == nil {
+		return
+	}
+	err := rl.apiWsConn.SendRuleDetectionAlert(websocket.RuleDetectionAlert{AgentId: rl.configuration.UUID, RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: classification, Severity: ConvertSeverityIntegerToString(severity), Timestamp: time.Now().Unix()})
+	//Check if an error occured when sending the alert
+	if err != nil {
+		rl.logger.Error("Error occured when sending alert to API when a high or critical payload was detected")
+	}
+}
+
 // Run all the rules on the request
 // @param r - the http request to operate on
 // Returns a list of findings or an error if something occured
@@ -315,54 +558,153 @@ func (rl *RuleRunner) RunRulesOnRequest(r *http.Request) ([]*models.FindingData,
 	findings := make([]*models.FindingData, 0)
 
 	//Check if the rules are nil
-	if rl.rules == nil {
+	if rl.ruleSet == nil {
 		return findings, nil
 	}
+	//The rules are evaluated within the time budget from the configuration
+	rl.startTimeBudget()
+
+	//Read the body once, it is searched by all the rules
+	bodyRead := true
+	bodyData, err := io.ReadAll(r.Body)
+	if err != nil {
+		rl.logger.Error("Error occured when reading the body contents from the request", err.Error())
+		bodyRead = false
+	}
+	//Reassign the body so other function can read the data
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+
+	//Parse the form to get the POST parameters
+	formParsed := true
+	err = r.ParseForm()
+	if err != nil {
+		rl.logger.Error("Error occured when parsing the request form when running rules on request", err.Error())
+		formParsed = false
+	}
+	//Reasign the body after parsing the form
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+
+	//Parse the JSON, XML and multipart bodies into parameters
+	structuredBody := rl.parseRequestBody(r.Header.Get("Content-Type"), bodyData)
+
+	//Keep the values of the request for the scope of the rules and the exclusions of the allow rules
+	rl.request = newScopeRequest(r)
+
+	//Get the URL components, the request line and the cookies once, they are searched by all the rules
+	targetValues := getRequestTargetValues(r)
+	cookies := r.Cookies()
+
+	//Holds the request findings of the transaction rules, they are reported only if the response matches too
+	transactionFindings := make([]*models.FindingData, 0)
 
 	//Loop through all the rules and check if any one of them matches a string in the request
 	//TO DO... Run each rule on a different go routine
-	for _, rule := range rl.rules {
+	for _, compiled := range rl.ruleSet.compiledRules {
+		rule := compiled.rule
+		//Record the time of the previous rule and stop when the time budget is exceeded
+		rl.finishRuleEvaluation(len(findings))
+		if !rl.withinTimeBudget() {
+			break
+		}
 		//Check if the rule has request matchers specified
-		if rule.Request == nil {
+		if rule.Request == nil && !isExpressionOnlyRule(rule) {
+			continue
+		}
+		//Skip the rules which are out of scope before running any matcher
+		if !compiled.scope.matches(rl.request) {
 			continue
 		}
-		allMatches := make([]string, 0)
+		//Skip the rules disabled because they are slow and time the evaluation of the rule
+		if !rl.startRuleEvaluation(compiled, len(findings)) {
+			continue
+		}
+		//Get the values the expressions are evaluated on the first time a rule needs them
+		if compiled.expression != nil && rl.expression == nil {
+			rl.expression = &ExpressionEnvironment{Request: newExpressionRequest(r, len(bodyData), r.URL.Query(), r.PostForm, structuredBody.parameters), RemoteIP: remoteIP(r)}
+		}
+		//The rules with only an expression match when the expression is true
+		if isExpressionOnlyRule(rule) {
+			if !rl.evaluateExpression(compiled, ExpressionPhaseRequest, findings) {
+				continue
+			}
+		}
+		allMatches := make([]searchMatch, 0)
+		if isExpressionOnlyRule(rule) {
+			allMatches = append(allMatches, searchMatch{matchedString: rule.Expr, unlocated: true, target: evidenceTargetExpression})
+		}
 		//Check the Method of the request
-		matches, _ := rl.checkMethod(r.Method, rule.Request.Method)
+		matches, _ := rl.checkMethod(r.Method, compiled.method)
 		allMatches = append(allMatches, matches...)
 
 		//Check the URL of the request
-		matches, _ = rl.checkURL(r.URL.RawPath, rule.Request.URL)
+		matches, _ = rl.checkURL(r.URL.EscapedPath(), compiled.url)
 		allMatches = append(allMatches, matches...)
+		//Check the URL components and the request line
+		for _, target := range rule.Request.Targets() {
+			matches, _ = rl.checkTarget(targetValues[target.Name], compiled.targets[target.Name])
+			allMatches = append(allMatches, withTarget(matches, target.Name)...)
+		}
 		//Check the Headers of the request
-		matches, _ = rl.checkHeaders(r.Header, rule.Request.Headers)
+		matches, _ = rl.checkHeaders(r.Header, compiled.requestHeaders)
+		allMatches = append(allMatches, matches...)
+		//Check the cookies of the request
+		matches, _ = rl.checkCookies(cookies, compiled.cookies)
 		allMatches = append(allMatches, matches...)
 		//Check the parameters of the request
 		//Check the GET parameters
-		matches, _ = rl.checkParameters(r.URL.Query(), rule.Request.Parameters)
+		matches, _ = rl.checkParameters(r.URL.Query(), compiled.parameters)
 		allMatches = append(allMatches, matches...)
 
 		//Check the POST parameters
-		bodyData, err := io.ReadAll(r.Body)
-		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request in order to parse the form", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-		}
-		//Parse the form
-		err = r.ParseForm()
-		//Check if an error occured
-		if err != nil {
-			rl.logger.Error("Error occured when parsing the request form when running rules on request", err.Error())
-		} else {
-			//The request has body parameters so search for matches in these.
-			matches, _ := rl.checkParameters(r.PostForm, rule.Request.Parameters)
+		if formParsed {
+			matches, _ = rl.checkParameters(r.PostForm, compiled.parameters)
 			allMatches = append(allMatches, matches...)
 		}
+		//Check the parameters extracted from the structured body
+		matches, _ = rl.checkParameters(structuredBody.parameters, compiled.parameters)
+		allMatches = append(allMatches, matches...)
+		matches, _ = rl.checkBodyFields(structuredBody.fields, compiled.parameters)
+		allMatches = append(allMatches, matches...)
+
+		//Check the body of the request
+		bodyMatches := make([]searchMatch, 0)
+		hashMatches := make([]BodyHashMatch, 0)
+		if bodyRead {
+			bodyMatches, hashMatches, _ = rl.checkBody(string(bodyData), compiled.requestBody)
+		}
+
+		//Remove the matches suppressed by the allow rules which matched before
+		allMatches, bodyMatches, hashMatches = rl.applyExclusions(compiled, "ingress", allMatches, bodyMatches, hashMatches)
+
+		//The transaction rules fire only if the response of the transaction matches as well
+		if rule.Transaction {
+			transactionFindings = append(transactionFindings, rl.saveTransactionRequestMatches(rule, append(allMatches, bodyMatches...), hashMatches)...)
+			continue
+		}
+
+		//Check if the condition of the rule is met and keep only the matches which made it true
+		if compiled.condition != nil {
+			//The condition on the response matchers is not evaluated on the request, where its matchers are not run
+			if compiled.responsePhase {
+				continue
+			}
+			conditionMet, matcherIds := evaluateCondition(compiled.condition, append(allMatches, bodyMatches...), hashMatches)
+			if !conditionMet {
+				continue
+			}
+			allMatches = filterMatches(allMatches, matcherIds)
+			bodyMatches = filterMatches(bodyMatches, matcherIds)
+			hashMatches = filterHashMatches(hashMatches, matcherIds)
+			//The condition is true but no matcher made it true, so the rule itself is the finding
+			if len(allMatches)+len(bodyMatches)+len(hashMatches) == 0 {
+				allMatches = append(allMatches, newConditionMatch(compiled.condition))
+			}
+		}
 
-		//Reasign the body after parsing the form
-		r.Body = io.NopCloser(bytes.NewReader(bodyData))
+		//The expression should be true as well for the matches to be reported
+		if compiled.expression != nil && !isExpressionOnlyRule(rule) && len(allMatches)+len(bodyMatches)+len(hashMatches) > 0 && !rl.evaluateExpression(compiled, ExpressionPhaseRequest, findings) {
+			continue
+		}
 
 		//Append matches to the list of findings
 		for _, match := range allMatches {
@@ -378,40 +720,29 @@ func (rl *RuleRunner) RunRulesOnRequest(r *http.Request) ([]*models.FindingData,
 				continue
 			}
 
-			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
+			findings = append(findings, rl.newMatchFinding(rule, match))
 		}
-		//Check the body of the request
-		bodyData, err = io.ReadAll(r.Body)
-		//Check if an error occured when getting the body data
-		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-			matches, hashMatches, _ := rl.checkBody(string(bodyData), rule.Request.Body)
-			//Add the matches to the list of findings
-			for _, match := range matches {
-				findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
-			}
-			//Add the hash matches to the list of matches
-			for _, hashMatch := range hashMatches {
-				findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash))})
-			}
+		//Add the body matches to the list of findings
+		for _, match := range bodyMatches {
+			findings = append(findings, rl.newMatchFinding(rule, match))
+		}
+		//Add the hash matches to the list of matches
+		for _, hashMatch := range hashMatches {
+			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash)), Target: evidenceTargetBody, Offset: -1})
 		}
 
-		//Check if the rule has at least high severity
-		if ConvertSeverityStringToInteger(rule.Info.Severity) >= models.HIGH {
-			//Send an alert to the API via WebSocket
-			if rl.apiWsConn != nil {
-				err := rl.apiWsConn.SendRuleDetectionAlert(websocket.RuleDetectionAlert{AgentId: rl.configuration.UUID, RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: rule.Info.Severity, Timestamp: time.Now().Unix()})
-				//Check if an error occured when sending the alert
-				if err != nil {
-					rl.logger.Error("Error occured when sending alert to API when a high or critical payload was detected")
-				}
+		//Send an alert if the rule matched and has at least high severity
+		if len(allMatches)+len(bodyMatches)+len(hashMatches) > 0 {
+			rl.sendDetectionAlert(rule, rule.Info.Classification, ConvertSeverityStringToInteger(rule.Info.Severity))
+			//Apply the exclusions of the allow rule, or stop if it allows the whole transaction
+			if isAllowRule(rule) && rl.activateAllowRule(rule, compiled.exclusions) {
+				break
 			}
 		}
 	}
 
+	rl.finishRuleEvaluation(len(findings))
+
 	//Dump the request
 	rawRequest, err := utils.DumpHTTPRequest(r)
 	//Check if an error occured when dumping the request
@@ -421,74 +752,160 @@ func (rl *RuleRunner) RunRulesOnRequest(r *http.Request) ([]*models.FindingData,
 	}
 
 	//Look for every match in the raw request to find the line number, line offset of the match
-	for _, finding := range findings {
-		//Check if this finding is not a hash match
-		if finding.Line != -1 && finding.LineIndex != -1 {
-			lineNumber, lineOffset, err := utils.FindFindingDataInRawdata(string(rawRequest), finding.MatchedString)
-			//Check if an error occures
-			if err != nil {
-				//Skip the match
-				rl.logger.Error("Skipping match,", finding.MatchedString, "error occured when searching for the match in the raw request", err.Error())
-				continue
-			}
-			finding.Line = lineNumber
-			finding.LineIndex = lineOffset
-			//rl.logger.Debug(*finding)
-		}
-	}
+	rl.locateFindings(string(rawRequest), findings)
+	//The request findings of the transaction rules are located now, they are reported with the response
+	rl.locateFindings(string(rawRequest), transactionFindings)
+
+	//Add the metadata of the rules to the findings
+	rl.addRuleMetadata(findings)
+
+	//Keep the findings of the request for the expressions evaluated on the response
+	rl.requestFindings = findings
 
 	return findings, nil
 }
 
 // Run all the rules on the response
 // @param r - the http response to operate on
+// @param timings - the timings of the upstream response (nil if they were not measured, the latency matchers do not match)
 // Returns a list of findings or an error if something occured
-func (rl *RuleRunner) RunRulesOnResponse(r *http.Response) ([]*models.FindingData, error) {
+func (rl *RuleRunner) RunRulesOnResponse(r *http.Response, timings *UpstreamTimings) ([]*models.FindingData, error) {
 	//Create the list which will hold all the matches from all the rules for the request
 	findings := make([]*models.FindingData, 0)
 
 	//Check if the rules are nil
-	if rl.rules == nil {
+	if rl.ruleSet == nil {
+		return findings, nil
+	}
+	//The rules are evaluated within the time budget from the configuration
+	rl.startTimeBudget()
+
+	//The allow rule which matched on the request allows the response too
+	if rl.allowedBy != "" {
 		return findings, nil
 	}
+	//Keep the values of the request if the request was not checked by this runner
+	if rl.request == nil && r.Request != nil && r.Request.URL != nil {
+		rl.request = newScopeRequest(r.Request)
+	}
+
+	//Read the body once, it is searched by all the rules
+	bodyRead := true
+	bodyData, err := io.ReadAll(r.Body)
+	if err != nil {
+		rl.logger.Error("Error occured when reading the body contents from the response", err.Error())
+		bodyRead = false
+	}
+	//Reassign the body so other function can read the data
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+
+	//Add the values of the response the expressions of the rules are evaluated on
+	if rl.ruleSet.hasExpressions {
+		rl.addResponseExpressionValues(r, len(bodyData))
+	}
+
+	//Holds the request findings of the transaction rules which fired
+	transactionRequestFindings := make([]*models.FindingData, 0)
 
 	//Loop through all the rules and check if any one of them matches a string in the request
-	for _, rule := range rl.rules {
+	for _, compiled := range rl.ruleSet.compiledRules {
+		rule := compiled.rule
+		//Record the time of the previous rule and stop when the time budget is exceeded
+		rl.finishRuleEvaluation(len(findings))
+		if !rl.withinTimeBudget() {
+			break
+		}
 		//Check if the rule has request matchers specified
 		if rule.Response == nil {
 			continue
 		}
+		//Skip the rules which are out of scope before running any matcher
+		if !compiled.scope.matches(rl.request) {
+			continue
+		}
+		//Skip the rules disabled because they are slow and time the evaluation of the rule
+		if !rl.startRuleEvaluation(compiled, len(findings)) {
+			continue
+		}
 
-		allMatches := make([]string, 0)
+		allMatches := make([]searchMatch, 0)
+		//Check the status code of the response
+		matches, _ := rl.checkCode(strconv.Itoa(r.StatusCode), compiled.code)
+		allMatches = append(allMatches, matches...)
+		//Check the timings of the upstream response
+		if timings != nil {
+			allMatches = append(allMatches, withTarget(rl.checkDuration(timings.ResponseTime, compiled.latency), evidenceTargetLatency)...)
+			allMatches = append(allMatches, withTarget(rl.checkDuration(timings.TimeToFirstByte, compiled.ttfb), evidenceTargetTTFB)...)
+		}
 		//Check the Headers of the request
-		matches, _ := rl.checkHeaders(r.Header, rule.Response.Headers)
+		matches, _ = rl.checkHeaders(r.Header, compiled.responseHeaders)
 		allMatches = append(allMatches, matches...)
 
-		//Append matches to the list of findings
-		for _, match := range allMatches {
-			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
+		//Check the body of the response
+		hashMatches := make([]BodyHashMatch, 0)
+		if bodyRead {
+			matches, hashMatches, _ = rl.checkBody(string(bodyData), compiled.responseBody)
+			allMatches = append(allMatches, matches...)
 		}
 
-		//Check the body of the request
-		bodyData, err := io.ReadAll(r.Body)
-		//Check if an error occured when getting the body data
-		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-			matches, hashMatches, _ := rl.checkBody(string(bodyData), rule.Response.Body)
-			//Add the matches to the list of findings
-			for _, match := range matches {
-				findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
+		//Remove the matches suppressed by the allow rules which matched before
+		allMatches, _, hashMatches = rl.applyExclusions(compiled, "egress", allMatches, nil, hashMatches)
+
+		//Correlate the transaction rules with the request matches of the same transaction
+		if rule.Transaction {
+			fired, requestFindings, responseFindings := rl.correlateTransaction(compiled, allMatches, hashMatches)
+			//The expression should be true as well for the transaction rule to fire
+			if fired && compiled.expression != nil {
+				fired = rl.evaluateExpression(compiled, ExpressionPhaseResponse, findings)
+			}
+			if fired {
+				findings = append(findings, responseFindings...)
+				transactionRequestFindings = append(transactionRequestFindings, requestFindings...)
+				rl.sendDetectionAlert(rule, SuccessfulExploitationClassification, elevateSeverity(ConvertSeverityStringToInteger(rule.Info.Severity)))
+			}
+			continue
+		}
+
+		//Check if the condition of the rule is met and keep only the matches which made it true
+		if compiled.condition != nil {
+			//The condition on the request matchers is not evaluated on the response, where its matchers are not run
+			if !compiled.responsePhase {
+				continue
 			}
-			//Add the hash matches to the list of matches
-			for _, hashMatch := range hashMatches {
-				findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash))})
+			conditionMet, matcherIds := evaluateCondition(compiled.condition, allMatches, hashMatches)
+			if !conditionMet {
+				continue
+			}
+			allMatches = filterMatches(allMatches, matcherIds)
+			hashMatches = filterHashMatches(hashMatches, matcherIds)
+			//The condition is true but no matcher made it true, so the rule itself is the finding
+			if len(allMatches)+len(hashMatches) == 0 {
+				allMatches = append(allMatches, newConditionMatch(compiled.condition))
 			}
 		}
+
+		//The expression should be true as well for the matches to be reported
+		if compiled.expression != nil && len(allMatches)+len(hashMatches) > 0 && !rl.evaluateExpression(compiled, ExpressionPhaseResponse, findings) {
+			continue
+		}
+
+		//Append matches to the list of findings
+		for _, match := range allMatches {
+			findings = append(findings, rl.newMatchFinding(rule, match))
+		}
+		//Add the hash matches to the list of matches
+		for _, hashMatch := range hashMatches {
+			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash)), Target: evidenceTargetBody, Offset: -1})
+		}
+
+		//Apply the exclusions of the allow rule, or stop if it allows the whole response
+		if len(allMatches)+len(hashMatches) > 0 && isAllowRule(rule) && rl.activateAllowRule(rule, compiled.exclusions) {
+			break
+		}
 	}
 
+	rl.finishRuleEvaluation(len(findings))
+
 	//Dump the request
 	rawResponse, err := utils.DumpHTTPResponse(r)
 	//Check if an error occured when dumping the request
@@ -497,65 +914,30 @@ func (rl *RuleRunner) RunRulesOnResponse(r *http.Response) ([]*models.FindingDat
 		return nil, err
 	}
 
-	//Look for every match in the raw request to find the line number, line offset of the match
-	for _, finding := range findings {
-		//Check if this finding is not a hash match
-		if finding.Line != -1 && finding.LineIndex != -1 {
-			lineNumber, lineOffset, err := utils.FindFindingDataInRawdata(string(rawResponse), finding.MatchedString)
-			//Check if an error occures
-			if err != nil {
-				//Skip the match
-				rl.logger.Error("Skipping match,", finding.MatchedString, "error occured when searching for the match in the raw request", err.Error())
-				continue
-			}
-			finding.Line = lineNumber
-			finding.LineIndex = lineOffset
-			//rl.logger.Debug(*finding)
-		}
-	}
+	//Look for every match in the raw response to find the line number, line offset of the match
+	rl.locateFindings(string(rawResponse), findings)
+
+	//Add the request findings of the transaction rules which fired, they were located in the raw request
+	findings = append(findings, transactionRequestFindings...)
+
+	//Add the metadata of the rules to the findings
+	rl.addRuleMetadata(findings)
 
 	return findings, nil
 }
 
 // Searches for hex match on the value or if the regex can find any hex matches on the given value
 // @param value - the value to be searched uppon (as hex)
-// @param mode - the rule search specification
+// @param matcher - the compiled rule search specification
 // Returns the list of matches it found
-func (rl *RuleRunner) searchHex(value []byte, mode *RuleHexSearchMod) []string {
-	allMatches := make([]string, 0)
-
-	//Check if the exact match is specified
-	if mode.Match != "" {
-		//Check if the value as hex contains the match string
-		if strings.Contains(strings.ToLower(hex.EncodeToString(value)), strings.ToLower(mode.Match)) {
-			//Add the match to the list of matches
-			allMatches = append(allMatches, mode.Match)
-		}
-	}
-
-	//Check if the regex match is specified
-	if mode.Regex != "" {
-		//Compile the regex
-		r, err := regexp.Compile(mode.Regex)
-
-		//Check if an error occured during the regex compilation
-		if err != nil {
-			rl.logger.Error("Could not the compile regex matcher from the rule, invalid regex:", mode.Regex)
-			return nil
-		}
-		//Find all the matches for the regex
-		matches := r.FindAllString(strings.ToLower(hex.EncodeToString(value)), -1)
-		//rl.logger.Debug("Value:", value, "Regex:", mode.Regex, "Matches:", matches)
-		//Check if there were any matches
-		if len(matches) > 0 {
-			//rl.logger.Debug("Regex,", mode.Regex, "matched on", value)
-			//Add the matches to the list of matches
-			allMatches = append(allMatches, matches...)
-		}
+func (rl *RuleRunner) searchHex(value []byte, matcher *compiledMatcher) []searchMatch {
+	//Search on the lowercase hex representation of the value
+	matches := rl.search(strings.ToLower(hex.EncodeToString(value)), matcher)
+	//The evidence is kept as hex, but the offset is the offset of the bytes in the value
+	for i := range matches {
+		matches[i].offset /= 2
 	}
-
-	//Return all the matches
-	return allMatches
+	return matches
 }
 
 // Run the rules on the websocket message
@@ -564,31 +946,47 @@ func (rl *RuleRunner) RunRulesOnWebsocketMessage(messageType int, messageText []
 	findings := make([]*models.FindingData, 0)
 
 	//Check if the rules are nil
-	if rl.rules == nil {
+	if rl.ruleSet == nil {
 		return findings, nil
 	}
+	//The rules are evaluated within the time budget from the configuration
+	rl.startTimeBudget()
 
 	//Loop through all the rules and check if any one of them matches a string in the request
 	//TO DO... Run each rule on a different go routine
-	for _, rule := range rl.rules {
+	for _, compiled := range rl.ruleSet.compiledRules {
+		rule := compiled.rule
+		//Record the time of the previous rule and stop when the time budget is exceeded
+		rl.finishRuleEvaluation(len(findings))
+		if !rl.withinTimeBudget() {
+			break
+		}
 		//Create the list of all matches
-		allMatches := make([]string, 0)
+		allMatches := make([]searchMatch, 0)
 
 		//Check if the rule has request matchers specified
 		if rule.Websocket == nil {
 			continue
 		}
+		//The rules scoped to hosts, paths or methods do not apply to the websocket messages
+		if !compiled.scope.matches(rl.request) {
+			continue
+		}
+		//Skip the rules disabled because they are slow and time the evaluation of the rule
+		if !rl.startRuleEvaluation(compiled, len(findings)) {
+			continue
+		}
 
-		for _, ws_rule := range rule.Websocket {
+		for _, wsMatcher := range compiled.websocket {
 			//Check if the message is text
 			if messageType == 1 {
-				matches := rl.search(string(messageText), &RuleSearchMode{Match: ws_rule.Match, Regex: ws_rule.Regex, Encodings: nil})
+				matches := withTarget(rl.search(string(messageText), wsMatcher), evidenceTargetWebsocket)
 				allMatches = append(allMatches, matches...)
 			}
 
 			//Check if the message is binary and apply the hex search
 			if messageType == 2 {
-				matches := rl.searchHex(messageText, &RuleHexSearchMod{Match: ws_rule.Match, Regex: ws_rule.Regex})
+				matches := withTarget(rl.searchHex(messageText, wsMatcher), evidenceTargetWebsocket)
 				allMatches = append(allMatches, matches...)
 			}
 		}
@@ -608,10 +1006,15 @@ func (rl *RuleRunner) RunRulesOnWebsocketMessage(messageType int, messageText []
 				continue
 			}
 
-			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match)), Line: -1, LineIndex: -1})
+			findings = append(findings, newMessageFinding(rule, match))
 		}
 	}
 
+	rl.finishRuleEvaluation(len(findings))
+
+	//Add the metadata of the rules to the findings
+	rl.addRuleMetadata(findings)
+
 	return findings, nil
 }
 
@@ -622,35 +1025,54 @@ func (rl *RuleRunner) ApplyRulesOnTCPMessage(direction string, messageText []byt
 	findings := make([]*models.FindingData, 0)
 
 	//Check if the rules are nil
-	if rl.rules == nil {
+	if rl.ruleSet == nil {
 		return findings, nil
 	}
-
-	for _, rule := range rl.rules {
+	//The rules are evaluated within the time budget from the configuration
+	rl.startTimeBudget()
+
+	for _, compiled := range rl.ruleSet.compiledRules {
+		rule := compiled.rule
+		//Record the time of the previous rule and stop when the time budget is exceeded
+		rl.finishRuleEvaluation(len(findings))
+		if !rl.withinTimeBudget() {
+			break
+		}
 		//Create the list of all matches
-		allMatches := make([]string, 0)
+		allMatches := make([]searchMatch, 0)
 
 		//Check if the tcp field exists in the rule
 		if rule.TCP == nil {
 			continue
 		}
+		//The rules scoped to hosts, paths or methods do not apply to the tcp messages
+		if !compiled.scope.matches(rl.request) {
+			continue
+		}
+		//Skip the rules disabled because they are slow and time the evaluation of the rule
+		if !rl.startRuleEvaluation(compiled, len(findings)) {
+			continue
+		}
 
-		for _, tcpRule := range rule.TCP {
+		for _, tcpMatcher := range compiled.tcp {
 			//Check if the direction is ingress
-			if tcpRule.Direction == direction {
+			if tcpMatcher.direction == direction {
 				//if the match or regex field exists
-				if tcpRule.Match != "" || tcpRule.Regex != "" {
-					matches := rl.search(string(messageText), &RuleSearchMode{Match: tcpRule.Match, Regex: tcpRule.Regex, Encodings: nil})
+				if tcpMatcher.matcher != nil {
+					matches := withTarget(rl.search(string(messageText), tcpMatcher.matcher), evidenceTargetTCP)
 					allMatches = append(allMatches, matches...)
 				}
 				//if the hexmatch or hexregex field exists
-				if tcpRule.HexMatch != "" || tcpRule.HexRegex != "" {
-					matches := rl.search(string(messageText), &RuleSearchMode{Match: tcpRule.HexMatch, Regex: tcpRule.HexRegex, Encodings: nil})
+				if tcpMatcher.hexMatcher != nil {
+					matches := withTarget(rl.searchHex(messageText, tcpMatcher.hexMatcher), evidenceTargetTCP)
 					allMatches = append(allMatches, matches...)
 				}
 			}
 		}
 
+		//Remove the matches suppressed by the allow rules which matched before
+		allMatches, _, _ = rl.applyExclusions(compiled, direction, allMatches, nil, nil)
+
 		//Append matches to the list of findings
 		for _, match := range allMatches {
 			findingFound := false
@@ -666,9 +1088,19 @@ func (rl *RuleRunner) ApplyRulesOnTCPMessage(direction string, messageText []byt
 				continue
 			}
 
-			findings = append(findings, &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match)), Line: -1, LineIndex: -1})
+			findings = append(findings, newMessageFinding(rule, match))
+		}
+
+		//Apply the exclusions of the allow rule, or stop if it allows the whole message
+		if len(allMatches) > 0 && isAllowRule(rule) && rl.activateAllowRule(rule, compiled.exclusions) {
+			break
 		}
 	}
 
+	rl.finishRuleEvaluation(len(findings))
+
+	//Add the metadata of the rules to the findings
+	rl.addRuleMetadata(findings)
+
 	return findings, nil
 }
@@ -0,0 +1,137 @@
+package detection
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Holds the scope of the rule, every specified list should match for the rule to be evaluated (any value of a list is enough)
+// The services are checked when the rules of the services are selected, the hosts, paths and methods before running the matchers
+type RuleScope struct {
+	Services    []string `yaml:"services"`     //The names of the services the rule applies to
+	Hosts       []string `yaml:"hosts"`        //The hosts from the Host header without the port (case insensitive, *.example.com matches the subdomains)
+	Paths       []string `yaml:"paths"`        //The prefixes of the decoded path
+	PathRegexes []string `yaml:"path_regexes"` //The regexes of the decoded path (the path is in scope if it matches any of the prefixes or the regexes)
+	Methods     []string `yaml:"methods"`      //The HTTP methods (case insensitive)
+}
+
+// Holds the scope of the rule compiled when the rules are loaded
+type compiledScope struct {
+	hosts       []string         //The lowercase hosts
+	paths       []string         //The prefixes of the path
+	pathRegexes []*regexp.Regexp //The compiled regexes of the path
+	methods     []string         //The uppercase methods
+}
+
+// Holds the values of the HTTP request the scope of the rules is checked on
+type scopeRequest struct {
+	host   string //The lowercase host without the port
+	path   string //The decoded path
+	method string //The method
+}
+
+// Gets the values the scope is checked on from the request
+func newScopeRequest(r *http.Request) *scopeRequest {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return &scopeRequest{host: strings.ToLower(host), path: r.URL.Path, method: r.Method}
+}
+
+// Compiles the hosts, paths and methods of the scope
+// Returns nil if the scope does not limit the requests the rule is evaluated on
+func compileScope(scope *RuleScope) (*compiledScope, error) {
+	if scope == nil || len(scope.Hosts)+len(scope.Paths)+len(scope.PathRegexes)+len(scope.Methods) == 0 {
+		return nil, nil
+	}
+
+	compiled := &compiledScope{paths: scope.Paths, pathRegexes: make([]*regexp.Regexp, 0, len(scope.PathRegexes))}
+	for _, host := range scope.Hosts {
+		if host == "" {
+			return nil, errors.New("scope host cannot be empty")
+		}
+		compiled.hosts = append(compiled.hosts, strings.ToLower(host))
+	}
+	for _, pathRegex := range scope.PathRegexes {
+		regex, err := regexp.Compile(pathRegex)
+		if err != nil {
+			return nil, errors.New("cannot compile scope path regex, " + err.Error())
+		}
+		compiled.pathRegexes = append(compiled.pathRegexes, regex)
+	}
+	for _, method := range scope.Methods {
+		if method == "" {
+			return nil, errors.New("scope method cannot be empty")
+		}
+		compiled.methods = append(compiled.methods, strings.ToUpper(method))
+	}
+	return compiled, nil
+}
+
+// Checks if the scope of the rule is valid
+// @param scope - the scope from the rule file (can be nil)
+// Returns an error if a regex cannot be compiled or a value is empty
+func CheckRuleScope(scope *RuleScope) error {
+	if scope == nil {
+		return nil
+	}
+	for _, service := range scope.Services {
+		if service == "" {
+			return errors.New("scope service cannot be empty")
+		}
+	}
+	_, err := compileScope(scope)
+	return err
+}
+
+// Checks if the rule applies to the service based on the services of the scope
+// @param rule - the rule to check
+// @param serviceName - the name of the service
+// Returns true if the scope of the rule does not specify services or the service is one of them
+func IsRuleInServiceScope(rule Rule, serviceName string) bool {
+	if rule.Scope == nil || len(rule.Scope.Services) == 0 {
+		return true
+	}
+	return slices.Contains(rule.Scope.Services, serviceName)
+}
+
+// Checks if the host matches the scope host, *.example.com matches the subdomains of example.com
+func matchesScopeHost(scopeHost string, host string) bool {
+	if suffix, ok := strings.CutPrefix(scopeHost, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return scopeHost == host
+}
+
+// Checks if the request is in the scope
+// The rules with a scope are not evaluated on the data which is not part of an HTTP request (like the tcp messages)
+// @param request - the values of the request (nil if the data is not part of an HTTP request)
+// Returns true if the rule should be evaluated on the request
+func (cs *compiledScope) matches(request *scopeRequest) bool {
+	if cs == nil {
+		return true
+	}
+	if request == nil {
+		return false
+	}
+
+	if len(cs.hosts) > 0 && !slices.ContainsFunc(cs.hosts, func(host string) bool { return matchesScopeHost(host, request.host) }) {
+		return false
+	}
+	if len(cs.methods) > 0 && !slices.Contains(cs.methods, strings.ToUpper(request.method)) {
+		return false
+	}
+	if len(cs.paths)+len(cs.pathRegexes) > 0 {
+		inScope := slices.ContainsFunc(cs.paths, func(prefix string) bool { return strings.HasPrefix(request.path, prefix) })
+		inScope = inScope || slices.ContainsFunc(cs.pathRegexes, func(regex *regexp.Regexp) bool { return regex.MatchString(request.path) })
+		if !inScope {
+			return false
+		}
+	}
+	return true
+}
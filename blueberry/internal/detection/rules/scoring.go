@@ -0,0 +1,86 @@
+package detection
+
+import (
+	"blueberry/internal/config"
+	"blueberry/internal/models"
+)
+
+// The weight each finding adds to the anomaly score based on the severity of the rule (the same as the OWASP CRS weights)
+var AnomalyScoreWeights = map[int64]int64{
+	models.LOW:      2,
+	models.MEDIUM:   3,
+	models.HIGH:     4,
+	models.CRITICAL: 5,
+}
+
+// Computes the anomaly score of the findings
+// Every finding adds the weight of the severity of its rule, except for the rules with the allow action
+// @param rules - the list of rules loaded from disk
+// @param direction - the direction of the data the findings were on (ingress or egress)
+// @param findings - the list of rule findings
+// Returns the total score and the contribution of each rule
+func GetAnomalyScore(rules []Rule, direction string, findings []*models.FindingData) (int64, []*models.AnomalyScoreContribution) {
+	var score int64 = 0
+	contributions := make([]*models.AnomalyScoreContribution, 0)
+	//Keep the contribution of each rule in the order the rules were found
+	ruleContributions := make(map[string]*models.AnomalyScoreContribution)
+
+	for _, finding := range findings {
+		//The rules which explicitly allow the data do not add to the score
+		if GetRuleAction(rules, finding.RuleId) == "allow" {
+			continue
+		}
+
+		weight := AnomalyScoreWeights[finding.Severity]
+		score += weight
+
+		contribution, ok := ruleContributions[finding.RuleId]
+		if !ok {
+			contribution = &models.AnomalyScoreContribution{RuleId: finding.RuleId, Direction: direction, Severity: finding.Severity}
+			ruleContributions[finding.RuleId] = contribution
+			contributions = append(contributions, contribution)
+		}
+		contribution.Findings++
+		contribution.Score += weight
+	}
+
+	return score, contributions
+}
+
+// Get the verdict based on the verdict mode of the service
+// In first_match mode the verdict is taken by GetVerdictBasedOnFindings, in anomaly_scoring mode the data is dropped
+// when the anomaly score reaches the threshold of the direction. The anomaly score is computed in both modes
+// so the thresholds can be tuned before switching the mode
+// If the evaluation of the rules exceeded its budget the data is dropped when the budget exceeded action of the service is drop,
+// otherwise the verdict is taken on the findings of the rules which were evaluated (the skipped rules are ignored)
+// @param rules - the list of rules loaded from disk
+// @param defaultAction - the default action specified in the rules config
+// @param service - the service the data belongs to
+// @param direction - the direction of the data the findings were on (ingress or egress)
+// @param findings - the list of rule findings
+// @param budgetExceeded - if the evaluation of the rules was stopped because it exceeded its budget
+// Returns the verdict, the anomaly score and the contribution of each rule to the score
+func GetServiceVerdict(rules []Rule, defaultAction string, service *config.BackendServices, direction string, findings []*models.FindingData, budgetExceeded bool) (string, int64, []*models.AnomalyScoreContribution) {
+	score, contributions := GetAnomalyScore(rules, direction, findings)
+
+	//The rules which were skipped could have matched, so the data is dropped if the service fails closed
+	if budgetExceeded && service != nil && service.RuleConfig != nil && service.RuleConfig.BudgetExceededAction == "drop" {
+		return "drop", score, contributions
+	}
+
+	//Check if the service uses the anomaly scoring
+	if service == nil || service.VerdictMode != "anomaly_scoring" {
+		return GetVerdictBasedOnFindings(rules, defaultAction, findings), score, contributions
+	}
+
+	//Get the threshold based on the direction of the data
+	threshold := service.InboundAnomalyThreshold
+	if direction == "egress" {
+		threshold = service.OutboundAnomalyThreshold
+	}
+
+	if score >= threshold {
+		return "drop", score, contributions
+	}
+	return "allow", score, contributions
+}
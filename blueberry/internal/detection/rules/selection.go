@@ -0,0 +1,91 @@
+package detection
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"blueberry/internal/config"
+)
+
+// Selects the rules used by the service based on the scope of the rules and the rules options of the service
+// If the service does not specify rules options all the rules in the scope of the service are selected
+// @param rules - the list of rules loaded from disk
+// @param service - the service the rules are selected for
+// Returns the rules selected for the service in the order they were loaded
+func SelectServiceRules(rules []Rule, service *config.BackendServices) []Rule {
+	if service == nil {
+		return rules
+	}
+
+	selected := make([]Rule, 0)
+	for _, rule := range rules {
+		if IsRuleInServiceScope(rule, service.Name) && IsRuleSelected(rule, service.RuleConfig) {
+			selected = append(selected, rule)
+		}
+	}
+	return selected
+}
+
+// Checks if the rule is selected by the rules options of a service
+// @param rule - the rule to check
+// @param options - the rules options of the service
+// Returns true if the rule should be used by the service
+func IsRuleSelected(rule Rule, options *config.ServiceRuleOptions) bool {
+	if options == nil {
+		return true
+	}
+
+	//The excluded ids are always removed
+	if slices.Contains(options.ExcludeIds, rule.Id) {
+		return false
+	}
+	//The included ids are always kept
+	if slices.Contains(options.IncludeIds, rule.Id) {
+		return true
+	}
+
+	//If an include list is specified the rule should match at least one of them
+	hasIncludes := len(options.IncludeIds) > 0 || len(options.IncludeDirectories) > 0 || len(options.IncludeTags) > 0
+	if hasIncludes && !isRuleInDirectories(rule, options.IncludeDirectories) && !ruleHasAnyTag(rule, options.IncludeTags) {
+		return false
+	}
+
+	//Remove the rules from the excluded directories or with the excluded tags
+	if isRuleInDirectories(rule, options.ExcludeDirectories) || ruleHasAnyTag(rule, options.ExcludeTags) {
+		return false
+	}
+	return true
+}
+
+// Checks if the rule file is inside any of the directories (relative to the rules directory)
+func isRuleInDirectories(rule Rule, directories []string) bool {
+	ruleDirectory := path.Dir(rule.Path)
+	for _, directory := range directories {
+		//Normalize the directory so ./LFI/, LFI and LFI/ are the same
+		directory = path.Clean(strings.ReplaceAll(directory, "\\", "/"))
+		directory = strings.Trim(directory, "/")
+		if directory == "" || directory == "." {
+			return true
+		}
+		if ruleDirectory == directory || strings.HasPrefix(ruleDirectory, directory+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the rule has any of the tags (case insensitive)
+func ruleHasAnyTag(rule Rule, tags []string) bool {
+	if rule.Info == nil {
+		return false
+	}
+	for _, ruleTag := range rule.Info.Tags {
+		for _, tag := range tags {
+			if strings.EqualFold(ruleTag, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
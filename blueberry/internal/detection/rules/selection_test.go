@@ -0,0 +1,43 @@
+package detection
+
+import (
+	"testing"
+
+	"blueberry/internal/config"
+)
+
+func TestIsRuleSelected(t *testing.T) {
+	rule := Rule{Id: "lfi-001", Path: "LFI/linux/passwd.yaml", Info: &RuleInfo{Name: "passwd", Tags: []string{"LFI", "linux"}}}
+	tests := []struct {
+		name     string
+		options  *config.ServiceRuleOptions
+		expected bool
+	}{
+		{name: "no options", expected: true},
+		{name: "empty options", options: &config.ServiceRuleOptions{}, expected: true},
+		{name: "included id", options: &config.ServiceRuleOptions{IncludeIds: []string{"lfi-001"}}, expected: true},
+		{name: "other included id", options: &config.ServiceRuleOptions{IncludeIds: []string{"xss-001"}}},
+		{name: "excluded id", options: &config.ServiceRuleOptions{ExcludeIds: []string{"lfi-001"}}},
+		{name: "excluded id over included id", options: &config.ServiceRuleOptions{IncludeIds: []string{"lfi-001"}, ExcludeIds: []string{"lfi-001"}}},
+		{name: "excluded id over included directory", options: &config.ServiceRuleOptions{IncludeDirectories: []string{"LFI"}, ExcludeIds: []string{"lfi-001"}}},
+		{name: "included id over excluded directory", options: &config.ServiceRuleOptions{IncludeIds: []string{"lfi-001"}, ExcludeDirectories: []string{"LFI"}}, expected: true},
+		{name: "included id over excluded tag", options: &config.ServiceRuleOptions{IncludeIds: []string{"lfi-001"}, ExcludeTags: []string{"lfi"}}, expected: true},
+		{name: "normalized included directory", options: &config.ServiceRuleOptions{IncludeDirectories: []string{"./LFI/"}}, expected: true},
+		{name: "included parent directory", options: &config.ServiceRuleOptions{IncludeDirectories: []string{"LFI"}}, expected: true},
+		{name: "included directory with a common prefix", options: &config.ServiceRuleOptions{IncludeDirectories: []string{"LF"}}},
+		{name: "included tag", options: &config.ServiceRuleOptions{IncludeTags: []string{"lfi"}}, expected: true},
+		{name: "other included tag", options: &config.ServiceRuleOptions{IncludeTags: []string{"xss"}}},
+		{name: "excluded directory over included tag", options: &config.ServiceRuleOptions{IncludeTags: []string{"lfi"}, ExcludeDirectories: []string{"LFI/linux"}}},
+		{name: "excluded tag over included directory", options: &config.ServiceRuleOptions{IncludeDirectories: []string{"LFI"}, ExcludeTags: []string{"LINUX"}}},
+		{name: "excluded tag without includes", options: &config.ServiceRuleOptions{ExcludeTags: []string{"linux"}}},
+		{name: "other excluded directory", options: &config.ServiceRuleOptions{ExcludeDirectories: []string{"XSS"}}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := IsRuleSelected(rule, test.options); result != test.expected {
+				t.Errorf("IsRuleSelected() = %v, expected %v", result, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package detection
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+)
+
+// Holds the rule set with all the rules and the rule sets selected for every service
+type serviceRuleSets struct {
+	all      *RuleSet                             //The rule set with all the loaded rules
+	services map[*config.BackendServices]*RuleSet //The rule set of every service
+}
+
+// Holds the rule sets which are currently used by the handlers
+// The rule sets are swapped atomically when the rules are reloaded, so the requests which are already
+// being processed keep the rule set they started with
+type RuleStore struct {
+	logger        logging.ILogger                 //The logger interface
+	configuration config.Configuration            //The configuration structure
+	ruleSets      atomic.Pointer[serviceRuleSets] //The rule sets currently in use
+	reloadMutex   sync.Mutex                      //Makes sure only one reload runs at a time
+	profiler      *RuleProfiler                   //The profiler shared by all the rule sets
+}
+
+// Creates a new rule store which holds the initial rules
+// The rules are compiled once for every service based on the rules options of the service
+// @param logger - the logger interface
+// @param configuration - the configuration structure
+// @param initialRules - the rules loaded at startup (can be nil)
+// Returns the rule store or an error if the rules cannot be compiled
+func NewRuleStore(logger logging.ILogger, configuration config.Configuration, initialRules []Rule) (*RuleStore, error) {
+	store := &RuleStore{logger: logger, configuration: configuration, profiler: NewRuleProfiler(logger, configuration)}
+	ruleSets, err := store.compileRuleSets(initialRules)
+	if err != nil {
+		return nil, err
+	}
+	store.ruleSets.Store(ruleSets)
+	return store, nil
+}
+
+// Compiles the rule set with all the rules and the rule set of every service
+// The number of rules selected for every service is logged
+func (rs *RuleStore) compileRuleSets(rules []Rule) (*serviceRuleSets, error) {
+	all, err := NewRuleSet(rules)
+	if err != nil {
+		return nil, err
+	}
+	all.profiler = rs.profiler
+
+	ruleSets := &serviceRuleSets{all: all, services: make(map[*config.BackendServices]*RuleSet)}
+	for _, service := range rs.configuration.Services {
+		serviceRuleSet, err := NewRuleSet(SelectServiceRules(rules, service))
+		if err != nil {
+			return nil, errors.New("could not compile the rules of service " + service.Name + ", " + err.Error())
+		}
+		serviceRuleSet.profiler = rs.profiler
+		serviceRuleSet.service = service.Name
+		ruleSets.services[service] = serviceRuleSet
+		rs.logger.Info("Service", service.Name, "uses", serviceRuleSet.Count(), "of", all.Count(), "rules")
+	}
+	return ruleSets, nil
+}
+
+// Gets the rule set with all the loaded rules
+// The rule set is immutable, so it can be used by the handlers without locking
+func (rs *RuleStore) RuleSet() *RuleSet {
+	return rs.ruleSets.Load().all
+}
+
+// Gets the rule set selected for the service
+// If the service is not part of the configuration the rule set with all the rules is returned
+// @param service - the service from the configuration
+func (rs *RuleStore) ServiceRuleSet(service *config.BackendServices) *RuleSet {
+	ruleSets := rs.ruleSets.Load()
+	if ruleSet, ok := ruleSets.services[service]; ok {
+		return ruleSet
+	}
+	return ruleSets.all
+}
+
+// Gets the profiler which collects the evaluation statistics of the rules
+func (rs *RuleStore) Profiler() *RuleProfiler {
+	return rs.profiler
+}
+
+// Loads the rules from the rules directory and replaces the current rule sets
+// If any rule file is invalid the current rule sets are kept and the error is returned
+// Returns the number of rules in the previous rule set and the number of rules in the new rule set
+func (rs *RuleStore) Reload() (int, int, error) {
+	rs.reloadMutex.Lock()
+	defer rs.reloadMutex.Unlock()
+
+	previousCount := rs.RuleSet().Count()
+
+	//Check if the rules directory was specified in the configuration
+	if rs.configuration.RuleConfig.RulesDirectory == "" {
+		return previousCount, previousCount, errors.New("rules directory is not specified")
+	}
+
+	//Load all the rules and fail if any of them is invalid
+	newRules, err := LoadRulesFromDirectoryStrict(rs.configuration, rs.logger)
+	if err != nil {
+		return previousCount, previousCount, err
+	}
+
+	//Compile the new rules before replacing the current ones
+	newRuleSets, err := rs.compileRuleSets(newRules)
+	if err != nil {
+		return previousCount, previousCount, err
+	}
+
+	//Swap the rule sets, the handlers will pick them up on the next request
+	rs.ruleSets.Store(newRuleSets)
+	//The rules could have been changed, so the statistics start again and the slow rules are enabled
+	rs.profiler.Reset()
+
+	return previousCount, newRuleSets.all.Count(), nil
+}
@@ -0,0 +1,142 @@
+package detection
+
+import (
+	"errors"
+
+	"blueberry/internal/models"
+)
+
+// The classification of the findings of the transaction rules (the request attack succeeded based on the response)
+const SuccessfulExploitationClassification = "successful_exploitation"
+
+// The phases of the transaction a finding of a transaction rule was found in
+const (
+	TransactionPhaseRequest  = "request"
+	TransactionPhaseResponse = "response"
+)
+
+// Holds a request match of a transaction rule until the response of the transaction is checked
+type transactionRequestMatch struct {
+	matcherId string              //The id of the matcher which found the match (empty if not specified)
+	finding   *models.FindingData //The finding built from the match, located in the raw request
+}
+
+// Checks if the transaction rule has both the request and the response matchers
+// @param rule - the rule to check
+// Returns an error if the rule is a transaction rule without request or response section
+func CheckTransactionRule(rule Rule) error {
+	if !rule.Transaction {
+		return nil
+	}
+	if rule.Request == nil || rule.Response == nil {
+		return errors.New("transaction rule should have both request and response sections")
+	}
+	return nil
+}
+
+// Gets the severity of the findings of a transaction rule, which is one level above the severity of the rule
+func elevateSeverity(severity int64) int64 {
+	if severity >= models.CRITICAL {
+		return models.CRITICAL
+	}
+	return severity + 1
+}
+
+// Creates the finding of a transaction rule from a match
+func (rl *RuleRunner) newTransactionFinding(rule *Rule, phase string, match searchMatch) *models.FindingData {
+	finding := rl.newMatchFinding(rule, match)
+	finding.Classification = SuccessfulExploitationClassification
+	finding.Severity = elevateSeverity(finding.Severity)
+	finding.Phase = phase
+	return finding
+}
+
+// Creates the finding of a transaction rule from a body hash match
+func newTransactionHashFinding(rule *Rule, phase string, hashMatch BodyHashMatch) *models.FindingData {
+	return &models.FindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: SuccessfulExploitationClassification, Severity: elevateSeverity(ConvertSeverityStringToInteger(rule.Info.Severity)), MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash)), Target: evidenceTargetBody, Offset: -1, Phase: phase}
+}
+
+// Saves the request matches of a transaction rule so they can be correlated with the response matches
+// @param rule - the transaction rule
+// @param matches - the matches found by the request matchers
+// @param hashMatches - the request body hash matches
+// Returns the findings built from the matches, they should be located in the raw request
+func (rl *RuleRunner) saveTransactionRequestMatches(rule *Rule, matches []searchMatch, hashMatches []BodyHashMatch) []*models.FindingData {
+	requestMatches := make([]transactionRequestMatch, 0, len(matches)+len(hashMatches))
+	findings := make([]*models.FindingData, 0, len(matches)+len(hashMatches))
+	for _, match := range matches {
+		finding := rl.newTransactionFinding(rule, TransactionPhaseRequest, match)
+		requestMatches = append(requestMatches, transactionRequestMatch{matcherId: match.matcherId, finding: finding})
+		findings = append(findings, finding)
+	}
+	for _, hashMatch := range hashMatches {
+		finding := newTransactionHashFinding(rule, TransactionPhaseRequest, hashMatch)
+		requestMatches = append(requestMatches, transactionRequestMatch{matcherId: hashMatch.MatcherId, finding: finding})
+		findings = append(findings, finding)
+	}
+	rl.transactionRequests[rule.Id] = requestMatches
+	return findings
+}
+
+// Correlates the response matches of a transaction rule with the request matches saved for the same transaction
+// Without a condition the rule fires when both the request and the response matched, otherwise the condition
+// is evaluated on the request and the response matchers together
+// @param compiled - the compiled transaction rule
+// @param matches - the matches found by the response matchers
+// @param hashMatches - the response body hash matches
+// Returns if the rule fired, the request findings (already located in the raw request) and the response findings
+func (rl *RuleRunner) correlateTransaction(compiled *compiledRule, matches []searchMatch, hashMatches []BodyHashMatch) (bool, []*models.FindingData, []*models.FindingData) {
+	rule := compiled.rule
+	requestMatches, ok := rl.transactionRequests[rule.Id]
+	if !ok {
+		return false, nil, nil
+	}
+
+	if compiled.condition != nil {
+		//Evaluate the condition on the matchers of both phases
+		matched := make(map[string]bool)
+		for _, requestMatch := range requestMatches {
+			matched[requestMatch.matcherId] = true
+		}
+		for _, match := range matches {
+			matched[match.matcherId] = true
+		}
+		for _, hashMatch := range hashMatches {
+			matched[hashMatch.MatcherId] = true
+		}
+		conditionMet, matcherIds := compiled.condition.Evaluate(matched)
+		if !conditionMet {
+			return false, nil, nil
+		}
+
+		//Keep only the matches which made the condition true
+		filtered := make([]transactionRequestMatch, 0, len(requestMatches))
+		for _, requestMatch := range requestMatches {
+			if matcherIds[requestMatch.matcherId] {
+				filtered = append(filtered, requestMatch)
+			}
+		}
+		requestMatches = filtered
+		matches = filterMatches(matches, matcherIds)
+		hashMatches = filterHashMatches(hashMatches, matcherIds)
+		//The condition is true but no matcher made it true, so the rule itself is the response finding
+		if len(requestMatches)+len(matches)+len(hashMatches) == 0 {
+			matches = append(matches, newConditionMatch(compiled.condition))
+		}
+	} else if len(requestMatches) == 0 || len(matches)+len(hashMatches) == 0 {
+		return false, nil, nil
+	}
+
+	requestFindings := make([]*models.FindingData, 0, len(requestMatches))
+	for _, requestMatch := range requestMatches {
+		requestFindings = append(requestFindings, requestMatch.finding)
+	}
+	responseFindings := make([]*models.FindingData, 0, len(matches)+len(hashMatches))
+	for _, match := range matches {
+		responseFindings = append(responseFindings, rl.newTransactionFinding(rule, TransactionPhaseResponse, match))
+	}
+	for _, hashMatch := range hashMatches {
+		responseFindings = append(responseFindings, newTransactionHashFinding(rule, TransactionPhaseResponse, hashMatch))
+	}
+	return true, requestFindings, responseFindings
+}
@@ -0,0 +1,258 @@
+package detection
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// A transformation decodes or normalizes a value before the rule matchers are run on it
+// Returns the transformed value or an error if the value cannot be transformed (the decoding chain stops)
+type Transformation func(value string) (string, error)
+
+// The transformations which can be specified in the encodings lists of the rules
+var transformations = map[string]Transformation{
+	//Decoders
+	"base64":        decodeBase64,
+	"base64url":     decodeBase64URL,
+	"url":           url.QueryUnescape,
+	"double_url":    decodeDoubleURL,
+	"hex":           decodeHex,
+	"html":          decodeHTMLEntities,
+	"unicode":       decodeUnicodeEscapes,
+	"utf8_overlong": decodeUTF8Overlong,
+	"sql_char":      decodeSQLChar,
+	"sql_comments":  removeSQLComments,
+	//Normalizers
+	"lowercase":           normalizeLowercase,
+	"path":                normalizePath,
+	"compress_whitespace": compressWhitespace,
+	"remove_nulls":        removeNullBytes,
+}
+
+// Registers a new transformation which can be used in the encodings lists of the rules
+// It should be called before the rules are loaded, the transformations are not protected for concurrent access
+// @param name - the name used in the rule files (case insensitive)
+// @param transformation - the transformation function
+func RegisterTransformation(name string, transformation Transformation) {
+	transformations[strings.ToLower(name)] = transformation
+}
+
+// Gets the transformation registered with the name
+// Returns the transformation and if it was found
+func GetTransformation(name string) (Transformation, bool) {
+	transformation, ok := transformations[strings.ToLower(name)]
+	return transformation, ok
+}
+
+// Checks if the encoding is one of the registered transformations (case insensitive)
+func IsSupportedEncoding(encoding string) bool {
+	_, ok := GetTransformation(encoding)
+	return ok
+}
+
+// Gets the names of all the registered transformations sorted alphabetically
+func SupportedEncodings() []string {
+	names := make([]string, 0, len(transformations))
+	for name := range transformations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decodes standard base64
+func decodeBase64(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// Decodes the URL safe base64 (with or without padding)
+func decodeBase64URL(value string) (string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(decoded), nil
+}
+
+// Decodes a value which was URL encoded twice
+func decodeDoubleURL(value string) (string, error) {
+	decoded, err := url.QueryUnescape(value)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryUnescape(decoded)
+}
+
+// The escaped hex bytes which can appear inside a value (\x41)
+var hexEscapeRegex = regexp.MustCompile(`\\x[0-9a-fA-F]{2}`)
+
+// Decodes a hex string (optionally prefixed by 0x) or the \xHH escapes inside the value
+func decodeHex(value string) (string, error) {
+	//The whole value is a hex string
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if decoded, err := hex.DecodeString(trimmed); err == nil && len(trimmed) > 0 {
+		return string(decoded), nil
+	}
+
+	//Decode the escaped bytes
+	if !hexEscapeRegex.MatchString(value) {
+		return "", errors.New("value does not contain hex encoded data")
+	}
+	return hexEscapeRegex.ReplaceAllStringFunc(value, func(escape string) string {
+		b, _ := hex.DecodeString(escape[2:])
+		return string(b)
+	}), nil
+}
+
+// Decodes the HTML entities (named, decimal and hex)
+func decodeHTMLEntities(value string) (string, error) {
+	return html.UnescapeString(value), nil
+}
+
+// The JavaScript unicode escapes (\uXXXX)
+var unicodeEscapeRegex = regexp.MustCompile(`\\u[0-9a-fA-F]{4}`)
+
+// Decodes the JavaScript \uXXXX escapes, the surrogate pairs are combined
+func decodeUnicodeEscapes(value string) (string, error) {
+	locations := unicodeEscapeRegex.FindAllStringIndex(value, -1)
+	if len(locations) == 0 {
+		return "", errors.New("value does not contain unicode escapes")
+	}
+
+	var builder strings.Builder
+	last := 0
+	for i := 0; i < len(locations); i++ {
+		builder.WriteString(value[last:locations[i][0]])
+		code, _ := strconv.ParseUint(value[locations[i][0]+2:locations[i][1]], 16, 16)
+		r := rune(code)
+		last = locations[i][1]
+		//Combine the surrogate pair if the next escape follows immediately
+		if utf16.IsSurrogate(r) && i+1 < len(locations) && locations[i+1][0] == last {
+			next, _ := strconv.ParseUint(value[locations[i+1][0]+2:locations[i+1][1]], 16, 16)
+			if combined := utf16.DecodeRune(r, rune(next)); combined != unicode.ReplacementChar {
+				r = combined
+				i++
+				last = locations[i][1]
+			}
+		}
+		builder.WriteRune(r)
+	}
+	builder.WriteString(value[last:])
+	return builder.String(), nil
+}
+
+// Decodes the overlong UTF-8 sequences (like 0xC0 0xAE for '.') into the characters they represent
+func decodeUTF8Overlong(value string) (string, error) {
+	data := []byte(value)
+	result := make([]byte, 0, len(data))
+	found := false
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		//2 bytes sequence which encodes a code point lower than 0x80
+		case (b == 0xC0 || b == 0xC1) && i+1 < len(data) && data[i+1]&0xC0 == 0x80:
+			result = append(result, (b&0x1F)<<6|(data[i+1]&0x3F))
+			i++
+			found = true
+		//3 bytes sequence which encodes a code point lower than 0x800
+		case b == 0xE0 && i+2 < len(data) && data[i+1]&0xE0 == 0x80 && data[i+2]&0xC0 == 0x80:
+			result = append(result, []byte(string(rune(b&0x0F)<<12|rune(data[i+1]&0x3F)<<6|rune(data[i+2]&0x3F)))...)
+			i += 2
+			found = true
+		//4 bytes sequence which encodes a code point lower than 0x10000
+		case b == 0xF0 && i+3 < len(data) && data[i+1]&0xF0 == 0x80 && data[i+2]&0xC0 == 0x80 && data[i+3]&0xC0 == 0x80:
+			result = append(result, []byte(string(rune(b&0x07)<<18|rune(data[i+1]&0x3F)<<12|rune(data[i+2]&0x3F)<<6|rune(data[i+3]&0x3F)))...)
+			i += 3
+			found = true
+		default:
+			result = append(result, b)
+		}
+	}
+	if !found {
+		return "", errors.New("value does not contain overlong utf-8 sequences")
+	}
+	return string(result), nil
+}
+
+// The SQL CHAR() and CHR() calls with numeric arguments
+var sqlCharRegex = regexp.MustCompile(`(?i)\b(?:char|chr)\s*\(\s*(\d+(?:\s*,\s*\d+)*)\s*\)`)
+
+// The SQL concatenation operators between the decoded characters
+var sqlConcatRegex = regexp.MustCompile(`'\s*(?:\|\||\+)\s*'`)
+
+// Replaces the SQL CHAR(65,66) and CHR(65)||CHR(66) calls with the quoted characters they produce
+func decodeSQLChar(value string) (string, error) {
+	if !sqlCharRegex.MatchString(value) {
+		return "", errors.New("value does not contain sql char calls")
+	}
+	decoded := sqlCharRegex.ReplaceAllStringFunc(value, func(call string) string {
+		arguments := sqlCharRegex.FindStringSubmatch(call)[1]
+		var builder strings.Builder
+		for _, argument := range strings.Split(arguments, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(argument))
+			if err != nil || code > unicode.MaxRune {
+				return call
+			}
+			builder.WriteRune(rune(code))
+		}
+		return "'" + builder.String() + "'"
+	})
+	//Join the concatenated strings
+	return sqlConcatRegex.ReplaceAllString(decoded, ""), nil
+}
+
+// The SQL inline comments, the MySQL versioned comments keep their content
+var sqlCommentRegex = regexp.MustCompile(`(?s)/\*!\d*|/\*.*?\*/|\*/`)
+
+// Replaces the SQL inline comments with a space (UNION/**/SELECT becomes UNION SELECT)
+func removeSQLComments(value string) (string, error) {
+	return sqlCommentRegex.ReplaceAllString(value, " "), nil
+}
+
+// Converts the value to lowercase
+func normalizeLowercase(value string) (string, error) {
+	return strings.ToLower(value), nil
+}
+
+// The repeated path separators
+var multipleSlashesRegex = regexp.MustCompile(`/{2,}`)
+
+// Canonicalizes the path: the backslashes become slashes, the repeated slashes are merged and the /./ segments are removed
+// The /../ segments are kept so the path traversals can still be matched
+func normalizePath(value string) (string, error) {
+	normalized := strings.ReplaceAll(value, "\\", "/")
+	normalized = multipleSlashesRegex.ReplaceAllString(normalized, "/")
+	for strings.Contains(normalized, "/./") {
+		normalized = strings.ReplaceAll(normalized, "/./", "/")
+	}
+	return normalized, nil
+}
+
+// The sequences of whitespaces
+var whitespacesRegex = regexp.MustCompile(`\s+`)
+
+// Replaces every sequence of whitespaces with a single space
+func compressWhitespace(value string) (string, error) {
+	return whitespacesRegex.ReplaceAllString(value, " "), nil
+}
+
+// Removes the null bytes from the value
+func removeNullBytes(value string) (string, error) {
+	return strings.ReplaceAll(value, "\x00", ""), nil
+}
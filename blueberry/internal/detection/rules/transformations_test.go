@@ -0,0 +1,162 @@
+package detection
+
+import (
+	"testing"
+)
+
+// A value transformed by a transformation
+type transformationCase struct {
+	name     string
+	value    string
+	expected string
+	invalid  bool //If the transformation should fail on the value
+}
+
+func TestTransformations(t *testing.T) {
+	tests := []struct {
+		transformation string
+		cases          []transformationCase
+	}{
+		{
+			transformation: "hex",
+			cases: []transformationCase{
+				{name: "hex string", value: "3c7363726970743e", expected: "<script>"},
+				{name: "uppercase prefix", value: "0X3C3E", expected: "<>"},
+				{name: "lowercase prefix", value: "0x2e2e2f", expected: "../"},
+				{name: "escaped bytes", value: `a\x3cb\x3E`, expected: "a<b>"},
+				{name: "escaped null byte", value: `\x00`, expected: "\x00"},
+				{name: "odd length", value: "3c7", invalid: true},
+				{name: "only the prefix", value: "0x", invalid: true},
+				{name: "incomplete escape", value: `\x3`, invalid: true},
+				{name: "plain text", value: "select", invalid: true},
+			},
+		},
+		{
+			transformation: "html",
+			cases: []transformationCase{
+				{name: "named entities", value: "&lt;script&gt;", expected: "<script>"},
+				{name: "decimal entity", value: "&#60;a&#62;", expected: "<a>"},
+				{name: "hex entity", value: "&#x3C;a&#x3e;", expected: "<a>"},
+				{name: "entity without a semicolon", value: "&lt;a", expected: "<a"},
+				{name: "unknown entity", value: "&foo;", expected: "&foo;"},
+				{name: "plain text", value: "a & b", expected: "a & b"},
+			},
+		},
+		{
+			transformation: "unicode",
+			cases: []transformationCase{
+				{name: "escapes", value: `\u003cscript\u003E`, expected: "<script>"},
+				{name: "surrogate pair", value: `\ud83d\ude00`, expected: "\U0001F600"},
+				{name: "lone surrogate", value: `\ud83dx`, expected: "\uFFFDx"},
+				{name: "surrogates not adjacent", value: `\ud83d \ude00`, expected: "\uFFFD \uFFFD"},
+				{name: "short escape", value: `\u3c`, invalid: true},
+				{name: "plain text", value: "script", invalid: true},
+			},
+		},
+		{
+			transformation: "utf8_overlong",
+			cases: []transformationCase{
+				{name: "2 bytes dot", value: "\xc0\xae\xc0\xae/", expected: "../"},
+				{name: "2 bytes slash", value: "..\xc0\xaf", expected: "../"},
+				{name: "3 bytes slash", value: "\xe0\x80\xaf", expected: "/"},
+				{name: "4 bytes slash", value: "\xf0\x80\x80\xaf", expected: "/"},
+				{name: "truncated sequence", value: "\xc0", invalid: true},
+				{name: "valid utf-8", value: "café", invalid: true},
+			},
+		},
+		{
+			transformation: "sql_char",
+			cases: []transformationCase{
+				{name: "char with arguments", value: "CHAR(97,100,109)", expected: "'adm'"},
+				{name: "concatenated chr", value: "chr(97)||chr(98)", expected: "'ab'"},
+				{name: "concatenated with plus", value: "char(97) + char(98)", expected: "'ab'"},
+				{name: "argument out of range", value: "char(99999999)", expected: "char(99999999)"},
+				{name: "no arguments", value: "char()", invalid: true},
+				{name: "plain text", value: "select", invalid: true},
+			},
+		},
+		{
+			transformation: "sql_comments",
+			cases: []transformationCase{
+				{name: "inline comment", value: "UNION/**/SELECT", expected: "UNION SELECT"},
+				{name: "comment with content", value: "UNION/*foo*/SELECT", expected: "UNION SELECT"},
+				{name: "versioned comment", value: "/*!50000UNION*/SELECT", expected: " UNION SELECT"},
+				{name: "multiline comment", value: "a/*\n*/b", expected: "a b"},
+				{name: "unterminated comment", value: "a/*b", expected: "a/*b"},
+				{name: "no comments", value: "select 1", expected: "select 1"},
+			},
+		},
+		{
+			transformation: "double_url",
+			cases: []transformationCase{
+				{name: "encoded twice", value: "%253Cscript%253E", expected: "<script>"},
+				{name: "encoded once", value: "%3Cscript%3E", expected: "<script>"},
+				{name: "invalid first escape", value: "%zz", invalid: true},
+				{name: "invalid second escape", value: "%25zz", invalid: true},
+			},
+		},
+		{
+			transformation: "base64url",
+			cases: []transformationCase{
+				{name: "url alphabet", value: "PDw_Pz4-", expected: "<<??>>"},
+				{name: "with padding", value: "YWI=", expected: "ab"},
+				{name: "without padding", value: "YWI", expected: "ab"},
+				{name: "standard alphabet", value: "PDw/Pz4+", invalid: true},
+				{name: "invalid length", value: "Y", invalid: true},
+			},
+		},
+		{
+			transformation: "path",
+			cases: []transformationCase{
+				{name: "backslashes", value: `..\..\windows`, expected: "../../windows"},
+				{name: "repeated slashes", value: "/a//b///c", expected: "/a/b/c"},
+				{name: "current directory", value: "/a/./b", expected: "/a/b"},
+				{name: "consecutive current directories", value: "/a/././b", expected: "/a/b"},
+				{name: "parent directory kept", value: "/a/../b", expected: "/a/../b"},
+				{name: "already canonical", value: "/a/b", expected: "/a/b"},
+			},
+		},
+		{
+			transformation: "compress_whitespace",
+			cases: []transformationCase{
+				{name: "spaces", value: "union   select", expected: "union select"},
+				{name: "mixed whitespaces", value: "union\t\r\n select", expected: "union select"},
+				{name: "leading and trailing", value: "  a  ", expected: " a "},
+				{name: "no whitespace", value: "a", expected: "a"},
+			},
+		},
+		{
+			transformation: "remove_nulls",
+			cases: []transformationCase{
+				{name: "null bytes", value: "sel\x00ect\x00", expected: "select"},
+				{name: "only null bytes", value: "\x00\x00", expected: ""},
+				{name: "no null bytes", value: "select", expected: "select"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		transformation, ok := GetTransformation(test.transformation)
+		if !ok {
+			t.Errorf("GetTransformation(%q) not found", test.transformation)
+			continue
+		}
+		for _, tc := range test.cases {
+			t.Run(test.transformation+"/"+tc.name, func(t *testing.T) {
+				result, err := transformation(tc.value)
+				if tc.invalid {
+					if err == nil {
+						t.Errorf("%s(%q) = %q, expected an error", test.transformation, tc.value, result)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("%s(%q) error = %v", test.transformation, tc.value, err)
+				}
+				if result != tc.expected {
+					t.Errorf("%s(%q) = %q, expected %q", test.transformation, tc.value, result, tc.expected)
+				}
+			})
+		}
+	}
+}
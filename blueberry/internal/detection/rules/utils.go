@@ -2,6 +2,7 @@ package detection
 
 import (
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -14,14 +15,29 @@ import (
 	data "blueberry/internal/models"
 )
 
-var SupportedEncodings = []string{"base64", "url"}
-
 // Loads all the rules that can be found in the specified directory
 // Pass the logger as a parameter for better view of the problems
 // @param rulesDirectory - the directory from which the rules should be pulled
 // @param logger - the logger to be used to display the errors
 // If the directory cannot be opened to read all the files in it then an error is returned
 func LoadRulesFromDirectory(configuration config.Configuration, logger logging.ILogger) ([]Rule, error) {
+	return loadRulesFromDirectory(configuration, logger, false)
+}
+
+// Loads all the rules that can be found in the specified directory, but fails if any rule file is invalid
+// This is used when reloading the rules at runtime so that a broken file cannot replace a working rule set
+// @param configuration - the configuration of the agent
+// @param logger - the logger to be used to display the errors
+// Returns the list of rules or an error which contains the problems of every invalid rule file
+func LoadRulesFromDirectoryStrict(configuration config.Configuration, logger logging.ILogger) ([]Rule, error) {
+	return loadRulesFromDirectory(configuration, logger, true)
+}
+
+// Walks the rules directory and loads every rule file
+// @param configuration - the configuration of the agent
+// @param logger - the logger to be used to display the errors
+// @param strict - if the invalid rule files should make the load fail instead of being skipped
+func loadRulesFromDirectory(configuration config.Configuration, logger logging.ILogger, strict bool) ([]Rule, error) {
 	rulesDirectory := configuration.RuleConfig.RulesDirectory
 
 	//Check if the directory exists
@@ -29,19 +45,38 @@ func LoadRulesFromDirectory(configuration config.Configuration, logger logging.I
 	if err != nil {
 		return nil, errors.New("rules directory does not exist")
 	}
+	//Load the macros shared by the rules, the wordlists are loaded when the rules reference them
+	library, err := LoadRuleLibrary(configuration)
+	if err != nil {
+		return nil, errors.New("could not load the rule library, " + err.Error())
+	}
+	//Holds the problems found in the rule files when running in strict mode
+	ruleFileErrors := make([]error, 0)
+	//Skips the rule file or saves the problem if the load is strict
+	skipRuleFile := func(path string, reason string) {
+		logger.Warning("Skipping rule file", path, reason)
+		if strict {
+			ruleFileErrors = append(ruleFileErrors, fmt.Errorf("%s: %s", path, reason))
+		}
+	}
+
 	//Traverse the directory to get all the rules and append them to the list
 	rulesList := make([]Rule, 0)
 	err = filepath.WalkDir(rulesDirectory, func(path string, d fs.DirEntry, err error) error {
+		//Check if the directory entry could be read
+		if err != nil {
+			return err
+		}
 		//Check if the directory is not in the list of ignored directories from the config
 		if d.IsDir() {
-			if configuration.RuleConfig.IgnoreRulesDirectories != nil {
-				for _, ignoreDir := range configuration.RuleConfig.IgnoreRulesDirectories {
-					if ignoreDir == d.Name() {
-						logger.Info("Skipped rule directory", d.Name(), ", present in list of ignored directories")
-						//Skip the directory
-						return filepath.SkipDir
-					}
-				}
+			//The library directory contains the wordlists and the macros, not rules
+			if IsLibraryDirectory(configuration, path) {
+				return filepath.SkipDir
+			}
+			if IsIgnoredRulesDirectory(configuration, d.Name()) {
+				logger.Info("Skipped rule directory", d.Name(), ", present in list of ignored directories")
+				//Skip the directory
+				return filepath.SkipDir
 			}
 		}
 
@@ -59,20 +94,23 @@ func LoadRulesFromDirectory(configuration config.Configuration, logger logging.I
 			//Check if an error occured when opening the yaml rule file
 			if err != nil {
 				//Log the error
-				logger.Warning("Skipping rule file", path, "could not open the file for reading", err.Error())
+				skipRuleFile(path, "could not open the file for reading, "+err.Error())
 				return nil
 			}
+			defer file.Close()
 			err = rule.FromYAML(file)
 			//Check if an error occured when loading the yaml file
 			if err != nil {
-				logger.Warning("Skipping rule file", path, "error when parsing", err.Error())
+				skipRuleFile(path, "error when parsing, "+err.Error())
 				return nil
 			}
+			//Expand the macros and load the wordlists before checking the rule
+			library.ResolveRule(&rule)
 			//Check if the rule is valid
 			err = CheckRule(rule, logger)
 			if err != nil {
 				//The rule is not valid
-				logger.Warning("Skipping rule file", path, "error when checking rule,", err.Error())
+				skipRuleFile(path, "error when checking rule, "+err.Error())
 				return nil
 			}
 			//Check if the rule id is not already in the list of rules
@@ -84,17 +122,24 @@ func LoadRulesFromDirectory(configuration config.Configuration, logger logging.I
 				}
 			}
 			if found {
-				logger.Warning("Skipping rule file", path, "a rule with this id already exists")
+				skipRuleFile(path, "a rule with this id already exists")
 				return nil
 			}
 
 			//Apply the encodings to the matching subrules based on the global and local encodings lists
 			err = HandleEncodingsField(&rule)
 			if err != nil {
-				logger.Warning("Skipping rule file", path, "error occured when handling encodings lists", err.Error())
+				skipRuleFile(path, "error occured when handling encodings lists, "+err.Error())
 				return nil
 			}
 
+			//Save where the rule was loaded from so the services can select the rules by directory
+			relativePath, err := filepath.Rel(rulesDirectory, path)
+			if err != nil {
+				relativePath = path
+			}
+			rule.Path = filepath.ToSlash(relativePath)
+
 			//Add the rule read from file to the list of rules
 			rulesList = append(rulesList, rule)
 		}
@@ -103,9 +148,26 @@ func LoadRulesFromDirectory(configuration config.Configuration, logger logging.I
 	if err != nil {
 		return nil, errors.New("could not walk rules directory")
 	}
+
+	//In strict mode a single invalid rule file invalidates the whole set
+	if len(ruleFileErrors) > 0 {
+		return nil, errors.Join(ruleFileErrors...)
+	}
 	return rulesList, nil
 }
 
+// Checks if the directory name is in the list of ignored rules directories from the configuration
+// @param configuration - the configuration of the agent
+// @param directoryName - the name of the directory (not the full path)
+func IsIgnoredRulesDirectory(configuration config.Configuration, directoryName string) bool {
+	for _, ignoreDir := range configuration.RuleConfig.IgnoreRulesDirectories {
+		if ignoreDir == directoryName {
+			return true
+		}
+	}
+	return false
+}
+
 // Adds the encodings field based on the definition of the encodings
 // @rule - the rule to set the correct encoding fields
 // Returns an error if
@@ -138,6 +200,24 @@ func HandleEncodingsField(rule *Rule) error {
 					bodyRule.Encodings = rule.Info.Encodings
 				}
 			}
+
+			//Inherit the list of global encodings to the cookies matching rules
+			for _, cookiesRule := range rule.Request.Cookies {
+				//If there is not a list of encodings specified (local encodings)
+				if cookiesRule.Encodings == nil {
+					cookiesRule.Encodings = rule.Info.Encodings
+				}
+			}
+
+			//Inherit the list of global encodings to the URL components and request line matching rules
+			for _, target := range rule.Request.Targets() {
+				for _, targetRule := range target.Matchers {
+					//If there is not a list of encodings specified (local encodings)
+					if targetRule.Encodings == nil {
+						targetRule.Encodings = rule.Info.Encodings
+					}
+				}
+			}
 		}
 
 		if rule.Response != nil {
@@ -212,19 +292,17 @@ func CheckRuleInfo(info *RuleInfo) error {
 	//Check if the encodings is a list containing supported encodings
 	if info.Encodings != nil {
 		for _, encoding := range info.Encodings {
-			var found bool = false
-			for _, supportedEncoding := range SupportedEncodings {
-				if strings.ToLower(encoding) == supportedEncoding {
-					found = true
-				}
-			}
-
-			if !found {
+			if !IsSupportedEncoding(encoding) {
 				return errors.New("rule encodings contains unsuported encodings, " + encoding)
 			}
 		}
 	}
 
+	//Check the references and the CWE, OWASP and CVE identifiers
+	if err := CheckRuleMetadata(info); err != nil {
+		return errors.New("rule metadata is not valid, " + err.Error())
+	}
+
 	return nil
 }
 
@@ -237,6 +315,10 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 	if err := CheckRuleInfo(rule.Info); err != nil {
 		return err
 	}
+	//Check if the macros and the wordlists of the rule were found in the library
+	if err := CheckRuleLibrary(rule); err != nil {
+		return err
+	}
 
 	//Check if the request field exists in the rule
 	if rule.Request != nil {
@@ -261,6 +343,12 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 				if _, err := regexp.Compile(parameter.Regex); err != nil {
 					return errors.New("cannot compile regex for parameter, " + parameter.Name + ", " + err.Error())
 				}
+				//Check if the selector of the body value is valid
+				if parameter.Selector != "" {
+					if _, err := compileBodySelector(parameter.Selector); err != nil {
+						return errors.New("invalid selector for parameter, " + err.Error())
+					}
+				}
 			}
 		}
 		//Check if the headers regex compiles
@@ -271,6 +359,20 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 				}
 			}
 		}
+		//Check if the cookies regex compiles
+		for _, cookie := range rule.Request.Cookies {
+			if _, err := regexp.Compile(cookie.Regex); err != nil {
+				return errors.New("cannot compile regex for cookie, " + cookie.Name + ", " + err.Error())
+			}
+		}
+		//Check if the URL components and request line regex compiles
+		for _, target := range rule.Request.Targets() {
+			for _, targetRule := range target.Matchers {
+				if _, err := regexp.Compile(targetRule.Regex); err != nil {
+					return errors.New("cannot compile regex for " + target.Name + ", " + err.Error())
+				}
+			}
+		}
 		//Check if the regex for body
 		if rule.Request.Body != nil {
 			for _, bodyRule := range rule.Request.Body {
@@ -320,6 +422,46 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 		return errors.New("subfield contains invalid encoding, " + err.Error())
 	}
 
+	//Check the upstream timing matchers of the response
+	if rule.Response != nil {
+		if err := CheckDurationRule(rule.Response.Latency); err != nil {
+			return errors.New("invalid latency matcher, " + err.Error())
+		}
+		if err := CheckDurationRule(rule.Response.TTFB); err != nil {
+			return errors.New("invalid ttfb matcher, " + err.Error())
+		}
+	}
+
+	//Check if the transaction rule has both the request and the response matchers
+	if err := CheckTransactionRule(rule); err != nil {
+		return err
+	}
+
+	//Check the scope of the rule
+	if err := CheckRuleScope(rule.Scope); err != nil {
+		return errors.New("invalid scope, " + err.Error())
+	}
+
+	//Check the exclusions of the allow rule
+	if err := CheckRuleExclusions(rule); err != nil {
+		return errors.New("invalid exclusions, " + err.Error())
+	}
+
+	//Check the expression evaluated on the transaction
+	if err := CheckRuleExpression(rule); err != nil {
+		return errors.New("invalid expr, " + err.Error())
+	}
+
+	//Check the condition which combines the matchers
+	if err := CheckRuleCondition(rule); err != nil {
+		return errors.New("invalid condition, " + err.Error())
+	}
+
+	//Check the test cases embedded in the rule
+	if err := CheckRuleTests(rule.Tests); err != nil {
+		return errors.New("invalid tests, " + err.Error())
+	}
+
 	return nil
 }
 
@@ -365,6 +507,24 @@ func CheckEncodingSubfields(rule Rule, logger logging.ILogger) error {
 			}
 		}
 
+		//Check the request cookies rules
+		for _, subRule := range rule.Request.Cookies {
+			err := CheckEncodingsList(subRule.Encodings)
+			if err != nil {
+				return errors.New("Invalid encodings list in request cookie " + subRule.Name + ", " + err.Error())
+			}
+		}
+
+		//Check the URL components and request line rules
+		for _, target := range rule.Request.Targets() {
+			for _, subRule := range target.Matchers {
+				err := CheckEncodingsList(subRule.Encodings)
+				if err != nil {
+					return errors.New("Invalid encodings list in request " + target.Name + ", " + err.Error())
+				}
+			}
+		}
+
 		//Check the request body rule
 		for _, subRule := range rule.Request.Body {
 			err := CheckEncodingsList(subRule.Encodings)
@@ -406,10 +566,8 @@ func CheckEncodingSubfields(rule Rule, logger logging.ILogger) error {
 
 func CheckEncodingsList(encodings []string) error {
 	for _, encoding := range encodings {
-		for _, supportedEncoding := range SupportedEncodings {
-			if strings.ToLower(encoding) != supportedEncoding {
-				return errors.New("invalid encoding specified, " + encoding)
-			}
+		if !IsSupportedEncoding(encoding) {
+			return errors.New("invalid encoding specified, " + encoding)
 		}
 	}
 	return nil
@@ -433,6 +591,24 @@ func ConvertSeverityStringToInteger(severity string) int64 {
 	}
 }
 
+// Converts the severity integer value to the string representation
+// @param severity - the integer value of the severity
+// Returns the string representation (low, medium, high, critical) or an empty string if the severity does not exist
+func ConvertSeverityIntegerToString(severity int64) string {
+	switch severity {
+	case data.LOW:
+		return "low"
+	case data.MEDIUM:
+		return "medium"
+	case data.HIGH:
+		return "high"
+	case data.CRITICAL:
+		return "critical"
+	default:
+		return ""
+	}
+}
+
 // Gets the action for a specific rule
 // @param rules - the list of rules loaded from disk
 // @param ruleId - the id of the rule to retrieve the action field
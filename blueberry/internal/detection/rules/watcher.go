@@ -0,0 +1,142 @@
+package detection
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// The time to wait after the last change in the rules directory before triggering a reload
+// Editors usually generate multiple events when saving a file so they are grouped together
+const RulesWatcherDebounce = 500 * time.Millisecond
+
+// Watches the rules directory and calls the change callback when a rule file is created, modified or removed
+type RulesWatcher struct {
+	logger        logging.ILogger      //The logger interface
+	configuration config.Configuration //The configuration structure
+	onChange      func()               //The function called after the rules directory changed
+	watcher       *fsnotify.Watcher    //The underlying file system watcher
+	timer         *time.Timer          //The debounce timer
+	timerMutex    sync.Mutex           //The mutex for the debounce timer
+}
+
+// Creates a new rules watcher
+func NewRulesWatcher(logger logging.ILogger, configuration config.Configuration, onChange func()) *RulesWatcher {
+	return &RulesWatcher{logger: logger, configuration: configuration, onChange: onChange}
+}
+
+// Adds the directory and all the subdirectories which are not ignored to the watcher
+func (rw *RulesWatcher) addDirectory(directory string) error {
+	return filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		//Skip the ignored directories, the same way the loader does
+		if IsIgnoredRulesDirectory(rw.configuration, d.Name()) {
+			return filepath.SkipDir
+		}
+		return rw.watcher.Add(path)
+	})
+}
+
+// Starts watching the rules directory
+// The events are handled in a separate go routine
+func (rw *RulesWatcher) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	rw.watcher = watcher
+
+	//fsnotify is not recursive so every subdirectory has to be added
+	err = rw.addDirectory(rw.configuration.RuleConfig.RulesDirectory)
+	if err != nil {
+		rw.watcher.Close()
+		return err
+	}
+	//The library directory can be outside the rules directory
+	libraryDirectory := GetLibraryDirectory(rw.configuration)
+	if relativePath, err := filepath.Rel(rw.configuration.RuleConfig.RulesDirectory, libraryDirectory); err != nil || strings.HasPrefix(relativePath, "..") {
+		if _, err := os.Stat(libraryDirectory); err == nil {
+			if err := rw.addDirectory(libraryDirectory); err != nil {
+				rw.watcher.Close()
+				return err
+			}
+		}
+	}
+
+	go rw.handleEvents()
+
+	return nil
+}
+
+// Handles the events received from the file system watcher
+func (rw *RulesWatcher) handleEvents() {
+	for {
+		select {
+		case event, ok := <-rw.watcher.Events:
+			if !ok {
+				return
+			}
+			//Watch the new directories so the rules added in them are picked up
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := rw.addDirectory(event.Name); err != nil {
+						rw.logger.Warning("Failed to watch new rules directory", event.Name, err.Error())
+					}
+					rw.scheduleChange()
+					continue
+				}
+			}
+			//Only the yaml files (rules and macros) and the text files (wordlists) change the rules
+			if !strings.HasSuffix(event.Name, ".yaml") && !strings.HasSuffix(event.Name, ".txt") {
+				continue
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				rw.logger.Debug("Rules directory changed", event.String())
+				rw.scheduleChange()
+			}
+		case err, ok := <-rw.watcher.Errors:
+			if !ok {
+				return
+			}
+			rw.logger.Error("Rules watcher error", err.Error())
+		}
+	}
+}
+
+// Schedules the change callback, resetting the timer if a change is already scheduled
+func (rw *RulesWatcher) scheduleChange() {
+	rw.timerMutex.Lock()
+	defer rw.timerMutex.Unlock()
+
+	if rw.timer != nil {
+		rw.timer.Stop()
+	}
+	rw.timer = time.AfterFunc(RulesWatcherDebounce, rw.onChange)
+}
+
+// Stops watching the rules directory
+func (rw *RulesWatcher) Close() error {
+	rw.timerMutex.Lock()
+	if rw.timer != nil {
+		rw.timer.Stop()
+	}
+	rw.timerMutex.Unlock()
+
+	if rw.watcher == nil {
+		return nil
+	}
+	return rw.watcher.Close()
+}
@@ -15,17 +15,29 @@ const (
 
 // Structure that will hold information about the findings
 type FindingData struct {
-	RuleId             string `json:"ruleId"`             //The rule id specified on the agent rule
-	RuleName           string `json:"ruleName"`           //The name of the rule specified on the agent
-	RuleDescription    string `json:"ruleDescription"`    //The description of the rule
-	Line               int64  `json:"line"`               //The line from the request where the finding is located
-	LineIndex          int64  `json:"lineIndex"`          //The offset from the start of the line
-	Length             int64  `json:"length"`             //The length of the finding string
-	MatchedString      string `json:"matchedString"`      //The string on which the rule matched
-	MatchedBodyHash    string `json:"matchedBodyHash"`    //The hash of the body which matched
-	MatchedBodyHashAlg string `json:"matchedBodyHashAlg"` //The algorithm used for hashing the body
-	Classification     string `json:"classification"`     //The classification of the finding based on the string specified in the rule file
-	Severity           int64  `json:"severity"`           //The severity of the finding
+	RuleId             string   `json:"ruleId"`             //The rule id specified on the agent rule
+	RuleName           string   `json:"ruleName"`           //The name of the rule specified on the agent
+	RuleDescription    string   `json:"ruleDescription"`    //The description of the rule
+	Line               int64    `json:"line"`               //The line from the request where the finding is located
+	LineIndex          int64    `json:"lineIndex"`          //The offset from the start of the line
+	Length             int64    `json:"length"`             //The length of the finding string
+	MatchedString      string   `json:"matchedString"`      //The string on which the rule matched
+	MatchedBodyHash    string   `json:"matchedBodyHash"`    //The hash of the body which matched
+	MatchedBodyHashAlg string   `json:"matchedBodyHashAlg"` //The algorithm used for hashing the body
+	Classification     string   `json:"classification"`     //The classification of the finding based on the string specified in the rule file
+	Severity           int64    `json:"severity"`           //The severity of the finding
+	DecodingChain      []string `json:"decodingChain"`      //The decodings applied on the value before the rule matched (empty if it matched on the original value)
+	Target             string   `json:"target"`             //Where the match was found (like header:User-Agent, param:q or body.json:$.user.name)
+	Offset             int64    `json:"offset"`             //The byte offset of the evidence in the raw message (-1 if it is not located)
+	Evidence           string   `json:"evidence"`           //The original (not decoded) snippet of the raw message which matched
+	Phase              string   `json:"phase"`              //The phase of the transaction the finding was found in (request or response), set only for the transaction rules
+	References         []string `json:"references"`         //The URLs of the advisories and the documentation of the attack
+	CWE                []string `json:"cwe"`                //The CWE identifiers of the weakness (like CWE-22)
+	OWASP              []string `json:"owasp"`              //The OWASP Top 10 categories (like A03:2021)
+	CVE                []string `json:"cve"`                //The CVE identifiers of the vulnerabilities the rule detects
+	Tags               []string `json:"tags"`               //The tags of the rule
+	RuleAuthor         string   `json:"ruleAuthor"`         //The author of the rule
+	RuleVersion        string   `json:"ruleVersion"`        //The version of the rule
 }
 
 // Rule findings found by agent, one for request, one for response
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// This structure holds the outcome of a rules reload that is sent to the api
+type RulesReloadData struct {
+	AgentId            string   `json:"agentId"`            //The UUID of the agent that reloaded the rules
+	Timestamp          int64    `json:"timestamp"`          //Timestamp when the reload finished
+	Trigger            string   `json:"trigger"`            //What triggered the reload (sighup, watcher)
+	Success            bool     `json:"success"`            //If the new rule set is in use
+	PreviousRulesCount int64    `json:"previousRulesCount"` //The number of rules before the reload
+	RulesCount         int64    `json:"rulesCount"`         //The number of rules in use after the reload
+	Errors             []string `json:"errors"`             //The problems found in the rule files if the reload failed
+}
+
+// Convert json data to RulesReloadData structure
+func (rrd *RulesReloadData) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rrd)
+}
+
+// Convert RulesReloadData structure to json string
+func (rrd *RulesReloadData) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rrd)
+}
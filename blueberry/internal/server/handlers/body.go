@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// The policies applied when a body is larger than the inspection window
+const (
+	bodyLimitInspectPartial = "inspect_partial" //The rules are applied on the window and the whole body is forwarded
+	bodyLimitBlock          = "block"           //The request or the response is dropped
+	bodyLimitPass           = "pass"            //The body is not inspected and the whole body is forwarded
+)
+
+// The size of the chunks of the body streamed to the client
+const streamChunkSize = 32 * 1024
+
+// Holds the first bytes of a body which are inspected by the rules and the rest of the body which is streamed
+type bodyWindow struct {
+	data     []byte        //The bytes read from the body (at most one byte more than the limit)
+	limit    int64         //The number of bytes inspected by the rules
+	exceeded bool          //If the body is larger than the inspection window
+	rest     io.ReadCloser //The body after the bytes which were read (nil if the whole body was read)
+}
+
+// Joins the buffered bytes and the rest of the body into a single body
+type windowReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+// Closes the rest of the body
+func (wrc *windowReadCloser) Close() error {
+	if wrc.closer == nil {
+		return nil
+	}
+	return wrc.closer.Close()
+}
+
+// Reads the body of the request, moving the read deadline of the connection forward before every chunk
+// A client which sends the body slowly is not cut off as long as it does not stay idle longer than the timeout
+type idleTimeoutReader struct {
+	io.ReadCloser
+	controller *http.ResponseController
+	timeout    time.Duration
+}
+
+// Reads a chunk of the body after moving the read deadline forward
+func (itr *idleTimeoutReader) Read(p []byte) (int, error) {
+	//The deadline cannot be set on all the connections (like the ones used by the tests), the read is not limited then
+	itr.controller.SetReadDeadline(time.Now().Add(itr.timeout))
+	return itr.ReadCloser.Read(p)
+}
+
+// Wraps the body of the request so the client can stay idle for at most the timeout between two chunks
+// @param body - the body of the request
+// @param rw - the response writer of the request, used to set the deadline of the connection
+// @param timeout - the time the client can stay idle
+func newIdleTimeoutReader(body io.ReadCloser, rw http.ResponseWriter, timeout time.Duration) io.ReadCloser {
+	if body == nil || body == http.NoBody {
+		return body
+	}
+	return &idleTimeoutReader{ReadCloser: body, controller: http.NewResponseController(rw), timeout: timeout}
+}
+
+// Reads the inspection window of the body, the rest of the body is not read
+// One more byte than the limit is read to know if the body is larger than the window
+// @param body - the body of the request or the response (can be nil)
+// @param limit - the size of the inspection window
+// Returns the window or an error if the body could not be read
+func readBodyWindow(body io.ReadCloser, limit int64) (*bodyWindow, error) {
+	window := &bodyWindow{limit: limit}
+	if body == nil || body == http.NoBody {
+		return window, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	window.data = data
+	if int64(len(data)) > limit {
+		window.exceeded = true
+		window.rest = body
+	} else {
+		body.Close()
+	}
+	return window, nil
+}
+
+// Creates a window which does not buffer anything, the whole body is streamed without being inspected
+// @param body - the body of the response
+func newStreamingBodyWindow(body io.ReadCloser) *bodyWindow {
+	return &bodyWindow{rest: body}
+}
+
+// Gets the bytes of the body inspected by the rules
+// @param policy - the policy applied when the body is larger than the window
+func (bw *bodyWindow) inspected(policy string) []byte {
+	if bw.exceeded && policy == bodyLimitPass {
+		return nil
+	}
+	return bw.data[:min(int64(len(bw.data)), bw.limit)]
+}
+
+// Gets the whole body, the buffered bytes followed by the rest of the body
+func (bw *bodyWindow) stream() io.ReadCloser {
+	if bw.rest == nil {
+		if len(bw.data) == 0 {
+			return http.NoBody
+		}
+		return io.NopCloser(bytes.NewReader(bw.data))
+	}
+	return &windowReadCloser{Reader: io.MultiReader(bytes.NewReader(bw.data), bw.rest), closer: bw.rest}
+}
+
+// Closes the rest of the body, if it was not read
+func (bw *bodyWindow) close() {
+	if bw.rest != nil {
+		bw.rest.Close()
+	}
+}
+
+// Checks if the response is an event stream (Server-Sent Events), which is streamed without waiting for the inspection window
+func isEventStream(response *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// Sends the body to the client, flushing every chunk so the streamed responses are not delayed
+// The write deadline is moved forward before every chunk, so a long download or event stream is not cut off
+// as long as the client receives every chunk within the timeout
+// @param rw - the response writer of the client
+// @param body - the body to send
+// @param timeout - the time a chunk can take to be sent to the client
+// Returns an error if the body could not be read or sent
+func streamBody(rw http.ResponseWriter, body io.Reader, timeout time.Duration) error {
+	controller := http.NewResponseController(rw)
+	//The deadline is removed after the body was sent, so it does not apply to the next requests of the connection
+	defer controller.SetWriteDeadline(time.Time{})
+	buffer := make([]byte, streamChunkSize)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			controller.SetWriteDeadline(time.Now().Add(timeout))
+			if _, writeErr := rw.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+			controller.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
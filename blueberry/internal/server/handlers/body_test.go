@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Body which records if it was closed
+type testBody struct {
+	io.Reader
+	closed bool
+}
+
+// Records that the body was closed
+func (tb *testBody) Close() error {
+	tb.closed = true
+	return nil
+}
+
+// Reader which always fails
+type errorReader struct {
+	err error
+}
+
+// Returns the error of the reader
+func (er *errorReader) Read(p []byte) (int, error) {
+	return 0, er.err
+}
+
+func TestReadBodyWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		readErr   error
+		limit     int64
+		exceeded  bool
+		inspected map[string]string
+		closed    bool
+	}{
+		{
+			name:      "smaller than the window",
+			body:      "name=value",
+			limit:     16,
+			inspected: map[string]string{bodyLimitInspectPartial: "name=value", bodyLimitBlock: "name=value", bodyLimitPass: "name=value"},
+			closed:    true,
+		},
+		{
+			name:      "same size as the window",
+			body:      "name=value",
+			limit:     10,
+			inspected: map[string]string{bodyLimitInspectPartial: "name=value", bodyLimitBlock: "name=value", bodyLimitPass: "name=value"},
+			closed:    true,
+		},
+		{
+			name:      "larger than the window",
+			body:      "name=value&other=value",
+			limit:     10,
+			exceeded:  true,
+			inspected: map[string]string{bodyLimitInspectPartial: "name=value", bodyLimitBlock: "name=value", bodyLimitPass: ""},
+		},
+		{
+			name:    "read error",
+			body:    "name=value",
+			readErr: errors.New("connection reset"),
+			limit:   16,
+			closed:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reader io.Reader = strings.NewReader(test.body)
+			if test.readErr != nil {
+				reader = io.MultiReader(reader, &errorReader{err: test.readErr})
+			}
+			body := &testBody{Reader: reader}
+
+			window, err := readBodyWindow(body, test.limit)
+			if body.closed != test.closed {
+				t.Errorf("body closed = %v, expected %v", body.closed, test.closed)
+			}
+			if test.readErr != nil {
+				if !errors.Is(err, test.readErr) {
+					t.Errorf("readBodyWindow() error = %v, expected %v", err, test.readErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readBodyWindow() error = %v", err)
+			}
+			if window.exceeded != test.exceeded {
+				t.Errorf("exceeded = %v, expected %v", window.exceeded, test.exceeded)
+			}
+			for policy, expected := range test.inspected {
+				if inspected := string(window.inspected(policy)); inspected != expected {
+					t.Errorf("inspected(%s) = %q, expected %q", policy, inspected, expected)
+				}
+			}
+			//The whole body is forwarded whatever the size of the window
+			stream := window.stream()
+			data, err := io.ReadAll(stream)
+			if err != nil || string(data) != test.body {
+				t.Errorf("stream() = (%q, %v), expected %q", data, err, test.body)
+			}
+			stream.Close()
+			if !body.closed {
+				t.Errorf("body not closed after the stream was closed")
+			}
+		})
+	}
+}
+
+func TestReadBodyWindowWithoutBody(t *testing.T) {
+	for _, body := range []io.ReadCloser{nil, http.NoBody} {
+		window, err := readBodyWindow(body, 16)
+		if err != nil {
+			t.Fatalf("readBodyWindow() error = %v", err)
+		}
+		if window.exceeded || len(window.inspected(bodyLimitInspectPartial)) != 0 || window.stream() != http.NoBody {
+			t.Errorf("readBodyWindow() = %+v, expected an empty window", window)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"blueberry/internal/config"
+)
+
+// The pseudonym of the agent added to the Via header (RFC 9110, section 7.6.3)
+const viaPseudonym = "blueberry"
+
+// The hop-by-hop headers which are not forwarded (RFC 9110, section 7.6.1)
+// The headers listed in the Connection header are hop-by-hop as well
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// The forwarding headers a client could send to spoof its address, they are removed if the client is not a trusted proxy
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+// Holds how the headers of the requests are changed when they are forwarded to a service
+type forwardingPolicy struct {
+	preserveHost     bool         //If the Host header of the client is sent to the service
+	hostHeader       string       //The Host header sent to the service (empty to use the host of the remote URL or of the client)
+	trustedProxies   []*net.IPNet //The ranges of the proxies whose forwarding headers are kept
+	forwardedHeaders string       //The forwarding headers added (x_forwarded, forwarded, both or none)
+}
+
+// Creates the forwarding policy of the service
+// The trusted proxies are validated when the configuration is loaded, the invalid ones are skipped
+// @param service - the service from the configuration (can be nil)
+func newForwardingPolicy(service *config.BackendServices) *forwardingPolicy {
+	policy := &forwardingPolicy{forwardedHeaders: "both", trustedProxies: make([]*net.IPNet, 0)}
+	if service == nil {
+		return policy
+	}
+	policy.preserveHost = service.PreserveHost
+	policy.hostHeader = service.HostHeader
+	if service.ForwardedHeaders != "" {
+		policy.forwardedHeaders = service.ForwardedHeaders
+	}
+	for _, proxy := range service.TrustedProxies {
+		//A single address is a range with only that address
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxy = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			policy.trustedProxies = append(policy.trustedProxies, ipNet)
+		}
+	}
+	return policy
+}
+
+// Checks if the address is one of the trusted proxies
+func (fp *forwardingPolicy) isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range fp.trustedProxies {
+		if ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the hop-by-hop headers and the headers listed in the Connection header
+func removeHopByHopHeaders(header http.Header) {
+	for _, connection := range header.Values("Connection") {
+		for _, name := range strings.Split(connection, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// Copies the headers keeping every value separately, so the multi-value headers (like Set-Cookie) are not joined
+func copyHeaders(dst http.Header, src http.Header) {
+	for name, values := range src {
+		dst[name] = append(dst[name], values...)
+	}
+}
+
+// Adds the agent to the Via header with the protocol version the message was received with
+func addVia(header http.Header, protoMajor int, protoMinor int) {
+	version := strconv.Itoa(protoMajor)
+	if protoMajor < 2 {
+		version += "." + strconv.Itoa(protoMinor)
+	}
+	header.Add("Via", version+" "+viaPseudonym)
+}
+
+// Formats the address as a node of the Forwarded header (RFC 7239, section 6), the IPv6 addresses are quoted and in brackets
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// Sets the headers of the request forwarded to the service
+// The hop-by-hop headers are removed, the forwarding headers of the untrusted clients are removed and the new forwarding headers are appended
+// @param req - the request received from the client
+// @param proxyReq - the request forwarded to the service
+func (fp *forwardingPolicy) setRequestHeaders(req *http.Request, proxyReq *http.Request) {
+	proxyReq.Header = make(http.Header, len(req.Header))
+	copyHeaders(proxyReq.Header, req.Header)
+
+	//The client can ask for the trailers of the response, which is end-to-end even if the TE header is hop-by-hop
+	acceptsTrailers := false
+	for _, te := range req.Header.Values("Te") {
+		for _, coding := range strings.Split(te, ",") {
+			if strings.EqualFold(textproto.TrimString(coding), "trailers") {
+				acceptsTrailers = true
+			}
+		}
+	}
+	removeHopByHopHeaders(proxyReq.Header)
+	if acceptsTrailers {
+		proxyReq.Header.Set("Te", "trailers")
+	}
+
+	//Set the Host header, by default the host of the remote URL is used
+	if fp.hostHeader != "" {
+		proxyReq.Host = fp.hostHeader
+	} else if fp.preserveHost {
+		proxyReq.Host = req.Host
+	}
+
+	//The forwarding headers are kept only if the client is a trusted proxy
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+	if !fp.isTrustedProxy(net.ParseIP(clientIP)) {
+		for _, name := range forwardingHeaders {
+			proxyReq.Header.Del(name)
+		}
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	if fp.forwardedHeaders == "x_forwarded" || fp.forwardedHeaders == "both" {
+		forwardedFor := append(slices.Clone(proxyReq.Header.Values("X-Forwarded-For")), clientIP)
+		proxyReq.Header.Set("X-Forwarded-For", strings.Join(forwardedFor, ", "))
+		//The protocol and the host set by a trusted proxy are the ones the client used
+		if proxyReq.Header.Get("X-Forwarded-Proto") == "" {
+			proxyReq.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if proxyReq.Header.Get("X-Forwarded-Host") == "" {
+			proxyReq.Header.Set("X-Forwarded-Host", req.Host)
+		}
+	}
+	if fp.forwardedHeaders == "forwarded" || fp.forwardedHeaders == "both" {
+		element := "for=" + forwardedNode(clientIP) + ";proto=" + proto
+		if req.Host != "" {
+			element += ";host=" + strconv.Quote(req.Host)
+		}
+		forwarded := append(slices.Clone(proxyReq.Header.Values("Forwarded")), element)
+		proxyReq.Header.Set("Forwarded", strings.Join(forwarded, ", "))
+	}
+
+	addVia(proxyReq.Header, req.ProtoMajor, req.ProtoMinor)
+}
+
+// Sets the headers of the response sent back to the client
+// The hop-by-hop headers are removed and the trailers of the response are announced, they are sent after the body
+// @param header - the headers of the response sent to the client
+// @param response - the response received from the service
+func setResponseHeaders(header http.Header, response *http.Response) {
+	copyHeaders(header, response.Header)
+	removeHopByHopHeaders(header)
+	addVia(header, response.ProtoMajor, response.ProtoMinor)
+	for name := range response.Trailer {
+		header.Add("Trailer", name)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"blueberry/internal/config"
+)
+
+func TestSetRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		service      *config.BackendServices
+		remoteAddr   string
+		headers      map[string][]string
+		expected     map[string]string
+		removed      []string
+		expectedHost string
+	}{
+		{
+			name:       "default forwarding headers",
+			remoteAddr: "192.0.2.10:5000",
+			headers:    map[string][]string{"Accept": {"text/html"}},
+			expected: map[string]string{
+				"Accept":            "text/html",
+				"X-Forwarded-For":   "192.0.2.10",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "example.com",
+				"Forwarded":         `for=192.0.2.10;proto=http;host="example.com"`,
+				"Via":               "1.1 blueberry",
+			},
+		},
+		{
+			name:       "hop-by-hop headers",
+			remoteAddr: "192.0.2.10:5000",
+			headers:    map[string][]string{"Connection": {"keep-alive, X-Custom"}, "X-Custom": {"value"}, "Keep-Alive": {"timeout=5"}, "Upgrade": {"h2c"}, "Te": {"gzip, trailers"}},
+			expected:   map[string]string{"Te": "trailers"},
+			removed:    []string{"Connection", "X-Custom", "Keep-Alive", "Upgrade"},
+		},
+		{
+			name:       "forwarding headers of an untrusted client",
+			remoteAddr: "192.0.2.10:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}, "X-Forwarded-Proto": {"https"}, "Forwarded": {"for=203.0.113.1"}},
+			expected: map[string]string{
+				"X-Forwarded-For":   "192.0.2.10",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         `for=192.0.2.10;proto=http;host="example.com"`,
+			},
+		},
+		{
+			name:       "forwarding headers of a trusted proxy",
+			service:    &config.BackendServices{TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.10:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}, "X-Forwarded-Proto": {"https"}, "Forwarded": {"for=203.0.113.1"}},
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.1, 192.0.2.10",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         `for=203.0.113.1, for=192.0.2.10;proto=http;host="example.com"`,
+			},
+		},
+		{
+			name:       "single trusted address",
+			service:    &config.BackendServices{TrustedProxies: []string{"192.0.2.10"}, ForwardedHeaders: "x_forwarded"},
+			remoteAddr: "192.0.2.10:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			expected:   map[string]string{"X-Forwarded-For": "203.0.113.1, 192.0.2.10"},
+			removed:    []string{"Forwarded"},
+		},
+		{
+			name:       "forwarded header of an IPv6 client",
+			service:    &config.BackendServices{ForwardedHeaders: "forwarded"},
+			remoteAddr: "[2001:db8::1]:5000",
+			expected:   map[string]string{"Forwarded": `for="[2001:db8::1]";proto=http;host="example.com"`},
+			removed:    []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"},
+		},
+		{
+			name:       "no forwarding headers",
+			service:    &config.BackendServices{ForwardedHeaders: "none"},
+			remoteAddr: "192.0.2.10:5000",
+			removed:    []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"},
+		},
+		{
+			name:         "preserved host",
+			service:      &config.BackendServices{PreserveHost: true},
+			remoteAddr:   "192.0.2.10:5000",
+			expectedHost: "example.com",
+		},
+		{
+			name:         "host header",
+			service:      &config.BackendServices{PreserveHost: true, HostHeader: "internal.local"},
+			remoteAddr:   "192.0.2.10:5000",
+			expectedHost: "internal.local",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/search", nil)
+			req.RemoteAddr = test.remoteAddr
+			for name, values := range test.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			proxyReq := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8081/search", nil)
+			proxyReq.Host = ""
+
+			newForwardingPolicy(test.service).setRequestHeaders(req, proxyReq)
+			for name, expected := range test.expected {
+				if value := proxyReq.Header.Get(name); value != expected {
+					t.Errorf("header %s = %q, expected %q", name, value, expected)
+				}
+			}
+			for _, name := range test.removed {
+				if values := proxyReq.Header.Values(name); len(values) > 0 {
+					t.Errorf("header %s = %q, expected to be removed", name, values)
+				}
+			}
+			if proxyReq.Host != test.expectedHost {
+				t.Errorf("host = %q, expected %q", proxyReq.Host, test.expectedHost)
+			}
+		})
+	}
+}
@@ -2,10 +2,10 @@ package handlers
 
 import (
 	"bytes"
-	"errors"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 
 	ws_gorilla "github.com/gorilla/websocket"
@@ -16,6 +16,7 @@ import (
 	rules "blueberry/internal/detection/rules"
 	"blueberry/internal/logging"
 	"blueberry/internal/models"
+	"blueberry/internal/upstream"
 	"blueberry/internal/utils"
 	"blueberry/internal/websocket"
 )
@@ -24,85 +25,243 @@ import (
  * Structure which holds all the information needed by the handler for the HTTP requests
  */
 type BlueberryHTTPHandler struct {
-	logger           logging.ILogger                   //The logger interface
-	apiBaseURL       string                            //The API base URL
-	configuration    config.Configuration              //The configuration structure
-	forwardServerUrl string                            //The URL the requests should be forwarded to
-	checkers         []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
-	rules            []rules.Rule                      //The list of rules which will try to find anomalies in the requests and the responses
-	apiWsConn        *websocket.APIWebSocketConnection //The WS connection to the API
+	logger        logging.ILogger                   //The logger interface
+	apiBaseURL    string                            //The API base URL
+	configuration config.Configuration              //The configuration structure
+	service       *config.BackendServices           //The service the handler proxies the traffic for
+	upstreams     *upstream.Pool                    //The pool of targets the requests are balanced between
+	checkers      []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
+	ruleStore     *rules.RuleStore                  //The store which holds the rules that will try to find anomalies in the requests and the responses
+	apiWsConn     *websocket.APIWebSocketConnection //The WS connection to the API
+	targetMapper  *requestTargetMapper              //Maps the path and the query of the requests to the URL of the target (nil if a rewrite is not valid)
+	forwarding    *forwardingPolicy                 //How the headers of the requests are changed when they are forwarded
+	httpClient    *http.Client                      //The client which forwards the requests, its connections to the targets are reused
 }
 
 // Creates a new BlueberryHandlerStructure
-func NewBlueberryHTTPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, forwardServerUrl string, checkers []code.IValidator, rules []rules.Rule, apiWsConn *websocket.APIWebSocketConnection) *BlueberryHTTPHandler {
-	return &BlueberryHTTPHandler{logger: logger, apiBaseURL: apiBaseURL, configuration: configuration, forwardServerUrl: forwardServerUrl, checkers: checkers, rules: rules, apiWsConn: apiWsConn}
+func NewBlueberryHTTPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, service *config.BackendServices, upstreams *upstream.Pool, checkers []code.IValidator, ruleStore *rules.RuleStore, apiWsConn *websocket.APIWebSocketConnection) *BlueberryHTTPHandler {
+	//Compile the path rewrites of the service
+	var rewrites []*config.PathRewrite
+	if service != nil {
+		rewrites = service.Rewrites
+	}
+	targetMapper, err := newRequestTargetMapper(rewrites)
+	if err != nil {
+		logger.Error("The requests cannot be forwarded,", err.Error())
+	}
+
+	//Create a client which will not follow rediects, the transport is shared by all the requests of the service
+	httpClient := &http.Client{
+		Transport: newUpstreamTransport(service),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &BlueberryHTTPHandler{logger: logger, apiBaseURL: apiBaseURL, configuration: configuration, service: service, upstreams: upstreams, checkers: checkers, ruleStore: ruleStore, apiWsConn: apiWsConn, targetMapper: targetMapper, forwarding: newForwardingPolicy(service), httpClient: httpClient}
 }
 
 // Forwards the request to the target server
-func (bHandler *BlueberryHTTPHandler) forwardRequest(req *http.Request) (*http.Response, error) {
-	// we need to buffer the body if we want to read it here and send it
-	// in the request.
-	body, err := io.ReadAll(req.Body)
+// The body of the request is streamed, the inspection window is sent first followed by the rest of the body
+// Only the inspection window of the response is read, the rest of the response body is streamed to the client
+// The outcome of the request is reported to the pool for the passive health checks and the circuit breaker of the target
+// The request is canceled if the client closes the connection, which is not counted as a failure of the target
+// @param req - the request received from the client
+// @param target - the upstream target selected for the request
+// @param requestBody - the inspection window of the request body
+func (bHandler *BlueberryHTTPHandler) forwardRequest(req *http.Request, target *upstream.Target, requestBody *bodyWindow) (*http.Response, *bodyWindow, *rules.UpstreamTimings, *upstreamError) {
+	//Keep the path and the query of the request, joined with the base path of the target
+	if bHandler.targetMapper == nil {
+		requestBody.close()
+		return nil, nil, nil, &upstreamError{target: target.URL.String(), reason: upstreamReasonConnectionError, statusCode: http.StatusBadGateway, message: "could not map the request to the target web server url " + target.URL.String()}
+	}
+	upstreamURL := bHandler.targetMapper.upstreamURL(target.URL, req)
+	bHandler.logger.Debug("Forwarding request", req.URL.RequestURI(), "to", upstreamURL.String())
+
+	proxyReq, err := http.NewRequestWithContext(req.Context(), req.Method, upstreamURL.String(), nil)
 	if err != nil {
-		return nil, errors.New("could not send the request to the target web server, " + err.Error())
+		requestBody.close()
+		return nil, nil, nil, newUpstreamError(target, "could not create the new request to forward to target web server", err)
+	}
+	//The length of the body is the one announced by the client, the body is chunked if the length is not known
+	proxyReq.Body = requestBody.stream()
+	if proxyReq.Body != http.NoBody {
+		proxyReq.ContentLength = req.ContentLength
+		if proxyReq.ContentLength <= 0 {
+			proxyReq.ContentLength = -1
+		}
 	}
 
-	// you can reassign the body if you need to parse it as multipart
-	req.Body = io.NopCloser(bytes.NewReader(body))
+	//Measure the time until the first byte of the response is received
+	timings := &rules.UpstreamTimings{}
+	var startTime time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			timings.TimeToFirstByte = time.Since(startTime)
+		},
+	}
+	proxyReq = proxyReq.WithContext(httptrace.WithClientTrace(proxyReq.Context(), trace))
+
+	//Set the headers following the forwarding policy of the service
+	bHandler.forwarding.setRequestHeaders(req, proxyReq)
+	//The trailers of the request are known after the body was read, they are sent after the chunked body
+	//The trailers are filled by the server when the rest of the body is streamed, so the map of the request is shared
+	if len(req.Trailer) > 0 {
+		proxyReq.Trailer = req.Trailer
+		proxyReq.ContentLength = -1
+		proxyReq.TransferEncoding = []string{"chunked"}
+	}
 
-	proxyReq, err := http.NewRequest(req.Method, bHandler.forwardServerUrl, bytes.NewReader(body))
+	startTime = time.Now()
+	resp, err := bHandler.httpClient.Do(proxyReq)
 	if err != nil {
-		return nil, errors.New("could not create the new request to forward to target web server")
+		if req.Context().Err() == nil {
+			bHandler.upstreams.ReportFailure(target)
+		}
+		return nil, nil, nil, newUpstreamError(target, "could not send the request to the target web server", err)
 	}
-
-	proxyReq.Header = make(http.Header)
-	for h, val := range req.Header {
-		proxyReq.Header[h] = val
+	//The gateway errors of the target count as failures, like the connection errors
+	if isGatewayError(resp.StatusCode) {
+		bHandler.upstreams.ReportFailure(target)
+	} else {
+		bHandler.upstreams.ReportSuccess(target)
 	}
 
-	//Create a client which will not follow rediects
-	httpClient := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+	//The event streams are sent to the client as they are received, so they are not inspected
+	if isEventStream(resp) {
+		timings.ResponseTime = time.Since(startTime)
+		bHandler.logger.Debug("Forward request, response status code", resp.StatusCode, "is an event stream, the body is not inspected")
+		responseBody := newStreamingBodyWindow(resp.Body)
+		resp.Body = http.NoBody
+		return resp, responseBody, timings, nil
 	}
 
-	resp, err := httpClient.Do(proxyReq)
+	//Read the inspection window of the response, the response time includes the body only if the whole body fits in the window
+	responseBody, err := readBodyWindow(resp.Body, bHandler.service.BodyInspectionLimit)
 	if err != nil {
-		return nil, errors.New("could not send the request to the target web server, " + err.Error())
+		if req.Context().Err() == nil {
+			bHandler.upstreams.ReportFailure(target)
+		}
+		return nil, nil, nil, newUpstreamError(target, "could not read the response from the target web server", err)
 	}
+	timings.ResponseTime = time.Since(startTime)
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody.inspected(bHandler.service.BodyLimitPolicy)))
 
-	bHandler.logger.Debug("Forward request, response status code", resp.StatusCode)
+	bHandler.logger.Debug("Forward request, response status code", resp.StatusCode, "in", timings.ResponseTime)
 
-	return resp, nil
+	return resp, responseBody, timings, nil
 }
 
-// Forwards the response back to the client
-func (bHandler *BlueberryHTTPHandler) forwardResponse(rw http.ResponseWriter, response *http.Response) {
-	//Send the headers
-	for name, values := range response.Header {
-		val := ""
-		for _, value := range values {
-			val += value
-			if len(values) > 1 {
-				val += ";"
+// Forwards the request to a target of the pool, the idempotent requests are sent again after a failure
+// A request is retried only if the whole body was read into the inspection window, since the streamed body cannot be sent twice
+// Every attempt selects a target, so a retry can be sent to another target, the failed attempts are added to the log data
+// @param r - the request received from the client
+// @param clientIP - the IP address of the client
+// @param requestBody - the inspection window of the request body
+// @param logData - the log data of the request
+// Returns the response and the target which sent it, the target should be released after the response was sent
+func (bHandler *BlueberryHTTPHandler) forwardWithRetries(r *http.Request, clientIP string, requestBody *bodyWindow, logData *models.LogData) (*http.Response, *bodyWindow, *rules.UpstreamTimings, *upstream.Target, *upstreamError) {
+	retries := int64(0)
+	if isIdempotentMethod(r.Method) && !requestBody.exceeded {
+		retries = bHandler.service.Retries
+	}
+
+	var lastFailure *upstreamError
+	for attempt := int64(0); ; attempt++ {
+		target, err := bHandler.upstreams.Select(clientIP)
+		if err != nil {
+			//If the previous attempt failed the retry is not sent, the client gets the failure of the previous attempt
+			if lastFailure != nil {
+				logData.UpstreamFailures[len(logData.UpstreamFailures)-1].Retried = false
+				return nil, nil, nil, nil, lastFailure
 			}
+			failure := newUpstreamError(nil, "could not select a target of service "+bHandler.service.Name, err)
+			bHandler.addUpstreamFailure(logData, failure, false)
+			return nil, nil, nil, nil, failure
+		}
+		logData.Upstream = target.URL.String()
+		logData.UpstreamAttempts = attempt + 1
+		retry := attempt < retries
+
+		response, responseBody, timings, failure := bHandler.forwardRequest(r, target, requestBody)
+		if failure == nil && (!retry || !isGatewayError(response.StatusCode)) {
+			return response, responseBody, timings, target, nil
+		}
+		bHandler.upstreams.Release(target)
+
+		//The gateway errors of the target are retried, the response is discarded
+		if failure == nil {
+			responseBody.close()
+			failure = &upstreamError{target: target.URL.String(), reason: upstreamReasonStatus, statusCode: response.StatusCode, message: "the target web server responded with " + response.Status}
+		}
+		//The request is not retried if the client closed the connection
+		retry = retry && failure.reason != upstreamReasonClientClosed
+		bHandler.addUpstreamFailure(logData, failure, retry)
+		if !retry {
+			return nil, nil, nil, nil, failure
 		}
-		rw.Header().Set(name, val)
+		lastFailure = failure
+
+		//Wait before the retry, the backoff doubles with every retry
+		backoff := retryBackoff(bHandler.service.RetryBackoff, attempt)
+		bHandler.logger.Warning("Attempt", attempt+1, "to forward", r.Method, "request on", r.URL.Path, "failed,", failure.Error()+", retrying in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-r.Context().Done():
+			return nil, nil, nil, nil, newUpstreamError(nil, "the client closed the connection before the retry", r.Context().Err())
+		}
+	}
+}
+
+// Adds a failed attempt to forward the request to the log data
+// @param logData - the log data of the request
+// @param failure - the failure of the attempt
+// @param retried - if the request was sent again after the failure
+func (bHandler *BlueberryHTTPHandler) addUpstreamFailure(logData *models.LogData, failure *upstreamError, retried bool) {
+	logData.UpstreamFailures = append(logData.UpstreamFailures, &models.UpstreamFailureData{Upstream: failure.target, Reason: failure.reason, Message: failure.message, StatusCode: int64(failure.statusCode), Retried: retried})
+}
+
+// Sends the error page of the failure back to the client and adds it to the log data
+// @param rw - the response writer of the client
+// @param failure - the failure of the last attempt to forward the request
+// @param logData - the log data of the request
+func (bHandler *BlueberryHTTPHandler) sendUpstreamError(rw http.ResponseWriter, failure *upstreamError, logData *models.LogData) {
+	page := errorPage(bHandler.service, failure.statusCode)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(failure.statusCode)
+	rw.Write([]byte(page))
+
+	encodedPage, err := utils.GetEncodedHTTPMessage(failure.statusCode, page)
+	if err != nil {
+		bHandler.logger.Warning("Failed to get base64 encoded response", err.Error(), "will default to empty response")
+	} else {
+		logData.Response = encodedPage
 	}
+}
+
+// Forwards the response back to the client
+// The body is streamed to the client, the inspection window is sent first followed by the rest of the body
+// @param rw - the response writer of the client
+// @param response - the response received from the service
+// @param responseBody - the inspection window of the response body
+func (bHandler *BlueberryHTTPHandler) forwardResponse(rw http.ResponseWriter, response *http.Response, responseBody *bodyWindow) {
+	//Send the headers, every value of the multi-value headers (like Set-Cookie) is sent separately
+	setResponseHeaders(rw.Header(), response)
 	//Send the status code
 	bHandler.logger.Debug(response.Status)
 	rw.WriteHeader(response.StatusCode)
 
-	//Send the body
-	body, err := io.ReadAll(response.Body)
-	//agent.logger.Debug("Body:", body)
-
-	if err != nil {
-		rw.Write([]byte("error"))
+	//Send the body, the status code was already sent so the connection is dropped if the body cannot be streamed
+	body := responseBody.stream()
+	defer body.Close()
+	if err := streamBody(rw, body, time.Duration(bHandler.service.StreamWriteTimeout)*time.Second); err != nil {
+		bHandler.logger.Error("Could not stream the response body to the client,", err.Error())
 		return
 	}
-	rw.Write(body)
+
+	//Send the trailers announced in the headers, they are known since the whole body was read
+	for name, values := range response.Trailer {
+		rw.Header()[name] = values
+	}
 }
 
 // Upgrader for the websocket
@@ -121,9 +280,12 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 			bHandler.logger,
 			bHandler.apiBaseURL,
 			bHandler.configuration,
-			bHandler.forwardServerUrl,
+			bHandler.service,
+			bHandler.upstreams,
+			bHandler.targetMapper,
+			bHandler.forwarding,
 			bHandler.checkers,
-			bHandler.rules,
+			bHandler.ruleStore,
 			bHandler.apiWsConn,
 		)
 
@@ -146,29 +308,68 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 	//Log the endpoint where the request was made
 	bHandler.logger.Info("Received", r.Method, "request on", r.URL.Path)
 
+	//Buffer only the inspection window of the body, the rest of the body is streamed to the service
+	//The client can send the body slowly, as long as it sends a chunk before the read timeout of the service
+	requestBody, err := readBodyWindow(newIdleTimeoutReader(r.Body, rw, time.Duration(bHandler.service.StreamReadTimeout)*time.Second), bHandler.service.BodyInspectionLimit)
+	if err != nil {
+		bHandler.logger.Error("Could not read the body of the request,", err.Error())
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logData.RequestBodyTruncated = requestBody.exceeded
+
+	//The requests with a body larger than the inspection window are dropped if the policy of the service is block
+	if requestBody.exceeded && bHandler.service.BodyLimitPolicy == bodyLimitBlock {
+		requestBody.close()
+		bHandler.logger.Info("Dropped", r.Method, "request on", r.URL.Path, "the body is larger than the inspection limit of", bHandler.service.BodyInspectionLimit, "bytes")
+		r.Body = io.NopCloser(bytes.NewReader(requestBody.inspected(bHandler.service.BodyLimitPolicy)))
+		if b64Req, err := utils.ConvertRequestToB64(r); err == nil {
+			logData.Request = b64Req
+		}
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		logData.Verdict = "drop"
+		cClient.SendLog(logData)
+		return
+	}
+
+	//The rules and the log see only the inspection window (or an empty body if the policy of the service is pass)
+	r.Body = io.NopCloser(bytes.NewReader(requestBody.inspected(bHandler.service.BodyLimitPolicy)))
+
+	//Get the current rule set, the request and the response are checked with the same rule set even if the rules are reloaded meanwhile
+	ruleSet := bHandler.ruleStore.ServiceRuleSet(bHandler.service)
+
 	//Create the rule runner
-	ruleRunner := rules.NewRuleRunner(bHandler.logger, bHandler.rules, bHandler.apiWsConn, bHandler.configuration)
+	ruleRunner := rules.NewRuleRunner(bHandler.logger, ruleSet, bHandler.apiWsConn, bHandler.configuration)
 
 	//Run all the rules on the request
 	startTime := time.Now()
 	requestRuleFindings, _ := ruleRunner.RunRulesOnRequest(r)
 	endTime := time.Now()
 
-	bHandler.logger.Debug("Applied", len(bHandler.rules), "rules on request in", float64(endTime.UnixNano()-startTime.UnixNano())/float64(1000000), "ms")
+	bHandler.logger.Debug("Applied", ruleSet.Count(), "rules on request in", float64(endTime.UnixNano()-startTime.UnixNano())/float64(1000000), "ms")
 
 	//Log the request rule findings
 	bHandler.logger.Debug("Request rule findings", requestRuleFindings)
 
 	//Add the request rule findings to the log data
 	logData.RequestFindings = requestRuleFindings
+	//Add the findings suppressed by the allow rules to the log data for auditing
+	logData.Suppressions = ruleRunner.Suppressions()
+	logData.AllowedBy = ruleRunner.AllowedBy()
+	logData.RulesBudgetExceeded = ruleRunner.BudgetExceeded()
 
 	//Get the verdict based on the findings
-	verdict := rules.GetVerdictBasedOnFindings(bHandler.rules, bHandler.configuration.RuleConfig.DefaultAction, requestRuleFindings)
+	verdict, requestScore, requestContributions := rules.GetServiceVerdict(ruleSet.Rules(), bHandler.service.RuleConfig.DefaultAction, bHandler.service, "ingress", requestRuleFindings, ruleRunner.BudgetExceeded())
+
+	//Add the anomaly score of the request to the log data
+	logData.AnomalyScore = requestScore
+	logData.AnomalyScoreContributions = requestContributions
 
 	//Add the request findings and the request raw dump
 	b64Req, err := utils.ConvertRequestToB64(r)
 	if err != nil {
 		bHandler.logger.Error("Failed to dump request to raw data and convert to base64", err.Error())
+		requestBody.close()
 		rw.WriteHeader(http.StatusInternalServerError)
 		rw.Write([]byte("Internal error"))
 		return
@@ -178,6 +379,7 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 
 	//If the verdict is drop then send the forbidden page back to the client
 	if verdict == "drop" {
+		requestBody.close()
 		rw.WriteHeader(http.StatusForbidden)
 		rw.Write([]byte(bHandler.configuration.RuleConfig.ForbiddenHTTPMessage))
 		logData.Verdict = "drop"
@@ -191,25 +393,45 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 		return
 	}
 
-	//Forward the request to the destination web server
-	response, err := bHandler.forwardRequest(r)
-	if err != nil {
-		bHandler.logger.Error(err.Error())
-		//TO DO...Send a error message back to the client
+	//Forward the request to the destination web server, the connection to the target is released after the response was sent
+	response, responseBody, timings, target, failure := bHandler.forwardWithRetries(r, remoteIp, requestBody, &logData)
+	if failure != nil {
+		bHandler.logger.Error("Could not forward the request of", r.RemoteAddr, "to service", bHandler.service.Name+",", failure.Error())
+		requestBody.close()
+		bHandler.sendUpstreamError(rw, failure, &logData)
+		logData.Verdict = "allow"
+		cClient.SendLog(logData)
 		return
 	}
+	defer bHandler.upstreams.Release(target)
+	//The rest of the response body is not read if the response is dropped
+	defer responseBody.close()
+	logData.ResponseBodyTruncated = responseBody.exceeded
+
+	//Add the upstream timings to the log data
+	logData.UpstreamResponseTime = float64(timings.ResponseTime.Microseconds()) / 1000
+	logData.UpstreamTimeToFirstByte = float64(timings.TimeToFirstByte.Microseconds()) / 1000
 
 	//Run the rules on the response
-	responseRuleFindings, _ := ruleRunner.RunRulesOnResponse(response)
+	//The same rule runner is used so the transaction rules can correlate the response with the request matches
+	responseRuleFindings, _ := ruleRunner.RunRulesOnResponse(response, timings)
 
 	//Log the rules response findings
 	bHandler.logger.Debug("Response rule findings", responseRuleFindings)
 
 	//Add the response findings to the list response findings
 	logData.ResponseFindings = responseRuleFindings
+	//The suppressions of the response are added to the ones of the request by the rule runner
+	logData.Suppressions = ruleRunner.Suppressions()
+	//The rule runner keeps the flag set if the request already exceeded the time budget
+	logData.RulesBudgetExceeded = ruleRunner.BudgetExceeded()
 
 	//Get the verdict for the response
-	verdictResponse := rules.GetVerdictBasedOnFindings(bHandler.rules, bHandler.configuration.RuleConfig.DefaultAction, responseRuleFindings)
+	verdictResponse, responseScore, responseContributions := rules.GetServiceVerdict(ruleSet.Rules(), bHandler.service.RuleConfig.DefaultAction, bHandler.service, "egress", responseRuleFindings, ruleRunner.BudgetExceeded())
+
+	//Add the anomaly score of the response to the log data
+	logData.AnomalyScore += responseScore
+	logData.AnomalyScoreContributions = append(logData.AnomalyScoreContributions, responseContributions...)
 
 	//Add the response to the log data
 	b64Resp, err := utils.ConvertResponseToB64(response)
@@ -222,6 +444,12 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 
 	logData.Response = b64Resp
 
+	//The responses with a body larger than the inspection window are dropped if the policy of the service is block
+	if responseBody.exceeded && bHandler.service.BodyLimitPolicy == bodyLimitBlock {
+		bHandler.logger.Info("Dropped the response of", r.Method, "request on", r.URL.Path, "the body is larger than the inspection limit of", bHandler.service.BodyInspectionLimit, "bytes")
+		verdictResponse = "drop"
+	}
+
 	//If the verdict is drop then send the forbidden http message
 	if verdictResponse == "drop" {
 		rw.WriteHeader(http.StatusForbidden)
@@ -237,5 +465,5 @@ func (bHandler *BlueberryHTTPHandler) HandleRequest(rw http.ResponseWriter, r *h
 	cClient.SendLog(logData)
 
 	//Send the response from the web server back to the client
-	bHandler.forwardResponse(rw, response)
+	bHandler.forwardResponse(rw, response, responseBody)
 }
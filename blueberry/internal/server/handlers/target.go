@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"blueberry/internal/config"
+)
+
+// Holds a compiled rewrite of the path of the requests
+type pathRewrite struct {
+	stripPrefix string         //The prefix removed from the path (empty if not specified)
+	regex       *regexp.Regexp //The regex matched on the path (nil if not specified)
+	replacement string         //The replacement of the regex matches
+}
+
+// Maps the request target of the incoming requests to the URL of the upstream target of the service
+// The path and the query of the request are kept, the path is rewritten and joined with the base path of the target URL
+type requestTargetMapper struct {
+	rewrites []pathRewrite //The rewrites applied in order on the path
+}
+
+// Creates a new request target mapper for the service
+// @param rewrites - the path rewrites of the service
+// Returns the mapper or an error if a rewrite regex is not valid
+func newRequestTargetMapper(rewrites []*config.PathRewrite) (*requestTargetMapper, error) {
+	var err error
+	mapper := &requestTargetMapper{rewrites: make([]pathRewrite, 0, len(rewrites))}
+	for _, rewrite := range rewrites {
+		compiled := pathRewrite{stripPrefix: rewrite.StripPrefix, replacement: rewrite.Replacement}
+		if rewrite.Regex != "" {
+			compiled.regex, err = regexp.Compile(rewrite.Regex)
+			if err != nil {
+				return nil, errors.New("could not compile the rewrite regex " + rewrite.Regex + ", " + err.Error())
+			}
+		}
+		mapper.rewrites = append(mapper.rewrites, compiled)
+	}
+	return mapper, nil
+}
+
+// Applies the rewrites on the escaped path of the request
+// The path always starts with / after the rewrites
+func (rtm *requestTargetMapper) rewritePath(path string) string {
+	for _, rewrite := range rtm.rewrites {
+		//The prefix is stripped only on a segment boundary, so /api does not strip /apiv2
+		if rewrite.stripPrefix != "" {
+			prefix := strings.TrimSuffix(rewrite.stripPrefix, "/")
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				path = strings.TrimPrefix(path, prefix)
+			}
+		}
+		if rewrite.regex != nil {
+			path = rewrite.regex.ReplaceAllString(path, rewrite.replacement)
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Joins the base path of the remote URL with the path of the request, with exactly one slash between them
+func joinURLPath(basePath string, path string) string {
+	if basePath == "" || basePath == "/" {
+		return path
+	}
+	return strings.TrimSuffix(basePath, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Gets the URL the request should be forwarded to
+// The query of the target URL (if any) is placed before the query of the request
+// @param remoteURL - the URL of the upstream target (can contain a base path and a query)
+// @param req - the request received from the client
+func (rtm *requestTargetMapper) upstreamURL(remoteURL *url.URL, req *http.Request) *url.URL {
+	escapedPath := joinURLPath(remoteURL.EscapedPath(), rtm.rewritePath(req.URL.EscapedPath()))
+
+	upstreamURL := &url.URL{Scheme: remoteURL.Scheme, User: remoteURL.User, Host: remoteURL.Host, RawPath: escapedPath}
+	//The path is kept escaped as it was received, so the encoded slashes are not decoded
+	if path, err := url.PathUnescape(escapedPath); err == nil {
+		upstreamURL.Path = path
+	} else {
+		upstreamURL.Path = escapedPath
+		upstreamURL.RawPath = ""
+	}
+
+	switch {
+	case remoteURL.RawQuery == "":
+		upstreamURL.RawQuery = req.URL.RawQuery
+	case req.URL.RawQuery == "":
+		upstreamURL.RawQuery = remoteURL.RawQuery
+	default:
+		upstreamURL.RawQuery = remoteURL.RawQuery + "&" + req.URL.RawQuery
+	}
+	return upstreamURL
+}
+
+// Gets the websocket scheme of the target, the targets served over TLS are dialed with wss
+func websocketScheme(scheme string) string {
+	if strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "wss") {
+		return "wss"
+	}
+	return "ws"
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"blueberry/internal/config"
+)
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []*config.PathRewrite
+		path     string
+		expected string
+	}{
+		{name: "no rewrites", path: "/api/users", expected: "/api/users"},
+		{name: "strip prefix", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}}, path: "/api/users", expected: "/users"},
+		{name: "strip prefix with a slash", rewrites: []*config.PathRewrite{{StripPrefix: "/api/"}}, path: "/api/users", expected: "/users"},
+		{name: "strip the whole path", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}}, path: "/api", expected: "/"},
+		{name: "prefix inside a segment", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}}, path: "/apiv2/users", expected: "/apiv2/users"},
+		{name: "regex", rewrites: []*config.PathRewrite{{Regex: `^/v1/(.*)$`, Replacement: "/v2/$1"}}, path: "/v1/users", expected: "/v2/users"},
+		{name: "regex without a leading slash", rewrites: []*config.PathRewrite{{Regex: `^/old/`, Replacement: ""}}, path: "/old/users", expected: "/users"},
+		{name: "rewrites in order", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}, {Regex: `^/v1/(.*)$`, Replacement: "/v2/$1"}}, path: "/api/v1/users", expected: "/v2/users"},
+		{name: "escaped path", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}}, path: "/api/a%2Fb", expected: "/a%2Fb"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapper, err := newRequestTargetMapper(test.rewrites)
+			if err != nil {
+				t.Fatalf("newRequestTargetMapper() error = %v", err)
+			}
+			if path := mapper.rewritePath(test.path); path != test.expected {
+				t.Errorf("rewritePath(%q) = %q, expected %q", test.path, path, test.expected)
+			}
+		})
+	}
+}
+
+func TestJoinURLPath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		path     string
+		expected string
+	}{
+		{basePath: "", path: "/users", expected: "/users"},
+		{basePath: "/", path: "/users", expected: "/users"},
+		{basePath: "/app", path: "/users", expected: "/app/users"},
+		{basePath: "/app/", path: "/users", expected: "/app/users"},
+		{basePath: "/app", path: "/", expected: "/app/"},
+	}
+
+	for _, test := range tests {
+		if path := joinURLPath(test.basePath, test.path); path != test.expected {
+			t.Errorf("joinURLPath(%q, %q) = %q, expected %q", test.basePath, test.path, path, test.expected)
+		}
+	}
+}
+
+func TestUpstreamURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteURL  string
+		rewrites   []*config.PathRewrite
+		requestURI string
+		expected   string
+	}{
+		{name: "path and query", remoteURL: "http://127.0.0.1:8081", requestURI: "/search?q=a&page=2", expected: "http://127.0.0.1:8081/search?q=a&page=2"},
+		{name: "base path", remoteURL: "http://127.0.0.1:8081/app/", requestURI: "/search?q=a", expected: "http://127.0.0.1:8081/app/search?q=a"},
+		{name: "query of the target", remoteURL: "http://127.0.0.1:8081/app?tenant=1", requestURI: "/search", expected: "http://127.0.0.1:8081/app/search?tenant=1"},
+		{name: "both queries", remoteURL: "http://127.0.0.1:8081/app?tenant=1", requestURI: "/search?q=a", expected: "http://127.0.0.1:8081/app/search?tenant=1&q=a"},
+		{name: "encoded slash", remoteURL: "http://127.0.0.1:8081", requestURI: "/files/a%2Fb", expected: "http://127.0.0.1:8081/files/a%2Fb"},
+		{name: "rewritten path", remoteURL: "https://10.0.0.1/app", rewrites: []*config.PathRewrite{{StripPrefix: "/api"}}, requestURI: "/api/users?id=1", expected: "https://10.0.0.1/app/users?id=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapper, err := newRequestTargetMapper(test.rewrites)
+			if err != nil {
+				t.Fatalf("newRequestTargetMapper() error = %v", err)
+			}
+			remoteURL, err := url.Parse(test.remoteURL)
+			if err != nil {
+				t.Fatalf("url.Parse() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, test.requestURI, nil)
+			if upstreamURL := mapper.upstreamURL(remoteURL, req).String(); upstreamURL != test.expected {
+				t.Errorf("upstreamURL() = %q, expected %q", upstreamURL, test.expected)
+			}
+		})
+	}
+}
+
+func TestWebsocketScheme(t *testing.T) {
+	for scheme, expected := range map[string]string{"http": "ws", "https": "wss", "HTTPS": "wss", "ws": "ws", "wss": "wss"} {
+		if result := websocketScheme(scheme); result != expected {
+			t.Errorf("websocketScheme(%q) = %q, expected %q", scheme, result, expected)
+		}
+	}
+}
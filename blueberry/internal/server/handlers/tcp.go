@@ -7,10 +7,10 @@ import (
 	rules "blueberry/internal/detection/rules"
 	"blueberry/internal/logging"
 	"blueberry/internal/models"
+	"blueberry/internal/upstream"
 	"blueberry/internal/utils"
 	"blueberry/internal/websocket"
 	"net"
-	"net/url"
 	"sync"
 	"time"
 
@@ -24,68 +24,67 @@ const (
 
 // Structure which holds all the necessary variables for TCP handler
 type BlueberryTCPHandler struct {
-	logger           logging.ILogger
-	apiBaseURL       string                            //The API base URL
-	configuration    config.Configuration              //The configuration structure
-	forwardServerUrl string                            //The URL the requests should be forwarded to
-	checkers         []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
-	rules            []rules.Rule                      //The list of rules which will try to find anomalies in the requests and the responses
-	apiWsConn        *websocket.APIWebSocketConnection //The WS connection to the API
+	logger        logging.ILogger
+	apiBaseURL    string                            //The API base URL
+	configuration config.Configuration              //The configuration structure
+	service       *config.BackendServices           //The service the handler proxies the traffic for
+	upstreams     *upstream.Pool                    //The pool of targets the connections are balanced between
+	checkers      []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
+	ruleStore     *rules.RuleStore                  //The store which holds the rules that will try to find anomalies in the requests and the responses
+	apiWsConn     *websocket.APIWebSocketConnection //The WS connection to the API
 	//TODO add global mutex for api websocket connection
-	targetTcpServer net.Conn
-	targetTcpMutex  sync.Mutex
 }
 
 // Structure which holds information about the client connection
 type ClientConnection struct {
 	clientSocket            net.Conn   //The socket to interact with the client
+	targetSocket            net.Conn   //The socket to interact with the upstream target selected for the client
+	target                  string     //The URL of the upstream target selected for the client
 	clientSocketMutex       sync.Mutex //The mutex for the client connection socket
 	streamUUID              string     //The UUID of the stream so that the client connection can be identified from logs
 	currentStreamIndex      int64      //The current index to be used by request/response traffic
 	currentStreamIndexMutex sync.Mutex //The mutex for the current stream index (prevent race conditions)
 }
 
-func NewBlueberryTCPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, forwardServerURL string, checkers []code.IValidator, rules []rules.Rule, apiWsConn *websocket.APIWebSocketConnection) *BlueberryTCPHandler {
+func NewBlueberryTCPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, service *config.BackendServices, upstreams *upstream.Pool, checkers []code.IValidator, ruleStore *rules.RuleStore, apiWsConn *websocket.APIWebSocketConnection) *BlueberryTCPHandler {
 	return &BlueberryTCPHandler{
-		logger:           logger,
-		apiBaseURL:       apiBaseURL,
-		configuration:    configuration,
-		forwardServerUrl: forwardServerURL,
-		checkers:         checkers,
-		rules:            rules,
-		apiWsConn:        apiWsConn,
+		logger:        logger,
+		apiBaseURL:    apiBaseURL,
+		configuration: configuration,
+		service:       service,
+		upstreams:     upstreams,
+		checkers:      checkers,
+		ruleStore:     ruleStore,
+		apiWsConn:     apiWsConn,
 	}
 }
 
-func (bth *BlueberryTCPHandler) ConnectToTargetServer() error {
-	//Parse the URL
-	url, err := url.Parse(bth.forwardServerUrl)
-	if err != nil {
-		bth.logger.Error("Failed to parse forward server url", err.Error())
-		return err
-	}
-
+// Connects to the upstream target selected for the client
+// The outcome of the connection is reported to the pool for the passive health checks of the target
+// @param target - the upstream target selected for the client
+// Returns the connection to the target or an error if the target could not be reached
+func (bth *BlueberryTCPHandler) ConnectToTargetServer(target *upstream.Target) (net.Conn, error) {
 	//Get the host and the port from the url
-	serverAddress := url.Host
+	serverAddress := target.Host()
 
 	//Resolve TCP address of the target server
 	tcpAddr, err := net.ResolveTCPAddr("tcp", serverAddress)
 	if err != nil {
 		bth.logger.Error("Failed to resolve address for forward server", err.Error())
-		return err
+		bth.upstreams.ReportFailure(target)
+		return nil, err
 	}
 
 	//Dial the server
-	targetConn, err := net.DialTCP(url.Scheme, nil, tcpAddr)
+	targetConn, err := net.DialTCP(target.URL.Scheme, nil, tcpAddr)
 	if err != nil {
 		bth.logger.Error("Failed to dial target tcp server", err.Error())
-		return err
+		bth.upstreams.ReportFailure(target)
+		return nil, err
 	}
+	bth.upstreams.ReportSuccess(target)
 
-	//Save the target connection in the handler struct
-	bth.targetTcpServer = targetConn
-
-	return nil
+	return targetConn, nil
 }
 
 // This function will proxy the traffic from client to target server
@@ -97,9 +96,6 @@ func (bth *BlueberryTCPHandler) ProxyRequests(clientConn *ClientConnection, errc
 
 	//TODO...Add timeout to read
 
-	//Initialize the rules runner
-	ruleRunner := rules.NewRuleRunner(bth.logger, bth.rules, bth.apiWsConn, bth.configuration)
-
 	//Infinite loop
 	for {
 		//Read from the client connection max DefaultBufferSize bytes
@@ -115,6 +111,10 @@ func (bth *BlueberryTCPHandler) ProxyRequests(clientConn *ClientConnection, errc
 		buf = buf[:readBytes]
 		bth.logger.Debug("Received tcp message from", clientConn.clientSocket.RemoteAddr().String(), "content", string(buf))
 
+		//Get the current rule set, every message is checked with the latest rules
+		ruleSet := bth.ruleStore.ServiceRuleSet(bth.service)
+		ruleRunner := rules.NewRuleRunner(bth.logger, ruleSet, bth.apiWsConn, bth.configuration)
+
 		//Apply the tcp request rules
 		findings, err := ruleRunner.ApplyRulesOnTCPMessage("ingress", buf)
 		if err != nil {
@@ -123,7 +123,7 @@ func (bth *BlueberryTCPHandler) ProxyRequests(clientConn *ClientConnection, errc
 		bth.logger.Debug("Ingress findings", findings)
 
 		//Get the verdict based on findings
-		verdict := rules.GetVerdictBasedOnFindings(bth.rules, bth.configuration.RuleConfig.DefaultAction, findings)
+		verdict, score, contributions := rules.GetServiceVerdict(ruleSet.Rules(), bth.service.RuleConfig.DefaultAction, bth.service, "ingress", findings, ruleRunner.BudgetExceeded())
 		if verdict == "drop" {
 			//Send the drop message for tcp connection
 			_, err := clientConn.clientSocket.Write([]byte(bth.configuration.RuleConfig.ForbiddenTCPMessage))
@@ -136,14 +136,20 @@ func (bth *BlueberryTCPHandler) ProxyRequests(clientConn *ClientConnection, errc
 		//Initialize the log data
 		remoteIp, _, _ := net.SplitHostPort(clientConn.clientSocket.RemoteAddr().String())
 		logData := models.LogData{
-			AgentId:         bth.configuration.UUID,
-			RemoteIP:        remoteIp,
-			Timestamp:       time.Now().Unix(),
-			StreamUUID:      clientConn.streamUUID,
-			RequestFindings: findings,
-			Verdict:         verdict,
-			Type:            "tcp",
-			Direction:       "ingress",
+			AgentId:                   bth.configuration.UUID,
+			RemoteIP:                  remoteIp,
+			Timestamp:                 time.Now().Unix(),
+			StreamUUID:                clientConn.streamUUID,
+			RequestFindings:           findings,
+			Verdict:                   verdict,
+			Type:                      "tcp",
+			Direction:                 "ingress",
+			AnomalyScore:              score,
+			AnomalyScoreContributions: contributions,
+			Suppressions:              ruleRunner.Suppressions(),
+			AllowedBy:                 ruleRunner.AllowedBy(),
+			RulesBudgetExceeded:       ruleRunner.BudgetExceeded(),
+			Upstream:                  clientConn.target,
 		}
 
 		//Convert the buf with ingress data to base64 and add to log data Request field
@@ -170,7 +176,7 @@ func (bth *BlueberryTCPHandler) ProxyRequests(clientConn *ClientConnection, errc
 		}
 
 		//Write the buffer to the target server
-		_, err = bth.targetTcpServer.Write(buf)
+		_, err = clientConn.targetSocket.Write(buf)
 		if err != nil {
 			bth.logger.Error("Failed to write message to target server", clientConn.clientSocket.RemoteAddr().String(), err.Error())
 			errc <- err
@@ -190,13 +196,10 @@ func (bth *BlueberryTCPHandler) ProxyResponses(clientConn *ClientConnection, err
 
 	//TODO...Add timeout to read
 
-	//Initialize the rules runner
-	ruleRunner := rules.NewRuleRunner(bth.logger, bth.rules, bth.apiWsConn, bth.configuration)
-
 	//Infinite loop
 	for {
 		//Read from the client connection max DefaultBufferSize bytes
-		readBytes, err := bth.targetTcpServer.Read(buf)
+		readBytes, err := clientConn.targetSocket.Read(buf)
 		if err != nil {
 			//Log the error
 			bth.logger.Error("Failed to read message from target server", err.Error())
@@ -208,6 +211,10 @@ func (bth *BlueberryTCPHandler) ProxyResponses(clientConn *ClientConnection, err
 		buf = buf[:readBytes]
 		bth.logger.Debug("Received tcp message from target server, content", string(buf))
 
+		//Get the current rule set, every message is checked with the latest rules
+		ruleSet := bth.ruleStore.ServiceRuleSet(bth.service)
+		ruleRunner := rules.NewRuleRunner(bth.logger, ruleSet, bth.apiWsConn, bth.configuration)
+
 		//Apply the response tcp rules
 		findings, err := ruleRunner.ApplyRulesOnTCPMessage("egress", buf)
 		if err != nil {
@@ -216,7 +223,7 @@ func (bth *BlueberryTCPHandler) ProxyResponses(clientConn *ClientConnection, err
 		bth.logger.Debug("Egress findings", findings)
 
 		//Get the verdict based on findings
-		verdict := rules.GetVerdictBasedOnFindings(bth.rules, bth.configuration.RuleConfig.DefaultAction, findings)
+		verdict, score, contributions := rules.GetServiceVerdict(ruleSet.Rules(), bth.service.RuleConfig.DefaultAction, bth.service, "egress", findings, ruleRunner.BudgetExceeded())
 		if verdict == "drop" {
 			//Send the drop message for tcp connection
 			_, err := clientConn.clientSocket.Write([]byte(bth.configuration.RuleConfig.ForbiddenTCPMessage))
@@ -229,14 +236,20 @@ func (bth *BlueberryTCPHandler) ProxyResponses(clientConn *ClientConnection, err
 		//Initialize the log data
 		remoteIp, _, _ := net.SplitHostPort(clientConn.clientSocket.RemoteAddr().String())
 		logData := models.LogData{
-			AgentId:          bth.configuration.UUID,
-			RemoteIP:         remoteIp,
-			Timestamp:        time.Now().Unix(),
-			StreamUUID:       clientConn.streamUUID,
-			ResponseFindings: findings,
-			Verdict:          verdict,
-			Type:             "tcp",
-			Direction:        "egress",
+			AgentId:                   bth.configuration.UUID,
+			RemoteIP:                  remoteIp,
+			Timestamp:                 time.Now().Unix(),
+			StreamUUID:                clientConn.streamUUID,
+			ResponseFindings:          findings,
+			Verdict:                   verdict,
+			Type:                      "tcp",
+			Direction:                 "egress",
+			AnomalyScore:              score,
+			AnomalyScoreContributions: contributions,
+			Suppressions:              ruleRunner.Suppressions(),
+			AllowedBy:                 ruleRunner.AllowedBy(),
+			RulesBudgetExceeded:       ruleRunner.BudgetExceeded(),
+			Upstream:                  clientConn.target,
 		}
 
 		//Convert the buf with ingress data to base64 and add to log data Request field
@@ -276,8 +289,20 @@ func (bth *BlueberryTCPHandler) ProxyResponses(clientConn *ClientConnection, err
 
 // Handle TCP connection
 func (bth *BlueberryTCPHandler) HandleTCPConnection(conn net.Conn) {
+	//Select the target of the client, the connection to the target is released when the client connection is closed
+	remoteIp, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	target, err := bth.upstreams.Select(remoteIp)
+	if err != nil {
+		bth.logger.Error("Could not forward the connection of", conn.RemoteAddr().String(), "to service", bth.service.Name+",", err.Error())
+		if err := conn.Close(); err != nil {
+			bth.logger.Error("Failed when calling close on connection", conn.RemoteAddr().String(), err.Error())
+		}
+		return
+	}
+	defer bth.upstreams.Release(target)
+
 	//Connect to the target tcp server
-	err := bth.ConnectToTargetServer()
+	targetConn, err := bth.ConnectToTargetServer(target)
 	//Check if the connection failed
 	if err != nil {
 		//Log the error
@@ -297,7 +322,7 @@ func (bth *BlueberryTCPHandler) HandleTCPConnection(conn net.Conn) {
 
 	//Create the structure for the client connection
 	//Generate a new UUID
-	clientConn := ClientConnection{clientSocket: conn, streamUUID: uuid.New().String(), currentStreamIndex: 0}
+	clientConn := ClientConnection{clientSocket: conn, targetSocket: targetConn, target: target.URL.String(), streamUUID: uuid.New().String(), currentStreamIndex: 0}
 
 	//Proxy the traffic from the conn in the function parameters and the target connection
 	//Proxy the requests
@@ -315,7 +340,7 @@ func (bth *BlueberryTCPHandler) HandleTCPConnection(conn net.Conn) {
 	}
 
 	//Close the connection to the target server
-	err = bth.targetTcpServer.Close()
+	err = targetConn.Close()
 	if err != nil {
 		bth.logger.Error("Failed to close connection to the target server", err.Error())
 	}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/upstream"
+)
+
+// The reasons of the failures to forward a request, logged to cranberry
+const (
+	upstreamReasonTimeout         = "timeout"               //The target did not respond in time
+	upstreamReasonConnectionError = "connection_error"      //The target could not be reached or the connection failed
+	upstreamReasonStatus          = "upstream_status"       //The target responded with a gateway error status
+	upstreamReasonNoTarget        = "no_available_upstream" //All the targets are unhealthy, ejected or have the circuit open
+	upstreamReasonClientClosed    = "client_closed"         //The client closed the connection before the response was received
+)
+
+// The methods which can be sent again without changing the outcome (RFC 9110, section 9.2.2)
+var idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+
+// Holds the failure of forwarding a request to an upstream target
+type upstreamError struct {
+	target     string //The URL of the target (empty if no target was available)
+	reason     string //The reason of the failure
+	statusCode int    //The status code received from the target or sent to the client (502, 503 or 504)
+	message    string //The error message
+}
+
+// Gets the error message of the failure
+func (ue *upstreamError) Error() string {
+	return ue.message
+}
+
+// Creates the failure of forwarding a request to the target
+// The timeouts are gateway timeouts, the requests without an available target are sent service unavailable and the other errors are bad gateways
+// @param target - the target the request was sent to (can be nil)
+// @param message - the description of the failed operation
+// @param err - the error of the operation
+func newUpstreamError(target *upstream.Target, message string, err error) *upstreamError {
+	failure := &upstreamError{reason: upstreamReasonConnectionError, statusCode: http.StatusBadGateway, message: message + ", " + err.Error()}
+	if target != nil {
+		failure.target = target.URL.String()
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, upstream.ErrNoAvailableTarget):
+		failure.reason, failure.statusCode = upstreamReasonNoTarget, http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		failure.reason = upstreamReasonClientClosed
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		failure.reason, failure.statusCode = upstreamReasonTimeout, http.StatusGatewayTimeout
+	}
+	return failure
+}
+
+// Creates the transport shared by all the requests forwarded to the targets of the service
+// The connections to the targets are kept open and reused by the next requests
+// @param service - the service from the configuration
+func newUpstreamTransport(service *config.BackendServices) *http.Transport {
+	dialer := &net.Dialer{Timeout: time.Duration(service.DialTimeout) * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   time.Duration(service.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(service.ResponseHeaderTimeout) * time.Second,
+		MaxIdleConnsPerHost:   service.MaxIdleConnections,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// Checks if the method of the request is idempotent, only these requests are retried
+func isIdempotentMethod(method string) bool {
+	return slices.Contains(idempotentMethods, method)
+}
+
+// Checks if the status code is a gateway error of the target (502, 503 or 504)
+func isGatewayError(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout
+}
+
+// Gets the time waited before a retry
+// The backoff doubles with every retry and a random jitter of up to half of the backoff is added, so the retries of the clients are spread
+// @param backoff - the number of milliseconds waited before the first retry
+// @param attempt - the number of the failed attempt (starting from 0)
+func retryBackoff(backoff int64, attempt int64) time.Duration {
+	delay := time.Duration(backoff) * time.Millisecond << min(attempt, 10)
+	return delay + rand.N(delay/2+1)
+}
+
+// Gets the error page of the service for the status code
+func errorPage(service *config.BackendServices, statusCode int) string {
+	if service.ErrorPages == nil {
+		return http.StatusText(statusCode)
+	}
+	switch statusCode {
+	case http.StatusServiceUnavailable:
+		return service.ErrorPages.ServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return service.ErrorPages.GatewayTimeout
+	default:
+		return service.ErrorPages.BadGateway
+	}
+}
@@ -15,23 +15,25 @@ type BlueberryUDPHandler struct {
 	logger           logging.ILogger
 	apiBaseURL       string                            //The API base URL
 	configuration    config.Configuration              //The configuration structure
+	service          *config.BackendServices           //The service the handler proxies the traffic for
 	forwardServerUrl string                            //The URL the requests should be forwarded to
 	checkers         []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
-	rules            []rules.Rule                      //The list of rules which will try to find anomalies in the requests and the responses
+	ruleStore        *rules.RuleStore                  //The store which holds the rules that will try to find anomalies in the requests and the responses
 	apiWsConn        *websocket.APIWebSocketConnection //The WS connection to the API
 	//TODO add global mutex for api websocket connection
 	targetUdpServer net.Conn
 	targetUdpMutex  sync.Mutex
 }
 
-func NewBlueberryUDPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, forwardServerURL string, checkers []code.IValidator, rules []rules.Rule, apiWsConn *websocket.APIWebSocketConnection) *BlueberryUDPHandler {
+func NewBlueberryUDPHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, service *config.BackendServices, forwardServerURL string, checkers []code.IValidator, ruleStore *rules.RuleStore, apiWsConn *websocket.APIWebSocketConnection) *BlueberryUDPHandler {
 	return &BlueberryUDPHandler{
 		logger:           logger,
 		apiBaseURL:       apiBaseURL,
 		configuration:    configuration,
+		service:          service,
 		forwardServerUrl: forwardServerURL,
 		checkers:         checkers,
-		rules:            rules,
+		ruleStore:        ruleStore,
 		apiWsConn:        apiWsConn,
 	}
 }
@@ -5,68 +5,90 @@ import (
 	code "blueberry/internal/detection/code"
 	rules "blueberry/internal/detection/rules"
 	"blueberry/internal/logging"
+	"blueberry/internal/upstream"
 	"blueberry/internal/websocket"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"net/url"
 
 	ws_gorilla "github.com/gorilla/websocket"
 )
 
+// The headers of the websocket handshake which are set by the dialer, they are not forwarded to the target
+var websocketHandshakeHeaders = []string{"Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions"}
+
 type BlueberryWebsocketHandler struct {
-	logger           logging.ILogger
-	apiBaseURL       string                            //The API base URL
-	configuration    config.Configuration              //The configuration structure
-	forwardServerUrl string                            //The URL the requests should be forwarded to
-	checkers         []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
-	rules            []rules.Rule                      //The list of rules which will try to find anomalies in the requests and the responses
-	apiWsConn        *websocket.APIWebSocketConnection //The WS connection to the API
-	targetWsConn     *ws_gorilla.Conn                  //The websocket connection to the target server
+	logger        logging.ILogger
+	apiBaseURL    string                            //The API base URL
+	configuration config.Configuration              //The configuration structure
+	service       *config.BackendServices           //The service the handler proxies the traffic for
+	upstreams     *upstream.Pool                    //The pool of targets the connections are balanced between
+	targetMapper  *requestTargetMapper              //Maps the path and the query of the upgrade requests to the URL of the target (nil if a rewrite is not valid)
+	forwarding    *forwardingPolicy                 //How the headers of the upgrade requests are changed when they are forwarded
+	checkers      []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
+	ruleStore     *rules.RuleStore                  //The store which holds the rules that will try to find anomalies in the requests and the responses
+	apiWsConn     *websocket.APIWebSocketConnection //The WS connection to the API
+	targetWsConn  *ws_gorilla.Conn                  //The websocket connection to the target server
 }
 
-func NewBlueberryWebsocketHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, forwardServerURL string, checkers []code.IValidator, rules []rules.Rule, apiWsConn *websocket.APIWebSocketConnection) *BlueberryWebsocketHandler {
+func NewBlueberryWebsocketHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, service *config.BackendServices, upstreams *upstream.Pool, targetMapper *requestTargetMapper, forwarding *forwardingPolicy, checkers []code.IValidator, ruleStore *rules.RuleStore, apiWsConn *websocket.APIWebSocketConnection) *BlueberryWebsocketHandler {
 	return &BlueberryWebsocketHandler{
-		logger:           logger,
-		apiBaseURL:       apiBaseURL,
-		configuration:    configuration,
-		forwardServerUrl: forwardServerURL,
-		checkers:         checkers,
-		rules:            rules,
-		apiWsConn:        apiWsConn,
+		logger:        logger,
+		apiBaseURL:    apiBaseURL,
+		configuration: configuration,
+		service:       service,
+		upstreams:     upstreams,
+		targetMapper:  targetMapper,
+		forwarding:    forwarding,
+		checkers:      checkers,
+		ruleStore:     ruleStore,
+		apiWsConn:     apiWsConn,
 	}
 }
 
-func (bwsh *BlueberryWebsocketHandler) ConnectToTargetServer() error {
-	//Parse the forward server URL
-	target_url, err := url.Parse(bwsh.forwardServerUrl)
-	//Check if an error occured
-	if err != nil {
-		bwsh.logger.Error("Failed to parse target server url", err.Error())
-		return err
+// Connects to the upstream target selected for the connection
+// The path, the query and the headers of the upgrade request are forwarded like for the http requests, the scheme of the target is mapped to ws or wss
+// The outcome of the connection is reported to the pool for the passive health checks of the target
+// @param target - the upstream target selected for the connection
+// @param r - the upgrade request received from the client
+// Returns the subprotocol selected by the target (empty if none was selected) or an error if the connection failed
+func (bwsh *BlueberryWebsocketHandler) ConnectToTargetServer(target *upstream.Target, r *http.Request) (string, error) {
+	if bwsh.targetMapper == nil {
+		return "", errors.New("could not map the request to the target websocket server url " + target.URL.String())
 	}
-
 	//Create the websocket url for the backend server
-	ws_url := fmt.Sprintf("%s://%s/%s", "ws", target_url.Host, target_url.RawPath)
+	wsURL := bwsh.targetMapper.upstreamURL(target.URL, r)
+	wsURL.Scheme = websocketScheme(wsURL.Scheme)
+
+	bwsh.logger.Debug("Backend websocket URL", wsURL.String())
 
-	bwsh.logger.Debug("Backend websocket URL", ws_url)
+	//Set the headers following the forwarding policy of the service, the handshake headers are set by the dialer
+	proxyReq := &http.Request{Header: make(http.Header)}
+	bwsh.forwarding.setRequestHeaders(r, proxyReq)
+	for _, name := range websocketHandshakeHeaders {
+		proxyReq.Header.Del(name)
+	}
+	if proxyReq.Host != "" {
+		proxyReq.Header.Set("Host", proxyReq.Host)
+	}
 
 	//Connect to the websocket backend
-	backendConn, _, err := ws_gorilla.DefaultDialer.Dial(ws_url, nil)
+	backendConn, _, err := ws_gorilla.DefaultDialer.Dial(wsURL.String(), proxyReq.Header)
 	if err != nil {
 		bwsh.logger.Error("Failed to connect to backend websocket server")
-		return err
+		bwsh.upstreams.ReportFailure(target)
+		return "", err
 	}
+	bwsh.upstreams.ReportSuccess(target)
 
 	//Save the backend connection in the struct
 	bwsh.targetWsConn = backendConn
 
-	return nil
+	return backendConn.Subprotocol(), nil
 }
 
 func (bwsh *BlueberryWebsocketHandler) ProxyRequests(clientConn *ws_gorilla.Conn, errc chan error) {
-	//Create the rule runner
-	ruleRunner := rules.NewRuleRunner(bwsh.logger, bwsh.rules, bwsh.apiWsConn, bwsh.configuration)
-
 	for {
 		mt, message, err := clientConn.ReadMessage()
 		if err != nil {
@@ -75,6 +97,10 @@ func (bwsh *BlueberryWebsocketHandler) ProxyRequests(clientConn *ws_gorilla.Conn
 			return
 		}
 
+		//Get the current rule set, every message is checked with the latest rules
+		ruleSet := bwsh.ruleStore.ServiceRuleSet(bwsh.service)
+		ruleRunner := rules.NewRuleRunner(bwsh.logger, ruleSet, bwsh.apiWsConn, bwsh.configuration)
+
 		//Apply the rules on the websocket messages
 		//TODO...Add direction to the websocket rules
 		findings, err := ruleRunner.RunRulesOnWebsocketMessage(mt, message)
@@ -84,7 +110,7 @@ func (bwsh *BlueberryWebsocketHandler) ProxyRequests(clientConn *ws_gorilla.Conn
 		bwsh.logger.Debug("Websocket client -> backend server findings", findings)
 
 		//Get the verdict based on the findings
-		verdict := rules.GetVerdictBasedOnFindings(bwsh.rules, bwsh.configuration.RuleConfig.DefaultAction, findings)
+		verdict, _, _ := rules.GetServiceVerdict(ruleSet.Rules(), bwsh.service.RuleConfig.DefaultAction, bwsh.service, "ingress", findings, ruleRunner.BudgetExceeded())
 
 		if verdict == "drop" {
 			//Create forbidden json
@@ -112,9 +138,6 @@ func (bwsh *BlueberryWebsocketHandler) ProxyRequests(clientConn *ws_gorilla.Conn
 }
 
 func (bwsh *BlueberryWebsocketHandler) ProxyResponses(clientConn *ws_gorilla.Conn, errc chan error) {
-	//Create the rule runner
-	ruleRunner := rules.NewRuleRunner(bwsh.logger, bwsh.rules, bwsh.apiWsConn, bwsh.configuration)
-
 	for {
 		mt, message, err := bwsh.targetWsConn.ReadMessage()
 		if err != nil {
@@ -123,6 +146,10 @@ func (bwsh *BlueberryWebsocketHandler) ProxyResponses(clientConn *ws_gorilla.Con
 			return
 		}
 
+		//Get the current rule set, every message is checked with the latest rules
+		ruleSet := bwsh.ruleStore.ServiceRuleSet(bwsh.service)
+		ruleRunner := rules.NewRuleRunner(bwsh.logger, ruleSet, bwsh.apiWsConn, bwsh.configuration)
+
 		//Apply the rules on the websocket messages
 		//TODO...Add direction to the websocket rules
 		findings, err := ruleRunner.RunRulesOnWebsocketMessage(mt, message)
@@ -132,7 +159,7 @@ func (bwsh *BlueberryWebsocketHandler) ProxyResponses(clientConn *ws_gorilla.Con
 		bwsh.logger.Debug("Backend server -> websocket client findings", findings)
 
 		//Get the verdict based on the findings
-		verdict := rules.GetVerdictBasedOnFindings(bwsh.rules, bwsh.configuration.RuleConfig.DefaultAction, findings)
+		verdict, _, _ := rules.GetServiceVerdict(ruleSet.Rules(), bwsh.service.RuleConfig.DefaultAction, bwsh.service, "egress", findings, ruleRunner.BudgetExceeded())
 
 		if verdict == "drop" {
 			//Create forbidden json
@@ -161,16 +188,30 @@ func (bwsh *BlueberryWebsocketHandler) ProxyResponses(clientConn *ws_gorilla.Con
 
 // Handle websocket messages
 func (bwsh *BlueberryWebsocketHandler) HandleWebsocketConnection(rw http.ResponseWriter, r *http.Request) {
+	//Select the target of the connection, the connection to the target is released when the websocket is closed
+	remoteIp, _, _ := net.SplitHostPort(r.RemoteAddr)
+	target, err := bwsh.upstreams.Select(remoteIp)
+	if err != nil {
+		bwsh.logger.Error("Could not forward the websocket connection of", r.RemoteAddr, "to service", bwsh.service.Name+",", err.Error())
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer bwsh.upstreams.Release(target)
+
 	//Connect to target websocket server
-	err := bwsh.ConnectToTargetServer()
+	subprotocol, err := bwsh.ConnectToTargetServer(target, r)
 	if err != nil {
 		bwsh.logger.Error("Failed to connect to target websocket server", err.Error())
 		return
 	}
 	defer bwsh.targetWsConn.Close()
 
-	// Upgrade incoming HTTP request to WebSocket
-	clientConn, err := upgrader.Upgrade(rw, r, nil)
+	// Upgrade incoming HTTP request to WebSocket, with the subprotocol selected by the target
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {subprotocol}}
+	}
+	clientConn, err := upgrader.Upgrade(rw, r, responseHeader)
 	if err != nil {
 		bwsh.logger.Error("Failed to upgrade client connection", err.Error(), clientConn.RemoteAddr().String())
 		return
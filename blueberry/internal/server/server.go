@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"blueberry/internal/config"
@@ -16,7 +17,9 @@ import (
 	code "blueberry/internal/detection/code"
 	rules "blueberry/internal/detection/rules"
 	"blueberry/internal/logging"
+	"blueberry/internal/models"
 	"blueberry/internal/server/handlers"
+	"blueberry/internal/upstream"
 	"blueberry/internal/utils"
 	"blueberry/internal/websocket"
 
@@ -52,8 +55,11 @@ type BlueberryServer struct {
 	apiBaseURL    string
 	configuration config.Configuration
 	checkers      []code.IValidator
-	rules         []rules.Rule
+	ruleStore     *rules.RuleStore
+	rulesWatcher  *rules.RulesWatcher
 	configFile    string
+	profileServer *http.Server
+	upstreamPools []*upstream.Pool
 }
 
 // Initialize the proxy http server based on the configuration file
@@ -90,12 +96,29 @@ func (server *BlueberryServer) Init() error {
 			server.logger.Error("Could not load rules from", server.configuration.RuleConfig.RulesDirectory, err.Error())
 		}
 		server.logger.Info("Loaded", len(allRules), "rules from", server.configuration.RuleConfig.RulesDirectory)
-		//Add the list of rules to the server structure
-		server.rules = allRules
+		//Create the rule store which will be shared by all the handlers
+		//The rules are compiled once for every service, based on the rules selected by the service
+		server.ruleStore, err = rules.NewRuleStore(server.logger, server.configuration, allRules)
+		if err != nil {
+			server.logger.Fatal("Could not compile the rules,", err.Error())
+			return err
+		}
+
+		//Watch the rules directory so the rules are reloaded when a rule file changes
+		if !server.configuration.RuleConfig.DisableWatcher {
+			server.rulesWatcher = rules.NewRulesWatcher(server.logger, server.configuration, func() { server.reloadRules("watcher") })
+			err = server.rulesWatcher.Start()
+			if err != nil {
+				server.logger.Error("Could not watch the rules directory for changes,", err.Error())
+				server.rulesWatcher = nil
+			} else {
+				server.logger.Info("Watching", server.configuration.RuleConfig.RulesDirectory, "for rule changes")
+			}
+		}
 	} else {
 		server.logger.Warning("No rules were loaded because the rules directory was not specified")
-		//Assign empty slice to the rules slice of the server structure
-		server.rules = make([]rules.Rule, 0)
+		//Create the rule store with an empty list of rules
+		server.ruleStore, _ = rules.NewRuleStore(server.logger, server.configuration, nil)
 	}
 
 	//Check if the listening protocol is https and if it is check if the certificate file and the key file exist on disk
@@ -182,6 +205,14 @@ func (server *BlueberryServer) Init() error {
 	//server.checkers = append(server.checkers, code.NewUserserverValidator(server.logger, server.configuration))
 	//Loop through the services and create a proxy server for each of them
 	for _, service := range server.configuration.Services {
+		//Create the pool of upstream targets the traffic of the service is balanced between
+		pool, err := upstream.NewPool(server.logger, service)
+		if err != nil {
+			server.logger.Error("Could not create the upstream pool of service", service.Name, err.Error())
+			return err
+		}
+		server.upstreamPools = append(server.upstreamPools, pool)
+
 		//If the service listening protocol is http create a http server
 		if service.ListeningProtocol == "http" || service.ListeningProtocol == "https" {
 			//Create the router
@@ -192,9 +223,10 @@ func (server *BlueberryServer) Init() error {
 				server.logger,
 				server.apiBaseURL,
 				server.configuration,
-				service.RemoteURL,
+				service,
+				pool,
 				server.checkers,
-				server.rules,
+				server.ruleStore,
 				apiWsConnection,
 			)
 
@@ -209,10 +241,11 @@ func (server *BlueberryServer) Init() error {
 					HttpServer: &http.Server{
 						Addr: service.ListeningAddress + ":" + service.ListeningPort,
 						// Good practice to set timeouts to avoid Slowloris attacks.
-						WriteTimeout: time.Second * 60,
-						ReadTimeout:  time.Second * 15,
-						IdleTimeout:  time.Second * 60,
-						Handler:      r, // Pass our instance of gorilla/mux in.
+						// The bodies are streamed, so the handler moves the read and write deadlines forward with every chunk
+						// instead of limiting the whole request and response
+						ReadHeaderTimeout: time.Second * 15,
+						IdleTimeout:       time.Second * 60,
+						Handler:           r, // Pass our instance of gorilla/mux in.
 					}})
 		}
 
@@ -224,9 +257,10 @@ func (server *BlueberryServer) Init() error {
 				server.logger,
 				server.apiBaseURL,
 				server.configuration,
-				service.RemoteURL,
+				service,
+				pool,
 				server.checkers,
-				server.rules,
+				server.ruleStore,
 				apiWsConnection,
 			)
 			//Create the tcp listener and add it to the proxy servers
@@ -254,9 +288,10 @@ func (server *BlueberryServer) Init() error {
 				server.logger,
 				server.apiBaseURL,
 				server.configuration,
+				service,
 				service.RemoteURL,
 				server.checkers,
-				server.rules,
+				server.ruleStore,
 				apiWsConnection,
 			)
 
@@ -282,6 +317,85 @@ func (server *BlueberryServer) Init() error {
 	return nil
 }
 
+// Reloads the rules from the rules directory and reports the outcome to cranberry
+// If the new rules are not valid the current rules are kept
+// @param trigger - what caused the reload (sighup, watcher)
+func (server *BlueberryServer) reloadRules(trigger string) {
+	reloadData := models.RulesReloadData{AgentId: server.configuration.UUID, Trigger: trigger, Errors: make([]string, 0)}
+
+	previousCount, newCount, err := server.ruleStore.Reload()
+	if err != nil {
+		server.logger.Error("Rules reload triggered by", trigger, "failed, keeping the", previousCount, "rules in use,", err.Error())
+		reloadData.Success = false
+		reloadData.Errors = append(reloadData.Errors, strings.Split(err.Error(), "\n")...)
+	} else {
+		server.logger.Info("Rules reload triggered by", trigger, "succeeded, replaced", previousCount, "rules with", newCount, "rules")
+		reloadData.Success = true
+	}
+	reloadData.PreviousRulesCount = int64(previousCount)
+	reloadData.RulesCount = int64(newCount)
+	reloadData.Timestamp = time.Now().Unix()
+
+	//Report the reload to cranberry
+	cClient := cranberry.NewCranberryClient(server.logger, server.configuration)
+	_, err = cClient.SendRulesReload(reloadData)
+	if err != nil {
+		server.logger.Error("Failed to send rules reload to cranberry", err.Error())
+	}
+}
+
+// Sends the evaluation statistics of the rules to cranberry
+func (server *BlueberryServer) sendRulesProfile() {
+	cClient := cranberry.NewCranberryClient(server.logger, server.configuration)
+	_, err := cClient.SendRulesProfile(server.ruleStore.Profiler().Report(server.configuration.UUID))
+	if err != nil {
+		server.logger.Error("Failed to send rules profile to cranberry", err.Error())
+	}
+}
+
+// Starts the endpoint which exposes the evaluation statistics of the rules and sends them periodically to cranberry
+// The endpoint is started only if the profiling address is specified in the configuration
+// @param done - the channel which is closed when the server shuts down
+func (server *BlueberryServer) startRulesProfiling(done <-chan struct{}) {
+	if server.configuration.RuleConfig == nil {
+		return
+	}
+
+	if server.configuration.RuleConfig.ProfilingAddress != "" {
+		router := mux.NewRouter()
+		router.HandleFunc("/rules/profile", func(rw http.ResponseWriter, r *http.Request) {
+			report := server.ruleStore.Profiler().Report(server.configuration.UUID)
+			rw.Header().Set("Content-Type", "application/json")
+			if err := report.ToJSON(rw); err != nil {
+				server.logger.Error("Failed to send the rules profile", err.Error())
+			}
+		}).Methods("GET")
+
+		server.profileServer = &http.Server{Addr: server.configuration.RuleConfig.ProfilingAddress, Handler: router, ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+		go func() {
+			if err := server.profileServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				server.logger.Error("Rules profiling endpoint stopped,", err.Error())
+			}
+		}()
+		server.logger.Info("Started rules profiling endpoint on", server.configuration.RuleConfig.ProfilingAddress)
+	}
+
+	if server.configuration.RuleConfig.ProfilingReportInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(server.configuration.RuleConfig.ProfilingReportInterval) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					server.sendRulesProfile()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+}
+
 // Start the proxy server
 func (server *BlueberryServer) Run() {
 	var wait time.Duration = 5
@@ -337,6 +451,26 @@ func (server *BlueberryServer) Run() {
 		server.logger.Info("Started", proxyServer.ServerProtocol, "server on port", proxyServer.ServerPort)
 	}
 
+	//Reload the rules when SIGHUP is received
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			server.logger.Info("Received SIGHUP, reloading rules")
+			server.reloadRules("sighup")
+		}
+	}()
+
+	//Expose the evaluation statistics of the rules
+	profilingDone := make(chan struct{})
+	server.startRulesProfiling(profilingDone)
+
+	//Check the health of the upstream targets
+	healthChecksDone := make(chan struct{})
+	for _, pool := range server.upstreamPools {
+		pool.StartHealthChecks(healthChecksDone)
+	}
+
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
@@ -351,6 +485,20 @@ func (server *BlueberryServer) Run() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 
+	//Stop watching the rules directory
+	if server.rulesWatcher != nil {
+		server.rulesWatcher.Close()
+	}
+
+	//Stop reporting the evaluation statistics of the rules
+	close(profilingDone)
+	if server.profileServer != nil {
+		server.profileServer.Shutdown(ctx)
+	}
+
+	//Stop the health checks of the upstream targets
+	close(healthChecksDone)
+
 	//Close all the servers
 	for _, proxyServer := range server.proxyServers {
 		if proxyServer.ServerProtocol == "http" || proxyServer.ServerProtocol == "https" {
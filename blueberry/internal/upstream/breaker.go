@@ -0,0 +1,65 @@
+package upstream
+
+import (
+	"time"
+)
+
+// The states of the circuit of a target
+const (
+	circuitClosed   int32 = iota //The traffic is sent to the target
+	circuitOpen                  //No traffic is sent to the target until the open time passes
+	circuitHalfOpen              //A trial request was sent to the target, no other traffic is sent until it finishes
+)
+
+// Checks if the circuit of the target lets the traffic through
+// An open circuit lets a trial request through after the open time, a half-open circuit lets another trial through
+// if the previous one did not finish in the open time
+func (t *Target) circuitAllows(now time.Time) bool {
+	return t.circuitState.Load() == circuitClosed || now.UnixNano() >= t.circuitOpenUntil.Load()
+}
+
+// Claims the trial request of the selected target if its circuit is not closed
+// Only the request which moves the open time forward is the trial request, the concurrent selections of the same target
+// lose the claim, so a single request is sent to a target which is already failing
+// Returns false if another request claimed the trial, the target should not be used
+func (p *Pool) acquireCircuit(target *Target, now time.Time) bool {
+	if p.circuitBreaker == nil || target.circuitState.Load() == circuitClosed {
+		return true
+	}
+	openUntil := target.circuitOpenUntil.Load()
+	if now.UnixNano() < openUntil {
+		return false
+	}
+	if !target.circuitOpenUntil.CompareAndSwap(openUntil, now.Add(time.Duration(p.circuitBreaker.OpenTime)*time.Second).UnixNano()) {
+		return false
+	}
+	if target.circuitState.CompareAndSwap(circuitOpen, circuitHalfOpen) {
+		p.logger.Info("Circuit of upstream", target.URL.String(), "of service", p.serviceName, "is half-open, sending a trial request")
+	}
+	return true
+}
+
+// Closes the circuit of the target after a successful request or connection
+func (p *Pool) closeCircuit(target *Target) {
+	target.circuitFailures.Store(0)
+	if p.circuitBreaker != nil && target.circuitState.Swap(circuitClosed) != circuitClosed {
+		p.logger.Info("Circuit of upstream", target.URL.String(), "of service", p.serviceName, "is closed again")
+	}
+}
+
+// Records a failure on the circuit of the target
+// The circuit opens after the consecutive failures of the failure threshold or if the trial request failed
+func (p *Pool) recordCircuitFailure(target *Target) {
+	if p.circuitBreaker == nil {
+		return
+	}
+	failures := target.circuitFailures.Add(1)
+	if target.circuitState.Load() != circuitHalfOpen && failures < p.circuitBreaker.FailureThreshold {
+		return
+	}
+	target.circuitFailures.Store(0)
+	target.circuitOpenUntil.Store(time.Now().Add(time.Duration(p.circuitBreaker.OpenTime) * time.Second).UnixNano())
+	if target.circuitState.Swap(circuitOpen) != circuitOpen {
+		p.logger.Warning("Circuit of upstream", target.URL.String(), "of service", p.serviceName, "is open for", p.circuitBreaker.OpenTime, "seconds after", failures, "consecutive failures")
+	}
+}
@@ -0,0 +1,141 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"blueberry/internal/config"
+)
+
+// The steps applied on the target of the circuit breaker tests
+const (
+	stepSuccess = "success" //A successful request is reported
+	stepFailure = "failure" //A failed request is reported
+	stepExpire  = "expire"  //The open time of the circuit passes
+	stepSelect  = "select"  //The target is selected for a request
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     []string
+		state     int32
+		available bool
+	}{
+		{
+			name:      "closed below the threshold",
+			steps:     []string{stepFailure, stepFailure},
+			state:     circuitClosed,
+			available: true,
+		},
+		{
+			name:  "open at the threshold",
+			steps: []string{stepFailure, stepFailure, stepFailure},
+			state: circuitOpen,
+		},
+		{
+			name:      "failures reset by a success",
+			steps:     []string{stepFailure, stepFailure, stepSuccess, stepFailure, stepFailure},
+			state:     circuitClosed,
+			available: true,
+		},
+		{
+			name:      "trial after the open time",
+			steps:     []string{stepFailure, stepFailure, stepFailure, stepExpire},
+			state:     circuitOpen,
+			available: true,
+		},
+		{
+			name:      "closed after a successful trial",
+			steps:     []string{stepFailure, stepFailure, stepFailure, stepExpire, stepSelect, stepSuccess},
+			state:     circuitClosed,
+			available: true,
+		},
+		{
+			name:  "open again after a failed trial",
+			steps: []string{stepFailure, stepFailure, stepFailure, stepExpire, stepSelect, stepFailure},
+			state: circuitOpen,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := newTestPool(t, "round_robin", []*config.UpstreamTarget{{URL: "http://10.0.0.1:8080"}}, &config.CircuitBreakerOptions{FailureThreshold: 3, OpenTime: 30})
+			target := pool.Targets()[0]
+			for _, step := range test.steps {
+				switch step {
+				case stepSuccess:
+					pool.ReportSuccess(target)
+				case stepFailure:
+					pool.ReportFailure(target)
+				case stepExpire:
+					target.circuitOpenUntil.Store(time.Now().Add(-time.Second).UnixNano())
+				case stepSelect:
+					if _, err := pool.Select("192.0.2.10"); err != nil {
+						t.Fatalf("Select() error = %v", err)
+					}
+				}
+			}
+			if state := target.circuitState.Load(); state != test.state {
+				t.Errorf("circuit state = %d, expected %d", state, test.state)
+			}
+
+			//The trial request moves the circuit to half-open
+			selected, err := pool.Select("192.0.2.10")
+			if (err == nil) != test.available {
+				t.Fatalf("Select() error = %v, expected available %v", err, test.available)
+			}
+			if err == nil && test.state == circuitOpen {
+				if state := selected.circuitState.Load(); state != circuitHalfOpen {
+					t.Errorf("circuit state after the trial = %d, expected %d", state, circuitHalfOpen)
+				}
+				if _, err := pool.Select("192.0.2.10"); !errors.Is(err, ErrNoAvailableTarget) {
+					t.Errorf("Select() during the trial error = %v, expected %v", err, ErrNoAvailableTarget)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	pool := newTestPool(t, "least_connections", []*config.UpstreamTarget{{URL: "http://10.0.0.1:8080"}}, &config.CircuitBreakerOptions{FailureThreshold: 1, OpenTime: 30})
+	target := pool.Targets()[0]
+	pool.ReportFailure(target)
+	target.circuitOpenUntil.Store(time.Now().Add(-time.Second).UnixNano())
+
+	//The concurrent selections all see the target as available, only the first claim gets the trial request
+	now := time.Now()
+	if !target.available(now) {
+		t.Fatalf("target not available after the open time")
+	}
+	if !pool.acquireCircuit(target, now) {
+		t.Errorf("acquireCircuit() = false, expected the first claim to get the trial")
+	}
+	if pool.acquireCircuit(target, now) {
+		t.Errorf("acquireCircuit() = true, expected the second claim to lose the trial")
+	}
+
+	//Only one of the concurrent selections gets the next trial request
+	target.circuitOpenUntil.Store(time.Now().Add(-time.Second).UnixNano())
+	var selected atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := pool.Select("192.0.2.10"); err == nil {
+				selected.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if selected.Load() != 1 {
+		t.Errorf("%d selections got the trial request, expected 1", selected.Load())
+	}
+}
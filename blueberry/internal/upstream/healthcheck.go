@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Starts the active health checks of the targets of the pool
+// The checks run only if the interval of the health checks is specified
+// @param done - the channel which is closed when the server shuts down
+func (p *Pool) StartHealthChecks(done <-chan struct{}) {
+	if p.healthCheck == nil || p.healthCheck.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(p.healthCheck.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkTargets()
+			case <-done:
+				return
+			}
+		}
+	}()
+	p.logger.Info("Started", p.healthCheck.Type, "health checks of the", len(p.targets), "upstreams of service", p.serviceName)
+}
+
+// Checks all the targets at the same time and waits for all the checks to finish
+func (p *Pool) checkTargets() {
+	var wg sync.WaitGroup
+	for _, target := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.updateHealth(target, p.checkTarget(target))
+		}()
+	}
+	wg.Wait()
+}
+
+// Runs the active check of the target
+// The http check requests the path of the health checks and fails on a status code of 400 or higher, the tcp check connects to the target
+// Returns true if the target passed the check
+func (p *Pool) checkTarget(target *Target) bool {
+	timeout := time.Duration(p.healthCheck.Timeout) * time.Second
+	if p.healthCheck.Type == "tcp" {
+		conn, err := net.DialTimeout("tcp", target.Host(), timeout)
+		if err != nil {
+			p.logger.Debug("Health check of upstream", target.URL.String(), "failed,", err.Error())
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	checkURL := *target.URL
+	checkURL.Path, checkURL.RawPath, checkURL.RawQuery = p.healthCheck.Path, "", ""
+	httpClient := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := httpClient.Get(checkURL.String())
+	if err != nil {
+		p.logger.Debug("Health check of upstream", target.URL.String(), "failed,", err.Error())
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		p.logger.Debug("Health check of upstream", target.URL.String(), "failed, status code", resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// Updates the health of the target with the outcome of a check
+// A healthy target is removed after the consecutive failures of the unhealthy threshold and
+// an unhealthy target is restored after the consecutive successes of the healthy threshold
+func (p *Pool) updateHealth(target *Target, passed bool) {
+	if passed {
+		target.checkFailures = 0
+		target.checkSuccesses++
+		if !target.healthy.Load() && target.checkSuccesses >= p.healthCheck.HealthyThreshold {
+			target.healthy.Store(true)
+			p.logger.Info("Upstream", target.URL.String(), "of service", p.serviceName, "is healthy again, restored to the pool")
+		}
+		return
+	}
+
+	target.checkSuccesses = 0
+	target.checkFailures++
+	if target.healthy.Load() && target.checkFailures >= p.healthCheck.UnhealthyThreshold {
+		target.healthy.Store(false)
+		p.logger.Warning("Upstream", target.URL.String(), "of service", p.serviceName, "is unhealthy, removed from the pool after", target.checkFailures, "failed checks")
+	}
+}
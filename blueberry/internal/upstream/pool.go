@@ -0,0 +1,220 @@
+package upstream
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+)
+
+// The number of points a target with weight 1 has on the consistent hash ring
+const hashRingReplicas = 100
+
+// The error returned when all the targets of the pool are unhealthy, ejected or have the circuit open
+var ErrNoAvailableTarget = errors.New("no upstream target is available")
+
+// Holds a target of the upstream pool and its health
+type Target struct {
+	URL               *url.URL     //The URL of the target
+	weight            int64        //The share of the traffic sent to the target
+	healthy           atomic.Bool  //If the active health checks consider the target healthy
+	ejectedUntil      atomic.Int64 //The unix time in nanoseconds until which the target is ejected by the passive health checks
+	failures          atomic.Int64 //The number of consecutive failed requests
+	activeConnections atomic.Int64 //The number of requests or connections currently sent to the target
+	circuitState      atomic.Int32 //The state of the circuit of the target (closed, open or half-open)
+	circuitOpenUntil  atomic.Int64 //The unix time in nanoseconds until which no traffic is sent while the circuit is open or half-open
+	circuitFailures   atomic.Int64 //The number of consecutive failures counted by the circuit breaker
+	currentWeight     int64        //The current weight used by the weighted round-robin (guarded by the mutex of the pool)
+	checkSuccesses    int64        //The number of consecutive successful active checks (used only by the health checker)
+	checkFailures     int64        //The number of consecutive failed active checks (used only by the health checker)
+}
+
+// Holds a point of the consistent hash ring
+type hashRingPoint struct {
+	hash   uint32  //The hash of the point
+	target *Target //The target which owns the point
+}
+
+// Holds the targets of a service and selects the target of every request or connection
+type Pool struct {
+	logger         logging.ILogger               //The logger interface
+	serviceName    string                        //The name of the service
+	targets        []*Target                     //The targets of the pool
+	loadBalancing  string                        //How a target is chosen (round_robin, least_connections or consistent_hash)
+	healthCheck    *config.HealthCheckOptions    //The health checks of the targets (nil if the targets are not checked)
+	circuitBreaker *config.CircuitBreakerOptions //The circuit breaker of the targets (nil if the circuits are always closed)
+	ring           []hashRingPoint               //The consistent hash ring, sorted by the hash of the points
+	mutex          sync.Mutex                    //Guards the current weights of the weighted round-robin
+}
+
+// Creates the upstream pool of the service
+// If the service does not declare upstreams the remote URL is the only target of the pool
+// @param logger - the logger interface
+// @param service - the service from the configuration
+// Returns the pool or an error if the URL of a target is not valid
+func NewPool(logger logging.ILogger, service *config.BackendServices) (*Pool, error) {
+	upstreams := service.Upstreams
+	if len(upstreams) == 0 {
+		upstreams = []*config.UpstreamTarget{{URL: service.RemoteURL, Weight: 1}}
+	}
+
+	pool := &Pool{logger: logger, serviceName: service.Name, targets: make([]*Target, 0, len(upstreams)), loadBalancing: service.LoadBalancing, healthCheck: service.HealthCheck, circuitBreaker: service.CircuitBreaker}
+	for _, upstream := range upstreams {
+		targetURL, err := url.Parse(upstream.URL)
+		if err != nil {
+			return nil, errors.New("could not parse the upstream url " + upstream.URL + ", " + err.Error())
+		}
+		target := &Target{URL: targetURL, weight: max(int64(upstream.Weight), 1)}
+		target.healthy.Store(true)
+		pool.targets = append(pool.targets, target)
+	}
+
+	//Every target has a number of points on the ring proportional to its weight
+	if pool.loadBalancing == "consistent_hash" {
+		for _, target := range pool.targets {
+			for i := int64(0); i < hashRingReplicas*target.weight; i++ {
+				pool.ring = append(pool.ring, hashRingPoint{hash: hashKey(target.URL.String() + "#" + strconv.FormatInt(i, 10)), target: target})
+			}
+		}
+		sort.Slice(pool.ring, func(i, j int) bool { return pool.ring[i].hash < pool.ring[j].hash })
+	}
+	return pool, nil
+}
+
+// Computes the hash of a key on the consistent hash ring
+func hashKey(key string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32()
+}
+
+// Gets the host and the port of the target
+func (t *Target) Host() string {
+	return t.URL.Host
+}
+
+// Checks if the target can receive traffic, it should be healthy, not ejected and its circuit should let the traffic through
+func (t *Target) available(now time.Time) bool {
+	return t.healthy.Load() && now.UnixNano() >= t.ejectedUntil.Load() && t.circuitAllows(now)
+}
+
+// Gets the targets of the pool
+func (p *Pool) Targets() []*Target {
+	return p.targets
+}
+
+// Selects the target of a request or a connection based on the load balancing of the service
+// The unavailable targets are skipped, the connection to the target should be released when it is done
+// If the trial request of the selected target was claimed by another request the selection is repeated
+// @param clientIP - the IP address of the client, used by the consistent hash
+// Returns the target or ErrNoAvailableTarget if no target can receive traffic
+func (p *Pool) Select(clientIP string) (*Target, error) {
+	for range len(p.targets) {
+		now := time.Now()
+		var target *Target
+		switch p.loadBalancing {
+		case "least_connections":
+			target = p.selectLeastConnections(now)
+		case "consistent_hash":
+			target = p.selectConsistentHash(clientIP, now)
+		default:
+			target = p.selectRoundRobin(now)
+		}
+		if target == nil {
+			return nil, ErrNoAvailableTarget
+		}
+		if !p.acquireCircuit(target, now) {
+			continue
+		}
+		target.activeConnections.Add(1)
+		return target, nil
+	}
+	return nil, ErrNoAvailableTarget
+}
+
+// Releases the connection to the target selected for a request or a connection
+func (p *Pool) Release(target *Target) {
+	target.activeConnections.Add(-1)
+}
+
+// Selects the target with the smooth weighted round-robin, the targets with a higher weight are not chosen in bursts
+func (p *Pool) selectRoundRobin(now time.Time) *Target {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var selected *Target
+	totalWeight := int64(0)
+	for _, target := range p.targets {
+		if !target.available(now) {
+			continue
+		}
+		target.currentWeight += target.weight
+		totalWeight += target.weight
+		if selected == nil || target.currentWeight > selected.currentWeight {
+			selected = target
+		}
+	}
+	if selected != nil {
+		selected.currentWeight -= totalWeight
+	}
+	return selected
+}
+
+// Selects the target with the least active connections relative to its weight
+func (p *Pool) selectLeastConnections(now time.Time) *Target {
+	var selected *Target
+	for _, target := range p.targets {
+		if !target.available(now) {
+			continue
+		}
+		//Compares connections/weight without dividing
+		if selected == nil || target.activeConnections.Load()*selected.weight < selected.activeConnections.Load()*target.weight {
+			selected = target
+		}
+	}
+	return selected
+}
+
+// Selects the target which owns the first point of the ring after the hash of the client IP
+// If the target is not available the next points of the ring are used, so only the clients of that target are moved
+func (p *Pool) selectConsistentHash(clientIP string, now time.Time) *Target {
+	if len(p.ring) == 0 {
+		return nil
+	}
+	hash := hashKey(clientIP)
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	for i := 0; i < len(p.ring); i++ {
+		point := p.ring[(start+i)%len(p.ring)]
+		if point.target.available(now) {
+			return point.target
+		}
+	}
+	return nil
+}
+
+// Records a successful request or connection to the target, the consecutive failures are reset and the circuit is closed
+func (p *Pool) ReportSuccess(target *Target) {
+	target.failures.Store(0)
+	p.closeCircuit(target)
+}
+
+// Records a failed request or connection to the target
+// The target is ejected after the consecutive failures configured for the passive health checks and
+// its circuit opens after the consecutive failures configured for the circuit breaker
+func (p *Pool) ReportFailure(target *Target) {
+	p.recordCircuitFailure(target)
+	failures := target.failures.Add(1)
+	if p.healthCheck == nil || p.healthCheck.MaxFailures <= 0 || failures < p.healthCheck.MaxFailures {
+		return
+	}
+	target.failures.Store(0)
+	target.ejectedUntil.Store(time.Now().Add(time.Duration(p.healthCheck.EjectionTime) * time.Second).UnixNano())
+	p.logger.Warning("Upstream", target.URL.String(), "of service", p.serviceName, "ejected for", p.healthCheck.EjectionTime, "seconds after", failures, "consecutive failures")
+}
@@ -0,0 +1,122 @@
+package upstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"blueberry/internal/config"
+	"blueberry/internal/logging"
+)
+
+// Creates the pool of the upstreams with the load balancing
+func newTestPool(t *testing.T, loadBalancing string, upstreams []*config.UpstreamTarget, circuitBreaker *config.CircuitBreakerOptions) *Pool {
+	pool, err := NewPool(logging.NewDefaultLogger(), &config.BackendServices{Name: "test", Upstreams: upstreams, LoadBalancing: loadBalancing, CircuitBreaker: circuitBreaker})
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	return pool
+}
+
+func TestPoolSelect(t *testing.T) {
+	upstreams := []*config.UpstreamTarget{{URL: "http://10.0.0.1:8080", Weight: 2}, {URL: "http://10.0.0.2:8080", Weight: 1}}
+	equalUpstreams := []*config.UpstreamTarget{{URL: "http://10.0.0.1:8080", Weight: 1}, {URL: "http://10.0.0.2:8080", Weight: 1}}
+	tests := []struct {
+		name          string
+		loadBalancing string
+		upstreams     []*config.UpstreamTarget
+		setup         func(targets []*Target)
+		expected      []string
+	}{
+		{
+			name:          "weighted round robin",
+			loadBalancing: "round_robin",
+			upstreams:     upstreams,
+			expected:      []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.1:8080", "10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.1:8080"},
+		},
+		{
+			name:          "round robin without unhealthy targets",
+			loadBalancing: "round_robin",
+			upstreams:     upstreams,
+			setup:         func(targets []*Target) { targets[0].healthy.Store(false) },
+			expected:      []string{"10.0.0.2:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:          "round robin without ejected targets",
+			loadBalancing: "round_robin",
+			upstreams:     upstreams,
+			setup:         func(targets []*Target) { targets[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano()) },
+			expected:      []string{"10.0.0.1:8080", "10.0.0.1:8080"},
+		},
+		{
+			name:          "least connections",
+			loadBalancing: "least_connections",
+			upstreams:     equalUpstreams,
+			setup:         func(targets []*Target) { targets[0].activeConnections.Store(2) },
+			expected:      []string{"10.0.0.2:8080", "10.0.0.2:8080", "10.0.0.1:8080"},
+		},
+		{
+			name:          "least connections relative to the weight",
+			loadBalancing: "least_connections",
+			upstreams:     upstreams,
+			setup:         func(targets []*Target) { targets[0].activeConnections.Store(1) },
+			expected:      []string{"10.0.0.2:8080", "10.0.0.1:8080"},
+		},
+		{
+			name:          "consistent hash without unhealthy targets",
+			loadBalancing: "consistent_hash",
+			upstreams:     equalUpstreams,
+			setup:         func(targets []*Target) { targets[0].healthy.Store(false) },
+			expected:      []string{"10.0.0.2:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:          "no available target",
+			loadBalancing: "round_robin",
+			upstreams:     upstreams,
+			setup: func(targets []*Target) {
+				targets[0].healthy.Store(false)
+				targets[1].healthy.Store(false)
+			},
+			expected: []string{""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := newTestPool(t, test.loadBalancing, test.upstreams, nil)
+			if test.setup != nil {
+				test.setup(pool.Targets())
+			}
+			for i, expected := range test.expected {
+				target, err := pool.Select("192.0.2.10")
+				if expected == "" {
+					if !errors.Is(err, ErrNoAvailableTarget) {
+						t.Errorf("Select() #%d error = %v, expected %v", i, err, ErrNoAvailableTarget)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Select() #%d error = %v", i, err)
+				}
+				if target.Host() != expected {
+					t.Errorf("Select() #%d = %s, expected %s", i, target.Host(), expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolSelectConsistentHash(t *testing.T) {
+	pool := newTestPool(t, "consistent_hash", []*config.UpstreamTarget{{URL: "http://10.0.0.1:8080"}, {URL: "http://10.0.0.2:8080"}, {URL: "http://10.0.0.3:8080"}}, nil)
+	for _, clientIP := range []string{"192.0.2.10", "192.0.2.11", "198.51.100.7", "2001:db8::1"} {
+		first, err := pool.Select(clientIP)
+		if err != nil {
+			t.Fatalf("Select(%s) error = %v", clientIP, err)
+		}
+		for range 5 {
+			if target, _ := pool.Select(clientIP); target != first {
+				t.Errorf("Select(%s) = %s, expected the same target %s", clientIP, target.Host(), first.Host())
+			}
+		}
+	}
+}
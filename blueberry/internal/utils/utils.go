@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
@@ -124,7 +123,6 @@ func DumpHTTPRequest(req *http.Request) ([]byte, error) {
 
 	//Reassign the body so other function can read the data
 	req.Body = io.NopCloser(bytes.NewReader(bodyData))
-	fmt.Println(bodyData)
 	//bodyData, _ = io.ReadAll(req.Body)
 
 	rawRequest = append(rawRequest, bodyData...)
@@ -235,14 +233,19 @@ func FindFindingDataInRawdata(rawData string, searchString string) (int64, int64
 
 // Gets the forbidden message base64 encoded
 func GetEncodedForbiddenMessage(forbiddenMessage string) (string, error) {
+	return GetEncodedHTTPMessage(http.StatusForbidden, forbiddenMessage)
+}
+
+// Gets the response with the status code and the html message base64 encoded
+func GetEncodedHTTPMessage(statusCode int, message string) (string, error) {
 	resp := http.Response{}
-	resp.StatusCode = http.StatusForbidden
+	resp.StatusCode = statusCode
 	resp.ProtoMajor = 1
 	resp.ProtoMinor = 1
 	resp.Header = http.Header{}
-	resp.Header.Add("Content-Length", strconv.Itoa(len(forbiddenMessage)))
+	resp.Header.Add("Content-Length", strconv.Itoa(len(message)))
 	resp.Header.Add("Content-Type", "text/html; charset=utf-8")
-	resp.Body = io.NopCloser(strings.NewReader(forbiddenMessage))
+	resp.Body = io.NopCloser(strings.NewReader(message))
 
 	rawResp, err := httputil.DumpResponse(&resp, true)
 	if err != nil {
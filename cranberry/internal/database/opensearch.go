@@ -181,6 +181,52 @@ func (osc *OpensearchConnection) InsertAgentLog(log models.ExtendedLogData) erro
 	return nil
 }
 
+// Insert the outcome of a rules reload from an agent
+// The reloads are kept in a separate index so they do not show up with the logs
+func (osc *OpensearchConnection) InsertRulesReload(reload models.RulesReloadData) error {
+	reloadData, err := json.Marshal(reload)
+	if err != nil {
+		osc.logger.Error("Failed to marshal rules reload data to JSON", err.Error())
+		return err
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index: "cranberry-rules-reloads",
+		Body:  strings.NewReader(string(reloadData)),
+	}
+
+	_, err = req.Do(context.Background(), osc.client)
+	if err != nil {
+		osc.logger.Error("Failed to insert rules reload into OpenSearch database", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Insert the evaluation statistics of the rules from an agent
+// The profiles are kept in a separate index so they do not show up with the logs
+func (osc *OpensearchConnection) InsertRulesProfile(profile models.RulesProfileData) error {
+	profileData, err := json.Marshal(profile)
+	if err != nil {
+		osc.logger.Error("Failed to marshal rules profile data to JSON", err.Error())
+		return err
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index: "cranberry-rules-profiles",
+		Body:  strings.NewReader(string(profileData)),
+	}
+
+	_, err = req.Do(context.Background(), osc.client)
+	if err != nil {
+		osc.logger.Error("Failed to insert rules profile into OpenSearch database", err.Error())
+		return err
+	}
+
+	return nil
+}
+
 func (osc *OpensearchConnection) GetLogs(logType string) (models.ViewExtendedLogsData, error) {
 	//Prepare the query
 	content := strings.NewReader(
@@ -229,6 +275,65 @@ func (osc *OpensearchConnection) GetLogs(logType string) (models.ViewExtendedLog
 	return logs, nil
 }
 
+// Get the logs with findings of the rules which match the metadata from the filter
+// The metadata fields are matched exactly, the logs are sorted by the timestamp (the newest first)
+func (osc *OpensearchConnection) GetFindingsLogs(filter models.FindingsFilter) (models.ViewExtendedLogsData, error) {
+	//Build the filters of the query
+	filters := make([]map[string]any, 0)
+	terms := [][2]string{{"ruleIds.keyword", filter.RuleId}, {"cwe.keyword", filter.CWE}, {"owasp.keyword", filter.OWASP}, {"cve.keyword", filter.CVE}, {"ruleTags.keyword", filter.Tag}}
+	for _, term := range terms {
+		if term[1] != "" {
+			filters = append(filters, map[string]any{"term": map[string]any{term[0]: term[1]}})
+		}
+	}
+	if filter.From != 0 || filter.To != 0 {
+		timestampRange := make(map[string]int64)
+		if filter.From != 0 {
+			timestampRange["gte"] = filter.From
+		}
+		if filter.To != 0 {
+			timestampRange["lte"] = filter.To
+		}
+		filters = append(filters, map[string]any{"range": map[string]any{"timestamp": timestampRange}})
+	}
+
+	query := map[string]any{
+		"size":  1000,
+		"query": map[string]any{"bool": map[string]any{"filter": filters}},
+		"sort":  []map[string]any{{"timestamp": map[string]string{"order": "desc"}}},
+	}
+	content, err := json.Marshal(query)
+	if err != nil {
+		return []models.ViewExtendedLogData{}, err
+	}
+
+	search := opensearchapi.SearchRequest{
+		Index: []string{"cranberry"},
+		Body:  strings.NewReader(string(content)),
+	}
+
+	searchResponse, err := search.Do(context.Background(), osc.client)
+	if err != nil {
+		return []models.ViewExtendedLogData{}, err
+	}
+	defer searchResponse.Body.Close()
+
+	logsResp := SearchResponse[models.ViewExtendedLogData]{}
+	err = logsResp.FromJSON(searchResponse.Body)
+	if err != nil {
+		return []models.ViewExtendedLogData{}, err
+	}
+
+	logs := []models.ViewExtendedLogData{}
+	for _, hit := range logsResp.Hits.Hits {
+		log := models.ViewExtendedLogData{ExtendedLogData: hit.Source.ExtendedLogData}
+		log.Id = hit.Id
+		logs = append(logs, log)
+	}
+
+	return logs, nil
+}
+
 // Get all the logs from a stream based on the stream UUID
 func (osc *OpensearchConnection) GetStreamLogs(streamUUID string) (models.ViewExtendedLogsData, error) {
 	//Prepare the query
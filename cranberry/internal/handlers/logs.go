@@ -5,7 +5,10 @@ import (
 	"cranberry/internal/database"
 	"cranberry/internal/logging"
 	"cranberry/internal/models"
+	"cranberry/internal/utils"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
 	b64 "encoding/base64"
@@ -47,10 +50,32 @@ func (lh *LogsHandler) processHTTPLog(log models.LogData) models.ExtendedLogData
 	return extendedLog
 }
 
+// Adds the metadata of the rules from the request and the response findings to the log, so the logs can be searched by them
+func (lh *LogsHandler) addFindingsMetadata(extendedLog *models.ExtendedLogData) {
+	extendedLog.RuleIds = make([]string, 0)
+	extendedLog.CWE = make([]string, 0)
+	extendedLog.OWASP = make([]string, 0)
+	extendedLog.CVE = make([]string, 0)
+	extendedLog.RuleTags = make([]string, 0)
+
+	findings := append(slices.Clone(extendedLog.RequestFindings), extendedLog.ResponseFindings...)
+	for _, finding := range findings {
+		if finding == nil {
+			continue
+		}
+		extendedLog.RuleIds = utils.AppendUnique(extendedLog.RuleIds, finding.RuleId)
+		extendedLog.CWE = utils.AppendUnique(extendedLog.CWE, finding.CWE...)
+		extendedLog.OWASP = utils.AppendUnique(extendedLog.OWASP, finding.OWASP...)
+		extendedLog.CVE = utils.AppendUnique(extendedLog.CVE, finding.CVE...)
+		extendedLog.RuleTags = utils.AppendUnique(extendedLog.RuleTags, finding.Tags...)
+	}
+}
+
 func (lh *LogsHandler) processAgentLog(log models.LogData) models.ExtendedLogData {
+	extendedLog := models.ExtendedLogData{LogData: log}
 	switch log.Type {
 	case "http":
-		return lh.processHTTPLog(log)
+		extendedLog = lh.processHTTPLog(log)
 	case "websocket":
 		break
 	case "tcp":
@@ -59,7 +84,8 @@ func (lh *LogsHandler) processAgentLog(log models.LogData) models.ExtendedLogDat
 		break
 	}
 
-	return models.ExtendedLogData{LogData: log}
+	lh.addFindingsMetadata(&extendedLog)
+	return extendedLog
 }
 
 func (lh *LogsHandler) InsertAgentLog(rw http.ResponseWriter, r *http.Request) {
@@ -179,6 +205,40 @@ func (lh *LogsHandler) ViewAgentLogs(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 }
 
+// View the logs with findings of the rules with the metadata from the query parameters
+// The query parameters are rule, cwe, owasp, cve, tag and the from and to unix timestamps
+func (lh *LogsHandler) ViewFindingsLogs(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.FindingsFilter{RuleId: query.Get("rule"), CWE: query.Get("cwe"), OWASP: query.Get("owasp"), CVE: query.Get("cve"), Tag: query.Get("tag")}
+
+	//Parse the time interval of the logs
+	var err error
+	if from := query.Get("from"); from != "" {
+		filter.From, err = strconv.ParseInt(from, 10, 64)
+	}
+	if to := query.Get("to"); err == nil && to != "" {
+		filter.To, err = strconv.ParseInt(to, 10, 64)
+	}
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		apiErr := models.CranberryAPIError{Detail: "from and to should be unix timestamps"}
+		apiErr.ToJSON(rw)
+		return
+	}
+
+	logs, err := lh.osConn.GetFindingsLogs(filter)
+	if err != nil {
+		lh.logger.Error("Failed to get the logs by findings from OpenSearch database", err.Error())
+		rw.WriteHeader(http.StatusInternalServerError)
+		cApiErr := models.CranberryAPIError{Detail: "Failed to get logs"}
+		cApiErr.ToJSON(rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	logs.ToJSON(rw)
+}
+
 // Statistics
 func (lh *LogsHandler) ViewMethodsCount(rw http.ResponseWriter, r *http.Request) {
 	stats, err := lh.osConn.GetMethodsCount()
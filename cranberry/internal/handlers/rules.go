@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"cranberry/internal/config"
+	"cranberry/internal/database"
+	"cranberry/internal/logging"
+	"cranberry/internal/models"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Structure that holds data used by the rules handler
+type RulesHandler struct {
+	logger        logging.ILogger
+	configuration config.Configuration
+	sqlDb         *database.MysqlConnection
+	osConn        *database.OpensearchConnection
+}
+
+func NewRulesHandler(logger logging.ILogger, configuration config.Configuration, sqlDb *database.MysqlConnection, osConn *database.OpensearchConnection) *RulesHandler {
+	return &RulesHandler{logger: logger, configuration: configuration, sqlDb: sqlDb, osConn: osConn}
+}
+
+// Receives the outcome of a rules reload from an agent
+func (rh *RulesHandler) InsertRulesReload(rw http.ResponseWriter, r *http.Request) {
+	//Get the agent uuid from the URL
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	//Parse the JSON body
+	reloadData := models.RulesReloadData{}
+	err := reloadData.FromJSON(r.Body)
+	if err != nil {
+		rh.logger.Error("Failed to parse rules reload data from body of request", err.Error())
+		rw.WriteHeader(http.StatusBadRequest)
+		cApiErr := models.CranberryAPIError{Detail: "Failed to parse body from JSON"}
+		cApiErr.ToJSON(rw)
+		return
+	}
+	//The agent id is taken from the URL
+	reloadData.AgentId = uuid
+
+	if !reloadData.Success {
+		rh.logger.Warning("Rules reload failed on agent", uuid, reloadData.Errors)
+	}
+
+	//Insert the reload in the opensearch database
+	err = rh.osConn.InsertRulesReload(reloadData)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		cApiErr := models.CranberryAPIError{Detail: "Failed to insert rules reload"}
+		cApiErr.ToJSON(rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// Receives the evaluation statistics of the rules from an agent
+func (rh *RulesHandler) InsertRulesProfile(rw http.ResponseWriter, r *http.Request) {
+	//Get the agent uuid from the URL
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	//Parse the JSON body
+	profileData := models.RulesProfileData{}
+	err := profileData.FromJSON(r.Body)
+	if err != nil {
+		rh.logger.Error("Failed to parse rules profile data from body of request", err.Error())
+		rw.WriteHeader(http.StatusBadRequest)
+		cApiErr := models.CranberryAPIError{Detail: "Failed to parse body from JSON"}
+		cApiErr.ToJSON(rw)
+		return
+	}
+	//The agent id is taken from the URL
+	profileData.AgentId = uuid
+
+	//Log the rules which were flagged as slow
+	for _, rule := range profileData.Rules {
+		if rule.Disabled {
+			rh.logger.Warning("Rule", rule.RuleId, "was disabled on agent", uuid, "for services", rule.DisabledServices, "because it is slow, p99", rule.P99, "ms")
+		} else if rule.Slow {
+			rh.logger.Warning("Rule", rule.RuleId, "is slow on agent", uuid, "p99", rule.P99, "ms")
+		}
+	}
+
+	//Insert the profile in the opensearch database
+	err = rh.osConn.InsertRulesProfile(profileData)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		cApiErr := models.CranberryAPIError{Detail: "Failed to insert rules profile"}
+		cApiErr.ToJSON(rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
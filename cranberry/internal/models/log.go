@@ -7,18 +7,57 @@ import (
 
 // This structure holds the log data that is sent to the api
 type LogData struct {
-	AgentId          string         `json:"agentId"`          //The UUID of the agent that collected the log data
-	RemoteIP         string         `json:"remoteIp"`         //The IP address of the sender of the request
-	Timestamp        int64          `json:"timestamp"`        //Timestamp when the request was received
-	Type             string         `json:"type"`             //The log type which can be http, websocket, tcp, udp (the same as the implemented handlers)
-	Request          string         `json:"request"`          //The request base64 encoded. this can be empty when the message is coming from backend server to the client
-	Response         string         `json:"response"`         //The response base64 encoded. this can be empty when the message is coming from client to backend server
-	RequestFindings  []*FindingData `json:"requestFindings"`  //The list of findings on the request
-	ResponseFindings []*FindingData `json:"responseFindings"` //The list of findings on the response
-	Verdict          string         `json:"verdict"`          //The action which was taken (drop/allow)
-	Direction        string         `json:"direction"`        //The direction of the data (ingress or egress)
-	StreamUUID       string         `json:"streamUUID"`       //The UUID of the stream
-	StreamIndex      int64          `json:"streamIndex"`      //The index of the stream (used by the websocket,tcp and udp proxies)
+	AgentId                   string                      `json:"agentId"`                   //The UUID of the agent that collected the log data
+	RemoteIP                  string                      `json:"remoteIp"`                  //The IP address of the sender of the request
+	Timestamp                 int64                       `json:"timestamp"`                 //Timestamp when the request was received
+	Type                      string                      `json:"type"`                      //The log type which can be http, websocket, tcp, udp (the same as the implemented handlers)
+	Request                   string                      `json:"request"`                   //The request base64 encoded. this can be empty when the message is coming from backend server to the client
+	Response                  string                      `json:"response"`                  //The response base64 encoded. this can be empty when the message is coming from client to backend server
+	RequestFindings           []*FindingData              `json:"requestFindings"`           //The list of findings on the request
+	ResponseFindings          []*FindingData              `json:"responseFindings"`          //The list of findings on the response
+	Verdict                   string                      `json:"verdict"`                   //The action which was taken (drop/allow)
+	Direction                 string                      `json:"direction"`                 //The direction of the data (ingress or egress)
+	StreamUUID                string                      `json:"streamUUID"`                //The UUID of the stream
+	StreamIndex               int64                       `json:"streamIndex"`               //The index of the stream (used by the websocket,tcp and udp proxies)
+	AnomalyScore              int64                       `json:"anomalyScore"`              //The total anomaly score of the findings
+	AnomalyScoreContributions []*AnomalyScoreContribution `json:"anomalyScoreContributions"` //The contribution of each rule to the anomaly score
+	UpstreamResponseTime      float64                     `json:"upstreamResponseTime"`      //The time the upstream took to send the whole response in milliseconds (only for http)
+	UpstreamTimeToFirstByte   float64                     `json:"upstreamTimeToFirstByte"`   //The time the upstream took to send the first byte of the response in milliseconds (only for http)
+	Suppressions              []*SuppressionData          `json:"suppressions"`              //The rule findings suppressed by the exclusions of the allow rules
+	AllowedBy                 string                      `json:"allowedBy"`                 //The id of the allow rule which stopped the evaluation of the remaining rules (empty if no such rule matched)
+	RulesBudgetExceeded       bool                        `json:"rulesBudgetExceeded"`       //If some rules were skipped because the evaluation exceeded the time budget
+	RequestBodyTruncated      bool                        `json:"requestBodyTruncated"`      //If the request body was larger than the inspection limit, only the first bytes were inspected and logged
+	ResponseBodyTruncated     bool                        `json:"responseBodyTruncated"`     //If the response body was larger than the inspection limit, only the first bytes were inspected and logged
+	Upstream                  string                      `json:"upstream"`                  //The URL of the upstream target the traffic was forwarded to
+	UpstreamAttempts          int64                       `json:"upstreamAttempts"`          //The number of times the request was sent to the upstream targets (only for http)
+	UpstreamFailures          []*UpstreamFailureData      `json:"upstreamFailures"`          //The failed attempts to forward the request, with the failure reason
+}
+
+// This structure holds the contribution of a rule to the anomaly score
+type AnomalyScoreContribution struct {
+	RuleId    string `json:"ruleId"`    //The id of the rule
+	Direction string `json:"direction"` //The direction of the data the findings were on (ingress or egress)
+	Severity  int64  `json:"severity"`  //The severity of the rule
+	Findings  int64  `json:"findings"`  //The number of findings of the rule
+	Score     int64  `json:"score"`     //The score added by the findings of the rule
+}
+
+// This structure holds the findings of a rule suppressed by an allow rule
+type SuppressionData struct {
+	RuleId       string   `json:"ruleId"`       //The id of the suppressed rule
+	SuppressedBy string   `json:"suppressedBy"` //The id of the allow rule which suppressed the findings
+	Direction    string   `json:"direction"`    //The direction of the data the findings were on (ingress or egress)
+	Parameters   []string `json:"parameters"`   //The parameters the suppressed matches were found on (empty if all the findings of the rule were suppressed)
+	Matches      int64    `json:"matches"`      //The number of suppressed matches
+}
+
+// This structure holds a failed attempt to forward a request to an upstream target
+type UpstreamFailureData struct {
+	Upstream   string `json:"upstream"`   //The URL of the upstream target (empty if no target was available)
+	Reason     string `json:"reason"`     //The reason of the failure (timeout, connection_error, upstream_status or no_available_upstream)
+	Message    string `json:"message"`    //The error message of the failure
+	StatusCode int64  `json:"statusCode"` //The status code received from the target or sent to the client
+	Retried    bool   `json:"retried"`    //If the request was sent again after the failure
 }
 
 // Convert json data to LogData structure
@@ -41,6 +80,24 @@ type ExtendedLogData struct {
 	HTTPRequestURL      string `json:"httpRequestURL"`
 	HTTPResponseVersion string `json:"httpResponseVersion"`
 	HTTPResponseCode    string `json:"httpResponseCode"`
+	//Fields with the metadata of the rules from the request and the response findings (used to search the logs)
+	RuleIds  []string `json:"ruleIds"`
+	CWE      []string `json:"cwe"`
+	OWASP    []string `json:"owasp"`
+	CVE      []string `json:"cve"`
+	RuleTags []string `json:"ruleTags"`
+}
+
+// This structure holds the filters used to search the logs by the metadata of the rules from the findings
+// The logs should match all the specified filters, the empty filters are ignored
+type FindingsFilter struct {
+	RuleId string //The id of the rule
+	CWE    string //The CWE identifier (like CWE-22)
+	OWASP  string //The OWASP Top 10 category (like A03:2021)
+	CVE    string //The CVE identifier
+	Tag    string //The tag of the rule
+	From   int64  //The minimum timestamp of the logs (0 for no limit)
+	To     int64  //The maximum timestamp of the logs (0 for no limit)
 }
 
 type ViewExtendedLogData struct {
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// This structure holds the outcome of a rules reload on an agent
+type RulesReloadData struct {
+	AgentId            string   `json:"agentId"`            //The UUID of the agent that reloaded the rules
+	Timestamp          int64    `json:"timestamp"`          //Timestamp when the reload finished
+	Trigger            string   `json:"trigger"`            //What triggered the reload (sighup, watcher)
+	Success            bool     `json:"success"`            //If the new rule set is in use
+	PreviousRulesCount int64    `json:"previousRulesCount"` //The number of rules before the reload
+	RulesCount         int64    `json:"rulesCount"`         //The number of rules in use after the reload
+	Errors             []string `json:"errors"`             //The problems found in the rule files if the reload failed
+}
+
+// Convert json data to RulesReloadData structure
+func (rrd *RulesReloadData) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rrd)
+}
+
+// Convert RulesReloadData structure to json string
+func (rrd *RulesReloadData) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rrd)
+}
+
+// This structure holds the evaluation statistics of the rules that are sent to the api
+type RulesProfileData struct {
+	AgentId          string             `json:"agentId"`          //The UUID of the agent that evaluated the rules
+	Timestamp        int64              `json:"timestamp"`        //Timestamp when the report was created
+	Since            int64              `json:"since"`            //Timestamp when the statistics started to be collected (the start of the agent or the last rules reload)
+	HistogramBuckets []float64          `json:"histogramBuckets"` //The upper bounds of the latency histogram buckets in milliseconds, the last bucket has no upper bound
+	BudgetExceeded   int64              `json:"budgetExceeded"`   //The number of evaluations stopped because they exceeded the time budget
+	Rules            []*RuleProfileData `json:"rules"`            //The statistics of every evaluated rule, the slowest first
+}
+
+// This structure holds the evaluation statistics of a rule
+type RuleProfileData struct {
+	RuleId           string   `json:"ruleId"`           //The id of the rule
+	Evaluations      int64    `json:"evaluations"`      //The number of times the rule was evaluated
+	Matches          int64    `json:"matches"`          //The number of evaluations which produced findings
+	TotalTime        float64  `json:"totalTime"`        //The total evaluation time in milliseconds
+	AverageTime      float64  `json:"averageTime"`      //The average evaluation time in milliseconds
+	MaxTime          float64  `json:"maxTime"`          //The longest evaluation time in milliseconds
+	P50              float64  `json:"p50"`              //The median evaluation time in milliseconds (estimated from the histogram)
+	P90              float64  `json:"p90"`              //The 90th percentile of the evaluation time in milliseconds (estimated from the histogram)
+	P99              float64  `json:"p99"`              //The 99th percentile of the evaluation time in milliseconds (estimated from the histogram)
+	Histogram        []int64  `json:"histogram"`        //The number of evaluations in every latency bucket
+	Slow             bool     `json:"slow"`             //If the 99th percentile exceeded the slow rule threshold
+	Disabled         bool     `json:"disabled"`         //If the rule was disabled for at least one service because it is slow
+	DisabledServices []string `json:"disabledServices"` //The services the rule was disabled for because it is slow on them
+}
+
+// Convert json data to RulesProfileData structure
+func (rpd *RulesProfileData) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rpd)
+}
+
+// Convert RulesProfileData structure to json string
+func (rpd *RulesProfileData) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rpd)
+}
@@ -79,6 +79,7 @@ func (server *CranberryServer) Init() error {
 	agentsHandler := handlers.NewAgentsHandler(server.logger, server.configuration, server.sqlDb, server.osConn)
 	logsHandler := handlers.NewLogsHandler(server.logger, server.configuration, server.sqlDb, server.osConn)
 	streamsHandler := handlers.NewStreamsHandler(server.logger, server.configuration, server.sqlDb, server.osConn)
+	rulesHandler := handlers.NewRulesHandler(server.logger, server.configuration, server.sqlDb, server.osConn)
 
 	//Create the healthcheck route
 	r.HandleFunc("/api/v1/healthcheck", healthcheckHandler.Healthcheck)
@@ -99,6 +100,9 @@ func (server *CranberryServer) Init() error {
 	//Create the route that will retrieve the methods count for HTTP logs
 	apiGetSubrouter.HandleFunc("/logs/methods-stats", logsHandler.ViewMethodsCount)
 
+	//Create the route that will retrieve the logs by the metadata of the rules from the findings (like the CWE)
+	apiGetSubrouter.HandleFunc("/logs/findings", logsHandler.ViewFindingsLogs)
+
 	//Create the route that will retrieve a log by id
 	apiGetSubrouter.HandleFunc("/logs/{id}", logsHandler.ViewLog)
 
@@ -109,6 +113,10 @@ func (server *CranberryServer) Init() error {
 	apiPostSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/logs", logsHandler.InsertAgentLog)
 	apiGetSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/logs", logsHandler.ViewAgentLogs)
 
+	//Create the route that will receive the rules reloads from an agent
+	apiPostSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/rules/reloads", rulesHandler.InsertRulesReload)
+	apiPostSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/rules/profiles", rulesHandler.InsertRulesProfile)
+
 	server.srv = &http.Server{
 		Addr: server.configuration.ListeningAddress + ":" + server.configuration.ListeningPort,
 		// Good practice to set timeouts to avoid Slowloris attacks.
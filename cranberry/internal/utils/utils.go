@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"os"
+	"slices"
 )
 
 // Check if the filepath is valid and exists on the disk
@@ -50,3 +51,13 @@ func ReadLinesFromFile(filePath string) ([]string, error) {
 	//Return the lines
 	return lines, nil
 }
+
+// Append the values which are not already in the slice
+func AppendUnique(values []string, newValues ...string) []string {
+	for _, value := range newValues {
+		if value != "" && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -9,5 +9,17 @@ type FindingData = {
     matchedBodyHash: string,
     matchedBodyHashAlg: string,
     classification: string,
-    severity: number
+    severity: number,
+    decodingChain?: string[],
+    target?: string,
+    offset?: number,
+    evidence?: string,
+    phase?: string,
+    references?: string[],
+    cwe?: string[],
+    owasp?: string[],
+    cve?: string[],
+    tags?: string[],
+    ruleAuthor?: string,
+    ruleVersion?: string
 }
\ No newline at end of file
@@ -1,3 +1,27 @@
+type AnomalyScoreContribution = {
+    ruleId: string,
+    direction: string,
+    severity: number,
+    findings: number,
+    score: number
+}
+
+type SuppressionData = {
+    ruleId: string,
+    suppressedBy: string,
+    direction: string,
+    parameters?: string[],
+    matches: number
+}
+
+type UpstreamFailureData = {
+    upstream: string,
+    reason: string,
+    message: string,
+    statusCode: number,
+    retried: boolean
+}
+
 type ViewExtendedLogData = {
     id: string,
     agentId: string,
@@ -12,11 +36,28 @@ type ViewExtendedLogData = {
     direction: string,
     streamUUID: string,
     streamIndex: number,
+    anomalyScore?: number,
+    anomalyScoreContributions?: AnomalyScoreContribution[],
+    suppressions?: SuppressionData[],
+    allowedBy?: string,
+    rulesBudgetExceeded?: boolean,
+    requestBodyTruncated?: boolean,
+    responseBodyTruncated?: boolean,
+    upstream?: string,
+    upstreamAttempts?: number,
+    upstreamFailures?: UpstreamFailureData[],
+    ruleIds?: string[],
+    cwe?: string[],
+    owasp?: string[],
+    cve?: string[],
+    ruleTags?: string[],
 
     //Fields for HTTP type of log (this can be empty)
     httpMethod: string,
     httpRequestVersion: string,
     httpRequestURL: string,
     httpResponseVersion: string,
-    httpResponseCode: string
+    httpResponseCode: string,
+    upstreamResponseTime?: number,
+    upstreamTimeToFirstByte?: number
 }
\ No newline at end of file